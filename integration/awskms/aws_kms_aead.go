@@ -18,9 +18,11 @@
 package awskms
 
 import (
+	"context"
 	"encoding/hex"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 )
@@ -28,12 +30,42 @@ import (
 // AWSAEAD is an implementation of the AEAD interface which performs
 // cryptographic operations remotely via the AWS KMS service using a specific
 // key URI.
+//
+// In addition to the methods of the AEAD interface, AWSAEAD provides
+// context-aware variants which allow callers to cancel KMS requests, set
+// deadlines on them and pass AWS SDK request options, and variants which take
+// an encryption context of key-value pairs instead of associated data, see
+// [AWSAEAD.EncryptWithEncryptionContext].
+//
+// AWSAEADs for multi-region keys registered with [WithMultiRegionKeyReplicas]
+// fail over to the other replicas of the key when a request fails.
+//
+// When a request to AWS KMS fails, the returned error is a [*KMSError], which
+// can be matched against [ErrInvalidCiphertext] and the other KMS errors.
 type AWSAEAD struct {
 	keyURI                string
 	kms                   kmsiface.KMSAPI
 	encryptionContextName EncryptionContextName
+	// replicas are the replicas of a multi-region key, in the order in which
+	// they are tried. It is empty for other keys.
+	replicas []awsReplica
+	// fallback is set by [WithEncryptionContextNameFallback].
+	fallback *encryptionContextFallback
+	// staticContext are the encryption context pairs set by
+	// [WithEncryptionContext] and [WithAEADEncryptionContext].
+	staticContext map[string]string
+	// grantTokens are set by [WithGrantTokens] and [WithAEADGrantTokens].
+	grantTokens []string
 }
 
+// AEADOption is an interface for defining options that are passed to
+// [Client.GetAEADWithOptions].
+type AEADOption interface{ setAEAD(*AWSAEAD) error }
+
+type aeadOption func(*AWSAEAD) error
+
+func (o aeadOption) setAEAD(a *AWSAEAD) error { return o(a) }
+
 // newAWSAEAD returns a new AWSAEAD instance.
 //
 // keyURI must have the following format:
@@ -49,36 +81,142 @@ func newAWSAEAD(keyURI string, kms kmsiface.KMSAPI, name EncryptionContextName)
 	}
 }
 
+// KeyID returns the key identifier a uses in AWS KMS requests.
+//
+// If a was created by a client with [WithAliasResolution] for a key URI of an
+// alias, this is the ARN of the key the alias referred to.
+func (a *AWSAEAD) KeyID() string {
+	return a.keyURI
+}
+
 // Encrypt encrypts the plaintext with associatedData.
 func (a *AWSAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
-	req := &kms.EncryptInput{
-		KeyId:     aws.String(a.keyURI),
-		Plaintext: plaintext,
+	if len(a.replicas) > 0 {
+		return a.EncryptWithContext(context.Background(), plaintext, associatedData)
 	}
-	if len(associatedData) > 0 {
-		ad := hex.EncodeToString(associatedData)
-		req.EncryptionContext = map[string]*string{a.encryptionContextName.String(): &ad}
-	}
-	resp, err := a.kms.Encrypt(req)
+	resp, err := a.kms.Encrypt(a.encryptInput(plaintext, associatedData))
 	if err != nil {
 		return nil, err
 	}
 	return resp.CiphertextBlob, nil
 }
 
+// EncryptWithContext encrypts the plaintext with associatedData.
+//
+// ctx is used for the KMS request, and opts are applied to it. For example,
+// request.WithResponseReadTimeout can be used to set a per-call timeout.
+func (a *AWSAEAD) EncryptWithContext(ctx context.Context, plaintext, associatedData []byte, opts ...request.Option) ([]byte, error) {
+	return a.encrypt(ctx, a.encryptInput(plaintext, associatedData), opts...)
+}
+
+// encrypt sends req with the key of each replica in turn, see withFailover.
+func (a *AWSAEAD) encrypt(ctx context.Context, req *kms.EncryptInput, opts ...request.Option) ([]byte, error) {
+	var ciphertext []byte
+	err := a.withFailover(ctx, func(keyURI string, k kmsiface.KMSAPI) error {
+		req.KeyId = aws.String(keyURI)
+		resp, err := k.EncryptWithContext(ctx, req, opts...)
+		if err != nil {
+			return err
+		}
+		ciphertext = resp.CiphertextBlob
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ciphertext, nil
+}
+
 // Decrypt decrypts the ciphertext and verifies the associated data.
 func (a *AWSAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	if len(a.replicas) > 0 || a.fallback != nil {
+		return a.DecryptWithContext(context.Background(), ciphertext, associatedData)
+	}
+	resp, err := a.kms.Decrypt(a.decryptInput(a.encryptionContextName, ciphertext, associatedData))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// DecryptWithContext decrypts the ciphertext and verifies the associated data.
+//
+// ctx is used for the KMS request, and opts are applied to it. For example,
+// request.WithResponseReadTimeout can be used to set a per-call timeout.
+func (a *AWSAEAD) DecryptWithContext(ctx context.Context, ciphertext, associatedData []byte, opts ...request.Option) ([]byte, error) {
+	plaintext, _, err := a.DecryptAndReportName(ctx, ciphertext, associatedData, opts...)
+	return plaintext, err
+}
+
+// decrypt sends req with the key of each replica in turn, see withFailover.
+func (a *AWSAEAD) decrypt(ctx context.Context, req *kms.DecryptInput, opts ...request.Option) ([]byte, error) {
+	var plaintext []byte
+	err := a.withFailover(ctx, func(keyURI string, k kmsiface.KMSAPI) error {
+		req.KeyId = aws.String(keyURI)
+		resp, err := k.DecryptWithContext(ctx, req, opts...)
+		if err != nil {
+			return err
+		}
+		plaintext = resp.Plaintext
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// withFailover calls op with the key URI and KMS client of each replica in
+// turn, until a call succeeds or fails with an error which should not fail
+// over. It returns the error of the last call.
+//
+// For keys without replicas, op is called once with the key of a.
+func (a *AWSAEAD) withFailover(ctx context.Context, op func(keyURI string, k kmsiface.KMSAPI) error) error {
+	if len(a.replicas) == 0 {
+		return op(a.keyURI, a.kms)
+	}
+	var err error
+	for _, r := range a.replicas {
+		if err = op(r.keyURI, r.kms); err == nil || !shouldFailOver(ctx, err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (a *AWSAEAD) encryptInput(plaintext, associatedData []byte) *kms.EncryptInput {
+	req := &kms.EncryptInput{
+		KeyId:       aws.String(a.keyURI),
+		Plaintext:   plaintext,
+		GrantTokens: kmsGrantTokens(a.grantTokens),
+	}
+	req.EncryptionContext = encryptionContext(a.staticContext, a.encryptionContextName, associatedData)
+	return req
+}
+
+func (a *AWSAEAD) decryptInput(name EncryptionContextName, ciphertext, associatedData []byte) *kms.DecryptInput {
 	req := &kms.DecryptInput{
 		KeyId:          aws.String(a.keyURI),
 		CiphertextBlob: ciphertext,
+		GrantTokens:    kmsGrantTokens(a.grantTokens),
 	}
-	if len(associatedData) > 0 {
-		ad := hex.EncodeToString(associatedData)
-		req.EncryptionContext = map[string]*string{a.encryptionContextName.String(): &ad}
+	req.EncryptionContext = encryptionContext(a.staticContext, name, associatedData)
+	return req
+}
+
+// encryptionContext returns the encryption context with the pairs of
+// staticContext and associatedData with the name name, or nil if both are
+// empty.
+func encryptionContext(staticContext map[string]string, name EncryptionContextName, associatedData []byte) map[string]*string {
+	if len(staticContext) == 0 && len(associatedData) == 0 {
+		return nil
 	}
-	resp, err := a.kms.Decrypt(req)
-	if err != nil {
-		return nil, err
+	ec := make(map[string]*string, len(staticContext)+1)
+	for k, v := range staticContext {
+		ec[k] = aws.String(v)
 	}
-	return resp.Plaintext, nil
+	if len(associatedData) > 0 {
+		ec[name.String()] = aws.String(hex.EncodeToString(associatedData))
+	}
+	return ec
 }
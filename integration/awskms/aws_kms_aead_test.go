@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// optionCountingKMS records the number of request options passed to the
+// context-aware KMS operations.
+type optionCountingKMS struct {
+	kmsiface.KMSAPI
+	encryptOpts int
+	decryptOpts int
+}
+
+func (o *optionCountingKMS) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	o.encryptOpts = len(opts)
+	return o.KMSAPI.EncryptWithContext(ctx, input, opts...)
+}
+
+func (o *optionCountingKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	o.decryptOpts = len(opts)
+	return o.KMSAPI.DecryptWithContext(ctx, input, opts...)
+}
+
+func TestAWSAEAD_EncryptDecryptWithContext(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-2:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	fakekms, err := fakeawskms.New([]string{keyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	k := &optionCountingKMS{KMSAPI: fakekms}
+	a := newAWSAEAD(keyARN, k, AssociatedData)
+
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associatedData")
+	timeout := request.WithResponseReadTimeout(time.Second)
+	ciphertext, err := a.EncryptWithContext(context.Background(), plaintext, associatedData, timeout)
+	if err != nil {
+		t.Fatalf("a.EncryptWithContext(ctx, plaintext, associatedData, timeout) err = %v, want nil", err)
+	}
+	if k.encryptOpts != 1 {
+		t.Errorf("number of Encrypt request options = %d, want 1", k.encryptOpts)
+	}
+	decrypted, err := a.DecryptWithContext(context.Background(), ciphertext, associatedData, timeout)
+	if err != nil {
+		t.Fatalf("a.DecryptWithContext(ctx, ciphertext, associatedData, timeout) err = %v, want nil", err)
+	}
+	if k.decryptOpts != 1 {
+		t.Errorf("number of Decrypt request options = %d, want 1", k.decryptOpts)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+
+	// Ciphertexts are interchangeable with the plain AEAD methods.
+	decrypted, err = a.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Decrypt(ciphertext, associatedData) err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestAWSAEAD_CanceledContextFails(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-2:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	fakekms, err := fakeawskms.New([]string{keyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a := newAWSAEAD(keyARN, fakekms, AssociatedData)
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.EncryptWithContext(ctx, []byte("plaintext"), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("a.EncryptWithContext(canceledCtx, ...) err = %v, want %v", err, context.Canceled)
+	}
+	if _, err := a.DecryptWithContext(ctx, ciphertext, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("a.DecryptWithContext(canceledCtx, ...) err = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// DefaultBatchConcurrency is the number of concurrent requests made by
+// [AWSAEAD.EncryptBatch] and [AWSAEAD.DecryptBatch] when no concurrency is
+// given.
+const DefaultBatchConcurrency = 8
+
+// BatchItem is an input of [AWSAEAD.EncryptBatch] or [AWSAEAD.DecryptBatch].
+type BatchItem struct {
+	// Data is the plaintext to encrypt or the ciphertext to decrypt.
+	Data []byte
+	// AssociatedData is the associated data of Data.
+	AssociatedData []byte
+}
+
+// BatchResult is the result of a [BatchItem].
+type BatchResult struct {
+	// Data is the ciphertext or the plaintext of the item, or nil if Err is
+	// set.
+	Data []byte
+	// Err is the error of the item, or nil if it succeeded.
+	Err error
+}
+
+// EncryptBatch encrypts the plaintexts of items with their associated data,
+// making up to concurrency requests at a time, or [DefaultBatchConcurrency] if
+// concurrency is not positive.
+//
+// It returns a result per item, in the order of items. When ctx is done, no
+// more requests are made and the items which were not encrypted yet fail with
+// the error of ctx. opts are applied to each request.
+func (a *AWSAEAD) EncryptBatch(ctx context.Context, items []BatchItem, concurrency int, opts ...request.Option) []BatchResult {
+	return runBatch(ctx, items, concurrency, func(item BatchItem) ([]byte, error) {
+		return a.EncryptWithContext(ctx, item.Data, item.AssociatedData, opts...)
+	})
+}
+
+// DecryptBatch decrypts the ciphertexts of items and verifies their associated
+// data, making up to concurrency requests at a time, or
+// [DefaultBatchConcurrency] if concurrency is not positive.
+//
+// It returns a result per item, in the order of items. When ctx is done, no
+// more requests are made and the items which were not decrypted yet fail with
+// the error of ctx. opts are applied to each request.
+func (a *AWSAEAD) DecryptBatch(ctx context.Context, items []BatchItem, concurrency int, opts ...request.Option) []BatchResult {
+	return runBatch(ctx, items, concurrency, func(item BatchItem) ([]byte, error) {
+		return a.DecryptWithContext(ctx, item.Data, item.AssociatedData, opts...)
+	})
+}
+
+// runBatch calls op for each item, with up to concurrency concurrent calls,
+// until ctx is done.
+func runBatch[T any](ctx context.Context, items []T, concurrency int, op func(T) ([]byte, error)) []BatchResult {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	results := make([]BatchResult, len(items))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		if ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case sem <- struct{}{}:
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(items); j++ {
+				results[j].Err = err
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int, item T) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i].Data, results[i].Err = op(item)
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+func batchItems(n int) []BatchItem {
+	items := make([]BatchItem, n)
+	for i := range items {
+		items[i] = BatchItem{
+			Data:           []byte(fmt.Sprintf("plaintext %d", i)),
+			AssociatedData: []byte(fmt.Sprintf("associated data %d", i)),
+		}
+	}
+	return items
+}
+
+func TestEncryptAndDecryptBatch(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	items := batchItems(20)
+	encrypted := a.EncryptBatch(context.Background(), items, 4)
+	if len(encrypted) != len(items) {
+		t.Fatalf("len(a.EncryptBatch()) = %d, want %d", len(encrypted), len(items))
+	}
+	ciphertexts := make([]BatchItem, len(items))
+	for i, r := range encrypted {
+		if r.Err != nil {
+			t.Fatalf("a.EncryptBatch()[%d].Err = %v, want nil", i, r.Err)
+		}
+		ciphertexts[i] = BatchItem{Data: r.Data, AssociatedData: items[i].AssociatedData}
+	}
+	decrypted := a.DecryptBatch(context.Background(), ciphertexts, 4)
+	for i, r := range decrypted {
+		if r.Err != nil {
+			t.Fatalf("a.DecryptBatch()[%d].Err = %v, want nil", i, r.Err)
+		}
+		if !bytes.Equal(r.Data, items[i].Data) {
+			t.Errorf("a.DecryptBatch()[%d].Data = %q, want %q", i, r.Data, items[i].Data)
+		}
+	}
+}
+
+func TestDecryptBatchReturnsErrorPerItem(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	items := batchItems(3)
+	encrypted := a.EncryptBatch(context.Background(), items, 0)
+	ciphertexts := []BatchItem{
+		{Data: encrypted[0].Data, AssociatedData: items[0].AssociatedData},
+		{Data: encrypted[1].Data, AssociatedData: []byte("wrong associated data")},
+		{Data: encrypted[2].Data, AssociatedData: items[2].AssociatedData},
+	}
+	results := a.DecryptBatch(context.Background(), ciphertexts, 0)
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("a.DecryptBatch() errors = %v, %v, want nil", results[0].Err, results[2].Err)
+	}
+	if !errors.Is(results[1].Err, ErrInvalidCiphertext) {
+		t.Errorf("a.DecryptBatch()[1].Err = %v, want %v", results[1].Err, ErrInvalidCiphertext)
+	}
+}
+
+func TestEncryptBatchLimitsConcurrency(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	slow := &slowKMS{KMSAPI: fakekms, delay: 10 * time.Millisecond}
+	a, err := newTestClient(t, slow).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	for i, r := range a.EncryptBatch(context.Background(), batchItems(12), 3) {
+		if r.Err != nil {
+			t.Fatalf("a.EncryptBatch()[%d].Err = %v, want nil", i, r.Err)
+		}
+	}
+	if slow.maxInFlight > 3 {
+		t.Errorf("maximum number of concurrent requests = %d, want at most 3", slow.maxInFlight)
+	}
+}
+
+func TestEncryptBatchStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var requests atomic.Int32
+	cancelAfterFirst := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		requests.Add(1)
+		err := invoke(ctx, call)
+		cancel()
+		return err
+	}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithInterceptors(cancelAfterFirst)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	results := a.EncryptBatch(ctx, batchItems(10), 1)
+	if results[0].Err != nil {
+		t.Errorf("a.EncryptBatch()[0].Err = %v, want nil", results[0].Err)
+	}
+	for i, r := range results[1:] {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("a.EncryptBatch()[%d].Err = %v, want %v", i+1, r.Err, context.Canceled)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("number of requests = %d, want 1", got)
+	}
+}
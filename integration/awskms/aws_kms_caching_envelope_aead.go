@@ -0,0 +1,460 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+const (
+	defaultMaxDataKeyAge       = 5 * time.Minute
+	defaultMaxBytesPerKey      = 1 << 40
+	defaultDecryptionCacheSize = 1000
+	// maxMessagesPerDataKey is the number of messages which can safely be
+	// encrypted with one AES-GCM key and random IVs. It is also the default.
+	maxMessagesPerDataKey = 1 << 32
+)
+
+// cacheConfig holds the limits of a [CachingEnvelopeAEAD].
+type cacheConfig struct {
+	maxAge              time.Duration
+	maxMessages         uint64
+	maxBytes            uint64
+	decryptionCacheSize int
+}
+
+// CacheOption is an interface for defining options that are passed to
+// [Client.GetCachingEnvelopeAEAD].
+type CacheOption interface{ setCache(*cacheConfig) error }
+
+type cacheOption func(*cacheConfig) error
+
+func (o cacheOption) setCache(c *cacheConfig) error { return o(c) }
+
+// WithMaxDataKeyAge sets how long a data key is used for after it was
+// generated, and how long unwrapped data keys are kept in the decryption
+// cache. The default is 5 minutes.
+func WithMaxDataKeyAge(maxAge time.Duration) CacheOption {
+	return cacheOption(func(c *cacheConfig) error {
+		if maxAge <= 0 {
+			return fmt.Errorf("max data key age must be positive, got %v", maxAge)
+		}
+		c.maxAge = maxAge
+		return nil
+	})
+}
+
+// WithMaxMessagesPerDataKey sets the number of messages encrypted with a data
+// key before a new one is generated. It must be at most 2^32, which is the
+// default.
+func WithMaxMessagesPerDataKey(maxMessages uint64) CacheOption {
+	return cacheOption(func(c *cacheConfig) error {
+		if maxMessages == 0 || maxMessages > maxMessagesPerDataKey {
+			return fmt.Errorf("max messages per data key must be in [1, 2^32], got %d", maxMessages)
+		}
+		c.maxMessages = maxMessages
+		return nil
+	})
+}
+
+// WithMaxBytesPerDataKey sets the number of plaintext bytes encrypted with a
+// data key before a new one is generated. The default is 2^40.
+//
+// Plaintexts larger than maxBytes are encrypted with a data key of their own,
+// which is not cached.
+func WithMaxBytesPerDataKey(maxBytes uint64) CacheOption {
+	return cacheOption(func(c *cacheConfig) error {
+		if maxBytes == 0 {
+			return errors.New("max bytes per data key must be positive")
+		}
+		c.maxBytes = maxBytes
+		return nil
+	})
+}
+
+// WithDecryptionCacheSize sets the number of unwrapped data keys kept for
+// decryption. The least recently used key is evicted when the cache is full.
+// The default is 1000.
+func WithDecryptionCacheSize(size int) CacheOption {
+	return cacheOption(func(c *cacheConfig) error {
+		if size <= 0 {
+			return fmt.Errorf("decryption cache size must be positive, got %d", size)
+		}
+		c.decryptionCacheSize = size
+		return nil
+	})
+}
+
+// cachedDataKey is a data key and its usage.
+type cachedDataKey struct {
+	dataKey          []byte
+	encryptedDataKey []byte
+	created          time.Time
+	messages         uint64
+	bytes            uint64
+}
+
+// CachingEnvelopeAEAD is an implementation of the AEAD interface which
+// performs envelope encryption like [EnvelopeAEAD], but reuses data keys to
+// reduce the number of AWS KMS requests.
+//
+// A data key generated via the AWS KMS GenerateDataKey API is used to encrypt
+// messages until it reaches its maximum age, number of messages or number of
+// bytes, see [CacheOption]. Unwrapped data keys are kept in a least recently
+// used cache keyed by the encrypted data key, so that decrypting ciphertexts
+// which share a data key calls AWS KMS once. Data keys are zeroized when they
+// are evicted, and at the latest shortly after their maximum age, even if the
+// AEAD is not used anymore.
+//
+// Ciphertexts have the same format as those of [EnvelopeAEAD], and can be
+// decrypted by either.
+//
+// CachingEnvelopeAEAD is safe for concurrent use.
+type CachingEnvelopeAEAD struct {
+	envelope *EnvelopeAEAD
+	config   cacheConfig
+	now      func() time.Time
+
+	mu      sync.Mutex
+	current *cachedDataKey
+	// generating is the generation of a new data key in flight, or nil.
+	generating *dataKeyGeneration
+	decryption *dataKeyCache
+	// expiry zeroizes the data keys which have expired when it fires, at
+	// expiresAt. It is nil when no data key is cached.
+	expiry    *time.Timer
+	expiresAt time.Time
+}
+
+// dataKeyGeneration is a GenerateDataKey request in flight. The lock of the
+// CachingEnvelopeAEAD is not held during the request, so that messages are
+// decrypted with cached data keys meanwhile. Encryptions which need the new
+// data key wait for done to be closed.
+type dataKeyGeneration struct {
+	done chan struct{}
+	// discard is set when the cache is cleared during the request, so that
+	// the generated data key is not used.
+	discard bool
+}
+
+var _ tink.AEAD = (*CachingEnvelopeAEAD)(nil)
+
+// Encrypt encrypts the plaintext with associatedData.
+func (c *CachingEnvelopeAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	return c.EncryptWithContext(context.Background(), plaintext, associatedData)
+}
+
+// EncryptWithContext encrypts the plaintext with associatedData.
+//
+// ctx is used for the GenerateDataKey request if a new data key is needed,
+// and opts are applied to it.
+func (c *CachingEnvelopeAEAD) EncryptWithContext(ctx context.Context, plaintext, associatedData []byte, opts ...request.Option) ([]byte, error) {
+	if uint64(len(plaintext)) > c.config.maxBytes {
+		return c.envelope.EncryptWithContext(ctx, plaintext, associatedData, opts...)
+	}
+	dataKey, encryptedDataKey, err := c.dataKeyForEncryption(ctx, uint64(len(plaintext)), opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(dataKey)
+	return encryptEnvelope(dataKey, encryptedDataKey, plaintext, associatedData)
+}
+
+// dataKeyForEncryption accounts for a message of n bytes and returns a copy of
+// the data key to encrypt it with, generating a new one if needed.
+//
+// Only one data key is generated at a time. Concurrent calls which need a new
+// data key wait for it, or until their ctx is done.
+func (c *CachingEnvelopeAEAD) dataKeyForEncryption(ctx context.Context, n uint64, opts ...request.Option) ([]byte, []byte, error) {
+	for {
+		c.mu.Lock()
+		if k := c.current; k != nil {
+			if c.now().Sub(k.created) >= c.config.maxAge || k.messages >= c.config.maxMessages || k.bytes+n > c.config.maxBytes {
+				zeroize(k.dataKey)
+				c.current = nil
+			}
+		}
+		if k := c.current; k != nil {
+			k.messages++
+			k.bytes += n
+			dataKey, encryptedDataKey := append([]byte(nil), k.dataKey...), k.encryptedDataKey
+			c.mu.Unlock()
+			return dataKey, encryptedDataKey, nil
+		}
+		if g := c.generating; g != nil {
+			c.mu.Unlock()
+			select {
+			case <-g.done:
+				continue
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+		g := &dataKeyGeneration{done: make(chan struct{})}
+		c.generating = g
+		c.mu.Unlock()
+
+		if err := c.generateDataKey(ctx, g, opts...); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// generateDataKey generates a new data key and makes it the current one,
+// unless the cache was cleared meanwhile.
+func (c *CachingEnvelopeAEAD) generateDataKey(ctx context.Context, g *dataKeyGeneration, opts ...request.Option) error {
+	resp, err := c.envelope.generateDataKey(ctx, opts...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(g.done)
+	if c.generating == g {
+		c.generating = nil
+	}
+	if err != nil {
+		return err
+	}
+	if g.discard {
+		zeroize(resp.Plaintext)
+		return nil
+	}
+	now := c.now()
+	c.current = &cachedDataKey{
+		dataKey:          resp.Plaintext,
+		encryptedDataKey: resp.CiphertextBlob,
+		created:          now,
+	}
+	c.decryption.add(resp.CiphertextBlob, resp.Plaintext, now)
+	c.scheduleExpiry(now.Add(c.config.maxAge))
+	return nil
+}
+
+// scheduleExpiry makes sure that expireDataKeys runs at the latest at t. It
+// must be called with the lock held.
+func (c *CachingEnvelopeAEAD) scheduleExpiry(t time.Time) {
+	if c.expiry != nil {
+		if !t.Before(c.expiresAt) {
+			return
+		}
+		c.expiry.Stop()
+	}
+	c.expiresAt = t
+	c.expiry = time.AfterFunc(t.Sub(c.now()), func() { c.expireDataKeys(t) })
+}
+
+// expireDataKeys zeroizes and drops the data keys which have reached their
+// maximum age, and schedules itself again for the next one to expire. It does
+// nothing if the timer scheduled at t was replaced or stopped meanwhile.
+func (c *CachingEnvelopeAEAD) expireDataKeys(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expiry == nil || !c.expiresAt.Equal(t) {
+		return
+	}
+	c.expiry = nil
+	now := c.now()
+	var next time.Time
+	if k := c.current; k != nil {
+		if now.Sub(k.created) >= c.config.maxAge {
+			zeroize(k.dataKey)
+			c.current = nil
+		} else {
+			next = k.created.Add(c.config.maxAge)
+		}
+	}
+	if t := c.decryption.removeExpired(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+		next = t
+	}
+	if !next.IsZero() {
+		c.scheduleExpiry(next)
+	}
+}
+
+// Decrypt decrypts the ciphertext and verifies the associated data.
+func (c *CachingEnvelopeAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	return c.DecryptWithContext(context.Background(), ciphertext, associatedData)
+}
+
+// DecryptWithContext decrypts the ciphertext and verifies the associated data.
+//
+// ctx is used for the KMS Decrypt request if the data key is not cached, and
+// opts are applied to it.
+func (c *CachingEnvelopeAEAD) DecryptWithContext(ctx context.Context, ciphertext, associatedData []byte, opts ...request.Option) ([]byte, error) {
+	header, encryptedDataKey, payload, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	dataKey := c.decryption.get(encryptedDataKey, c.now())
+	c.mu.Unlock()
+	if dataKey == nil {
+		dataKey, err = c.envelope.decryptDataKey(ctx, encryptedDataKey, opts...)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		now := c.now()
+		c.decryption.add(encryptedDataKey, dataKey, now)
+		c.scheduleExpiry(now.Add(c.config.maxAge))
+		c.mu.Unlock()
+	}
+	defer zeroize(dataKey)
+	return decryptPayload(dataKey, header, payload, associatedData)
+}
+
+// ClearCache zeroizes and drops all cached data keys. The next encryption
+// generates a new data key.
+func (c *CachingEnvelopeAEAD) ClearCache() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current != nil {
+		zeroize(c.current.dataKey)
+		c.current = nil
+	}
+	if c.generating != nil {
+		c.generating.discard = true
+		c.generating = nil
+	}
+	c.decryption.clear()
+	if c.expiry != nil {
+		c.expiry.Stop()
+		c.expiry = nil
+	}
+}
+
+// dataKeyCache is a least recently used cache of unwrapped data keys, keyed by
+// encrypted data key. It is not safe for concurrent use.
+type dataKeyCache struct {
+	size    int
+	maxAge  time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type dataKeyCacheEntry struct {
+	encryptedDataKey string
+	dataKey          []byte
+	added            time.Time
+}
+
+func newDataKeyCache(size int, maxAge time.Duration) *dataKeyCache {
+	return &dataKeyCache{
+		size:    size,
+		maxAge:  maxAge,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// add stores a copy of dataKey, evicting the least recently used entry if the
+// cache is full.
+func (d *dataKeyCache) add(encryptedDataKey, dataKey []byte, now time.Time) {
+	if e, ok := d.entries[string(encryptedDataKey)]; ok {
+		d.remove(e)
+	}
+	for d.lru.Len() >= d.size {
+		d.remove(d.lru.Back())
+	}
+	entry := &dataKeyCacheEntry{
+		encryptedDataKey: string(encryptedDataKey),
+		dataKey:          append([]byte(nil), dataKey...),
+		added:            now,
+	}
+	d.entries[entry.encryptedDataKey] = d.lru.PushFront(entry)
+}
+
+// get returns a copy of the data key for encryptedDataKey, or nil if it is
+// not cached or has expired.
+func (d *dataKeyCache) get(encryptedDataKey []byte, now time.Time) []byte {
+	e, ok := d.entries[string(encryptedDataKey)]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*dataKeyCacheEntry)
+	if now.Sub(entry.added) >= d.maxAge {
+		d.remove(e)
+		return nil
+	}
+	d.lru.MoveToFront(e)
+	return append([]byte(nil), entry.dataKey...)
+}
+
+// removeExpired removes the entries which have expired at now, and returns
+// when the next of the others expires, or the zero time if there are none.
+func (d *dataKeyCache) removeExpired(now time.Time) time.Time {
+	var next time.Time
+	for e := d.lru.Front(); e != nil; {
+		entry, following := e.Value.(*dataKeyCacheEntry), e.Next()
+		if expiresAt := entry.added.Add(d.maxAge); !now.Before(expiresAt) {
+			d.remove(e)
+		} else if next.IsZero() || expiresAt.Before(next) {
+			next = expiresAt
+		}
+		e = following
+	}
+	return next
+}
+
+func (d *dataKeyCache) remove(e *list.Element) {
+	entry := d.lru.Remove(e).(*dataKeyCacheEntry)
+	delete(d.entries, entry.encryptedDataKey)
+	zeroize(entry.dataKey)
+}
+
+func (d *dataKeyCache) clear() {
+	for d.lru.Len() > 0 {
+		d.remove(d.lru.Back())
+	}
+}
+
+// GetCachingEnvelopeAEAD returns a [*CachingEnvelopeAEAD] which performs
+// envelope encryption with data keys generated by AWS KMS under the key
+// keyURI, reusing them within the limits set by opts.
+//
+// keyURI must be supported by this client and must have the same format as
+// for [Client.GetAEAD].
+func (c *Client) GetCachingEnvelopeAEAD(keyURI string, opts ...CacheOption) (*CachingEnvelopeAEAD, error) {
+	envelope, err := c.GetEnvelopeAEAD(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	config := cacheConfig{
+		maxAge:              defaultMaxDataKeyAge,
+		maxMessages:         maxMessagesPerDataKey,
+		maxBytes:            defaultMaxBytesPerKey,
+		decryptionCacheSize: defaultDecryptionCacheSize,
+	}
+	for _, opt := range opts {
+		if err := opt.setCache(&config); err != nil {
+			return nil, fmt.Errorf("failed setting option: %v", err)
+		}
+	}
+	return &CachingEnvelopeAEAD{
+		envelope:   envelope,
+		config:     config,
+		now:        time.Now,
+		decryption: newDataKeyCache(config.decryptionCacheSize, config.maxAge),
+	}, nil
+}
@@ -0,0 +1,495 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// requestCountingKMS counts GenerateDataKey and Decrypt requests.
+type requestCountingKMS struct {
+	kmsiface.KMSAPI
+	mu               sync.Mutex
+	generateRequests int
+	decryptRequests  int
+}
+
+func (r *requestCountingKMS) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	r.mu.Lock()
+	r.generateRequests++
+	r.mu.Unlock()
+	return r.KMSAPI.GenerateDataKeyWithContext(ctx, input, opts...)
+}
+
+func (r *requestCountingKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	r.mu.Lock()
+	r.decryptRequests++
+	r.mu.Unlock()
+	return r.KMSAPI.DecryptWithContext(ctx, input, opts...)
+}
+
+func newRequestCountingKMS(t *testing.T) *requestCountingKMS {
+	t.Helper()
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	return &requestCountingKMS{KMSAPI: fakekms}
+}
+
+func TestCachingEnvelopeAEADReusesDataKey(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	a, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	associatedData := []byte("associatedData")
+	var ciphertexts [][]byte
+	for i := 0; i < 10; i++ {
+		ciphertext, err := a.Encrypt([]byte(fmt.Sprintf("plaintext %d", i)), associatedData)
+		if err != nil {
+			t.Fatalf("a.Encrypt() err = %v, want nil", err)
+		}
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+	if countingKMS.generateRequests != 1 {
+		t.Errorf("generateRequests = %d, want 1", countingKMS.generateRequests)
+	}
+
+	// The encrypting AEAD already knows the data key.
+	for i, ciphertext := range ciphertexts {
+		decrypted, err := a.Decrypt(ciphertext, associatedData)
+		if err != nil {
+			t.Fatalf("a.Decrypt() err = %v, want nil", err)
+		}
+		if want := fmt.Sprintf("plaintext %d", i); string(decrypted) != want {
+			t.Errorf("decrypted = %q, want %q", decrypted, want)
+		}
+	}
+	if countingKMS.decryptRequests != 0 {
+		t.Errorf("decryptRequests = %d, want 0", countingKMS.decryptRequests)
+	}
+
+	other, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	for _, ciphertext := range ciphertexts {
+		if _, err := other.Decrypt(ciphertext, associatedData); err != nil {
+			t.Fatalf("other.Decrypt() err = %v, want nil", err)
+		}
+	}
+	if countingKMS.decryptRequests != 1 {
+		t.Errorf("decryptRequests = %d, want 1", countingKMS.decryptRequests)
+	}
+	if _, err := other.Decrypt(ciphertexts[0], []byte("invalidAssociatedData")); err == nil {
+		t.Error("other.Decrypt(ciphertext, invalidAssociatedData) err = nil, want error")
+	}
+}
+
+func TestCachingEnvelopeAEADIsCompatibleWithEnvelopeAEAD(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	caching, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	envelope, err := newTestClient(t, countingKMS).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	plaintext := []byte("plaintext")
+	ciphertext, err := caching.Encrypt(plaintext, nil)
+	if err != nil {
+		t.Fatalf("caching.Encrypt() err = %v, want nil", err)
+	}
+	if decrypted, err := envelope.Decrypt(ciphertext, nil); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("envelope.Decrypt() = %q, %v, want %q, nil", decrypted, err, plaintext)
+	}
+	ciphertext, err = envelope.Encrypt(plaintext, nil)
+	if err != nil {
+		t.Fatalf("envelope.Encrypt() err = %v, want nil", err)
+	}
+	if decrypted, err := caching.Decrypt(ciphertext, nil); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("caching.Decrypt() = %q, %v, want %q, nil", decrypted, err, plaintext)
+	}
+}
+
+func TestCachingEnvelopeAEADRotatesDataKey(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		opts         []CacheOption
+		plaintext    []byte
+		messages     int
+		wantGenerate int
+	}{
+		{"max messages", []CacheOption{WithMaxMessagesPerDataKey(3)}, []byte("plaintext"), 7, 3},
+		{"max bytes", []CacheOption{WithMaxBytesPerDataKey(10)}, []byte("123456"), 3, 3},
+		{"max bytes reached exactly", []CacheOption{WithMaxBytesPerDataKey(12)}, []byte("123456"), 3, 2},
+		{"plaintext larger than max bytes", []CacheOption{WithMaxBytesPerDataKey(4)}, []byte("123456"), 3, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			countingKMS := newRequestCountingKMS(t)
+			a, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI, tc.opts...)
+			if err != nil {
+				t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+			}
+			for i := 0; i < tc.messages; i++ {
+				ciphertext, err := a.Encrypt(tc.plaintext, nil)
+				if err != nil {
+					t.Fatalf("a.Encrypt() err = %v, want nil", err)
+				}
+				if _, err := a.Decrypt(ciphertext, nil); err != nil {
+					t.Fatalf("a.Decrypt() err = %v, want nil", err)
+				}
+			}
+			if countingKMS.generateRequests != tc.wantGenerate {
+				t.Errorf("generateRequests = %d, want %d", countingKMS.generateRequests, tc.wantGenerate)
+			}
+		})
+	}
+}
+
+func TestCachingEnvelopeAEADMaxAge(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	a, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI, WithMaxDataKeyAge(time.Minute))
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	now = now.Add(59 * time.Second)
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if countingKMS.generateRequests != 1 {
+		t.Errorf("generateRequests = %d, want 1", countingKMS.generateRequests)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if countingKMS.generateRequests != 2 {
+		t.Errorf("generateRequests = %d, want 2", countingKMS.generateRequests)
+	}
+	// The unwrapped data key of the first ciphertext has expired too.
+	if _, err := a.Decrypt(ciphertext, nil); err != nil {
+		t.Fatalf("a.Decrypt() err = %v, want nil", err)
+	}
+	if countingKMS.decryptRequests != 1 {
+		t.Errorf("decryptRequests = %d, want 1", countingKMS.decryptRequests)
+	}
+}
+
+func TestCachingEnvelopeAEADZeroizesExpiredDataKeysWhenIdle(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	a, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI, WithMaxDataKeyAge(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	other, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI, WithMaxDataKeyAge(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := other.Decrypt(ciphertext, nil); err != nil {
+		t.Fatalf("other.Decrypt() err = %v, want nil", err)
+	}
+
+	// Keep references to the cached data keys to check that they are zeroized
+	// in place, without any further call.
+	a.mu.Lock()
+	dataKeys := [][]byte{a.current.dataKey, a.decryption.lru.Front().Value.(*dataKeyCacheEntry).dataKey}
+	a.mu.Unlock()
+	other.mu.Lock()
+	dataKeys = append(dataKeys, other.decryption.lru.Front().Value.(*dataKeyCacheEntry).dataKey)
+	other.mu.Unlock()
+
+	for _, c := range []*CachingEnvelopeAEAD{a, other} {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			c.mu.Lock()
+			empty := c.current == nil && c.decryption.lru.Len() == 0 && c.expiry == nil
+			c.mu.Unlock()
+			if empty {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("data keys were not dropped after their maximum age")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for i, dataKey := range dataKeys {
+		if !bytes.Equal(dataKey, make([]byte, len(dataKey))) {
+			t.Errorf("dataKeys[%d] = %x, want zeroized", i, dataKey)
+		}
+	}
+}
+
+func TestCachingEnvelopeAEADDecryptionCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	encrypter, err := newTestClient(t, countingKMS).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	a, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI, WithDecryptionCacheSize(2))
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	var ciphertexts [][]byte
+	for i := 0; i < 3; i++ {
+		ciphertext, err := encrypter.Encrypt([]byte("plaintext"), nil)
+		if err != nil {
+			t.Fatalf("encrypter.Encrypt() err = %v, want nil", err)
+		}
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+	decrypt := func(i int) {
+		t.Helper()
+		if _, err := a.Decrypt(ciphertexts[i], nil); err != nil {
+			t.Fatalf("a.Decrypt(ciphertexts[%d]) err = %v, want nil", i, err)
+		}
+	}
+
+	decrypt(0)
+	decrypt(1)
+	decrypt(0)
+	if countingKMS.decryptRequests != 2 {
+		t.Errorf("decryptRequests = %d, want 2", countingKMS.decryptRequests)
+	}
+
+	_, encryptedDataKey, _, err := parseEnvelope(ciphertexts[1])
+	if err != nil {
+		t.Fatalf("parseEnvelope() err = %v, want nil", err)
+	}
+	evicted := a.decryption.entries[string(encryptedDataKey)].Value.(*dataKeyCacheEntry).dataKey
+
+	// Evicts the data key of ciphertexts[1], the least recently used.
+	decrypt(2)
+	if !bytes.Equal(evicted, make([]byte, len(evicted))) {
+		t.Error("evicted data key was not zeroized")
+	}
+	decrypt(0)
+	if countingKMS.decryptRequests != 3 {
+		t.Errorf("decryptRequests = %d, want 3", countingKMS.decryptRequests)
+	}
+	decrypt(1)
+	if countingKMS.decryptRequests != 4 {
+		t.Errorf("decryptRequests = %d, want 4", countingKMS.decryptRequests)
+	}
+}
+
+func TestCachingEnvelopeAEADClearCache(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	a, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	dataKey := a.current.dataKey
+	a.ClearCache()
+	if !bytes.Equal(dataKey, make([]byte, len(dataKey))) {
+		t.Error("data key was not zeroized")
+	}
+	if _, err := a.Decrypt(ciphertext, nil); err != nil {
+		t.Fatalf("a.Decrypt() err = %v, want nil", err)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if countingKMS.generateRequests != 2 || countingKMS.decryptRequests != 1 {
+		t.Errorf("generateRequests, decryptRequests = %d, %d, want 2, 1", countingKMS.generateRequests, countingKMS.decryptRequests)
+	}
+}
+
+func TestCachingEnvelopeAEADConcurrentUse(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	a, err := newTestClient(t, countingKMS).GetCachingEnvelopeAEAD(symmetricKeyURI, WithMaxMessagesPerDataKey(5), WithDecryptionCacheSize(3))
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			plaintext := []byte(fmt.Sprintf("plaintext %d", i))
+			ciphertext, err := a.Encrypt(plaintext, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			decrypted, err := a.Decrypt(ciphertext, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				errs <- fmt.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if countingKMS.generateRequests != 4 {
+		t.Errorf("generateRequests = %d, want 4", countingKMS.generateRequests)
+	}
+}
+
+// blockingKMS blocks GenerateDataKey requests until release is closed.
+type blockingKMS struct {
+	*requestCountingKMS
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingKMS) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.requestCountingKMS.GenerateDataKeyWithContext(ctx, input, opts...)
+}
+
+func TestCachingEnvelopeAEADDoesNotBlockWhileGeneratingDataKey(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	blocking := &blockingKMS{requestCountingKMS: countingKMS, started: make(chan struct{}, 10), release: make(chan struct{})}
+	a, err := newTestClient(t, blocking).GetCachingEnvelopeAEAD(symmetricKeyURI, WithMaxMessagesPerDataKey(1))
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	// Generate a first data key and use it up.
+	close(blocking.release)
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	<-blocking.started
+	blocking.release = make(chan struct{})
+
+	results := make(chan error, 2)
+	go func() {
+		_, err := a.Encrypt([]byte("plaintext"), nil)
+		results <- err
+	}()
+	<-blocking.started
+
+	// While the second data key is generated, cached data keys can be used and
+	// encryptions which need the new data key wait until their context is done.
+	if _, err := a.Decrypt(ciphertext, nil); err != nil {
+		t.Errorf("a.Decrypt() err = %v, want nil", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := a.EncryptWithContext(ctx, []byte("plaintext"), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("a.EncryptWithContext() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	go func() {
+		_, err := a.Encrypt([]byte("plaintext"), nil)
+		results <- err
+	}()
+
+	close(blocking.release)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("a.Encrypt() err = %v, want nil", err)
+		}
+	}
+	if countingKMS.decryptRequests != 0 {
+		t.Errorf("decryptRequests = %d, want 0", countingKMS.decryptRequests)
+	}
+}
+
+func TestCachingEnvelopeAEADClearCacheDiscardsDataKeyInFlight(t *testing.T) {
+	countingKMS := newRequestCountingKMS(t)
+	blocking := &blockingKMS{requestCountingKMS: countingKMS, started: make(chan struct{}, 10), release: make(chan struct{})}
+	a, err := newTestClient(t, blocking).GetCachingEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetCachingEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	results := make(chan error, 1)
+	go func() {
+		_, err := a.Encrypt([]byte("plaintext"), nil)
+		results <- err
+	}()
+	<-blocking.started
+	a.ClearCache()
+	close(blocking.release)
+	if err := <-results; err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	// The data key generated before ClearCache is discarded.
+	if countingKMS.generateRequests != 2 {
+		t.Errorf("generateRequests = %d, want 2", countingKMS.generateRequests)
+	}
+}
+
+func TestGetCachingEnvelopeAEADWithInvalidOptionsFails(t *testing.T) {
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(newRequestCountingKMS(t)))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		opt  CacheOption
+	}{
+		{"zero max age", WithMaxDataKeyAge(0)},
+		{"zero max messages", WithMaxMessagesPerDataKey(0)},
+		{"too many max messages", WithMaxMessagesPerDataKey(1<<32 + 1)},
+		{"zero max bytes", WithMaxBytesPerDataKey(0)},
+		{"zero decryption cache size", WithDecryptionCacheSize(0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := client.(*Client).GetCachingEnvelopeAEAD(symmetricKeyURI, tc.opt); err == nil {
+				t.Error("client.GetCachingEnvelopeAEAD() err = nil, want error")
+			}
+		})
+	}
+}
@@ -20,15 +20,13 @@ import (
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"regexp"
 	"strconv"
-	"strings"
+	"sync"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 	"github.com/tink-crypto/tink-go/v2/core/registry"
 	"github.com/tink-crypto/tink-go/v2/tink"
@@ -44,43 +42,94 @@ var (
 	errCredCSV = errors.New("malformed credential CSV file")
 )
 
-// awsClient is a wrapper around an AWS SDK provided KMS client that can
+// Client is a wrapper around an AWS SDK provided KMS client that can
 // instantiate Tink primitives.
-type awsClient struct {
+//
+// Client implements [registry.KMSClient]. Clients returned by
+// [NewClientWithOptions] are of type *Client, which also provides primitives
+// beyond AEAD, such as [Client.GetSigner] and [Client.GetMAC].
+type Client struct {
 	keyURIPrefix          string
 	kms                   kmsiface.KMSAPI
 	encryptionContextName EncryptionContextName
+	// encryptionContextFallback is set by
+	// [WithEncryptionContextNameFallback], or nil.
+	encryptionContextFallback *encryptionContextFallback
+	// staticContext is set by [WithEncryptionContext], or nil.
+	staticContext map[string]string
+	// grantTokens are set by [WithGrantTokens].
+	grantTokens []string
+	// localRegion is the region of keyURIPrefix, if any.
+	localRegion string
+	// multiRegionKeys maps multi-region keys to their replicas, see
+	// [WithMultiRegionKeyReplicas].
+	multiRegionKeys map[string][]*multiRegionKey
+	// resolveAliases is set by [WithAliasResolution].
+	resolveAliases bool
+	// retryPolicy is set by [WithRetryPolicy], or nil.
+	retryPolicy *retryPolicy
+	// telemetry is set by [WithTelemetry], or nil.
+	telemetry *telemetry
+	// interceptors are set by [WithInterceptors].
+	interceptors []Interceptor
+	// rateLimiter is set by [WithRateLimit], or nil.
+	rateLimiter *rateLimiter
+	// credentials are set by [WithCredentialPath] and the other credential
+	// options, or nil for the default credentials.
+	credentials *credentialsSource
+	// profile is set by [WithProfile], or "".
+	profile string
+	// assumeRole is set by [WithAssumeRole], or nil.
+	assumeRole *AssumeRole
+	// credentialRefresh is set by [WithCredentialRefresh], or nil.
+	credentialRefresh *CredentialRefresh
+	// endpoint is set by [WithEndpoint], or "".
+	endpoint string
+	// fips is set by [WithFIPS].
+	fips bool
+	// dualStack is set by [WithDualStack].
+	dualStack bool
+	// httpClient is set by [WithHTTPClient] or [WithHTTPConfig], or nil.
+	httpClient *http.Client
+
+	// newRegionalKMS creates KMS clients for regions without one. It is nil if
+	// the KMS client was provided by the caller.
+	newRegionalKMS func(region string) (kmsiface.KMSAPI, error)
+	mu             sync.Mutex
+	regionalKMS    map[string]kmsiface.KMSAPI
 }
 
 // ClientOption is an interface for defining options that are passed to
 // [NewClientWithOptions].
-type ClientOption interface{ set(*awsClient) error }
+type ClientOption interface{ set(*Client) error }
 
-type option func(*awsClient) error
+type option func(*Client) error
 
-func (o option) set(a *awsClient) error { return o(a) }
+func (o option) set(a *Client) error { return o(a) }
 
 // WithCredentialPath instantiates the underlying AWS KMS client using the
 // credentials located at credentialPath.
 //
 // credentialPath can specify a file in CSV format as provided in the IAM
-// console or an INI-style credentials file.
+// console or an INI-style credentials file. The "default" profile of INI-style
+// files is used, unless another one is set with [WithProfile].
+//
+// The file is read again every [DefaultCredentialRefreshInterval], or as set
+// with [WithCredentialRefresh], and when AWS reports that the credentials
+// expired, so that rotated credentials are used without recreating the client.
 //
 // See https://docs.aws.amazon.com/cli/latest/userguide/cli-authentication-user.html#cli-authentication-user-configure-csv
 // and https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-files.html#cli-configure-files-format.
 func WithCredentialPath(credentialPath string) ClientOption {
-	return option(func(a *awsClient) error {
+	return option(func(a *Client) error {
 		if a.kms != nil {
 			return errors.New("WithCredentialPath option cannot be used, KMS client already set")
 		}
-
-		k, err := getKMSFromCredentialPath(a.keyURIPrefix, credentialPath)
+		source, err := getCredentialsFromPath(credentialPath)
 		if err != nil {
 			return err
 		}
-
-		a.kms = k
-		return nil
+		return a.setCredentials(source)
 	})
 }
 
@@ -91,10 +140,13 @@ func WithCredentialPath(credentialPath string) ClientOption {
 // aligns with the region in key URIs passed to this client. Otherwise, API
 // requests will fail.
 func WithKMS(kms kmsiface.KMSAPI) ClientOption {
-	return option(func(a *awsClient) error {
+	return option(func(a *Client) error {
 		if a.kms != nil {
 			return errors.New("WithKMS option cannot be used, KMS client already set")
 		}
+		if a.hasSessionOptions() {
+			return errors.New("WithKMS option cannot be used, options of created KMS clients already set")
+		}
 		a.kms = kms
 		return nil
 	})
@@ -138,8 +190,10 @@ func (n EncryptionContextName) String() string {
 // option was present, "additionalData" was hardcoded.
 //
 // This option is provided to facilitate compatibility with older ciphertexts.
+// To migrate ciphertexts from one name to the other, see
+// [WithEncryptionContextNameFallback].
 func WithEncryptionContextName(name EncryptionContextName) ClientOption {
-	return option(func(a *awsClient) error {
+	return option(func(a *Client) error {
 		if !name.valid() {
 			return fmt.Errorf("invalid EncryptionContextName: %v", name)
 		}
@@ -154,17 +208,24 @@ func WithEncryptionContextName(name EncryptionContextName) ClientOption {
 // NewClientWithOptions returns a [registry.KMSClient] which wraps an AWS KMS
 // client and will handle keys whose URIs start with uriPrefix.
 //
-// By default, the client will use default credentials.
+// By default, the client will use default credentials. KMS clients are then
+// created for the region of uriPrefix and, as needed, for the regions of key
+// URIs passed to the client. If uriPrefix has no region, such as "aws-kms://",
+// key URIs must specify one, see [Client.GetAEAD].
 //
 // AEAD primitives produced by this client will use [AssociatedData] when
 // serializing associated data.
 func NewClientWithOptions(uriPrefix string, opts ...ClientOption) (registry.KMSClient, error) {
-	if !strings.HasPrefix(strings.ToLower(uriPrefix), awsPrefix) {
-		return nil, fmt.Errorf("uriPrefix must start with %q, but got %q", awsPrefix, uriPrefix)
+	partition, localRegion, err := parseKeyURIPrefix(uriPrefix)
+	if err != nil {
+		return nil, err
 	}
 
-	a := &awsClient{
-		keyURIPrefix: uriPrefix,
+	a := &Client{
+		keyURIPrefix:    uriPrefix,
+		multiRegionKeys: make(map[string][]*multiRegionKey),
+		regionalKMS:     make(map[string]kmsiface.KMSAPI),
+		localRegion:     localRegion,
 	}
 
 	// Process options, if any.
@@ -174,13 +235,18 @@ func NewClientWithOptions(uriPrefix string, opts ...ClientOption) (registry.KMSC
 		}
 	}
 
+	if err := a.checkCredentialOptions(); err != nil {
+		return nil, fmt.Errorf("failed setting option: %v", err)
+	}
+	if err := a.checkEndpointOptions(partition); err != nil {
+		return nil, fmt.Errorf("failed setting option: %v", err)
+	}
+
 	// Populate values not defined via options.
 	if a.kms == nil {
-		k, err := getKMS(uriPrefix)
-		if err != nil {
+		if err := a.createKMSClients(); err != nil {
 			return nil, err
 		}
-		a.kms = k
 	}
 	if a.encryptionContextName == 0 {
 		a.encryptionContextName = AssociatedData
@@ -256,74 +322,163 @@ func NewClientWithKMS(uriPrefix string, kms kmsiface.KMSAPI) (registry.KMSClient
 }
 
 // Supported returns true if keyURI starts with the URI prefix provided when
-// creating the client.
-func (c *awsClient) Supported(keyURI string) bool {
-	return strings.HasPrefix(keyURI, c.keyURIPrefix)
+// creating the client, or is a replica registered with
+// [WithMultiRegionKeyReplicas].
+//
+// keyURI is not validated otherwise: if it is not a valid key URI, see
+// [ParseKeyURI], GetAEAD and the other methods taking a key URI return a
+// [*KeyURIError].
+func (c *Client) Supported(keyURI string) bool {
+	if hasKeyURIPrefix(keyURI, c.keyURIPrefix) {
+		return true
+	}
+	u, err := ParseKeyURI(keyURI)
+	return err == nil && c.isMultiRegionReplica(u)
 }
 
 // GetAEAD returns an implementation of the AEAD interface which performs
 // cryptographic operations remotely via AWS KMS using keyURI.
 //
-// keyUri must be supported by this client and must have the following format:
+// The returned AEAD is an [*AWSAEAD], which also provides context-aware
+// methods.
+//
+// keyUri must be supported by this client and must have one of the following
+// formats:
 //
-//	aws-kms://arn:<partition>:kms:<region>:<path>
+//	aws-kms://arn:<partition>:kms:<region>:<account>:key/<key-id>
+//	aws-kms://arn:<partition>:kms:<region>:<account>:alias/<alias>
+//	aws-kms://<key-id>[?region=<region>]
+//	aws-kms://alias/<alias>[?region=<region>]
 //
 // See https://docs.aws.amazon.com/IAM/latest/UserGuide/reference-arns.html
-func (c *awsClient) GetAEAD(keyURI string) (tink.AEAD, error) {
-	if !c.Supported(keyURI) {
-		return nil, fmt.Errorf("keyURI must start with prefix %s, but got %s", c.keyURIPrefix, keyURI)
+//
+// Key IDs and alias names without a region parameter use the region of the URI
+// prefix. Aliases are passed to AWS KMS as is, unless the client was created
+// with [WithAliasResolution].
+//
+// If keyURI is a replica of a multi-region key registered with
+// [WithMultiRegionKeyReplicas], the AEAD uses all replicas of the key, see
+// [WithMultiRegionKeyReplicas] for details.
+func (c *Client) GetAEAD(keyURI string) (tink.AEAD, error) {
+	a, err := c.GetAEADWithOptions(keyURI)
+	if err != nil {
+		return nil, err
 	}
-
-	uri := strings.TrimPrefix(keyURI, awsPrefix)
-	return newAWSAEAD(uri, c.kms, c.encryptionContextName), nil
+	return a, nil
 }
 
-func getKMS(uriPrefix string) (*kms.KMS, error) {
-	r, err := getRegion(uriPrefix)
+// GetAEADWithOptions returns an AEAD for keyURI like [Client.GetAEAD], with
+// opts applied to it.
+func (c *Client) GetAEADWithOptions(keyURI string, opts ...AEADOption) (*AWSAEAD, error) {
+	keyID, k, err := c.keyFor(keyURI)
 	if err != nil {
 		return nil, err
 	}
+	return c.newAEAD(keyID, k, opts...)
+}
 
-	session, err := session.NewSession(&aws.Config{
-		Region: aws.String(r),
-	})
+// newAEAD returns an AEAD for keyID, a key resolved by keyFor, and its KMS
+// client k, with the settings of the client and opts applied.
+func (c *Client) newAEAD(keyID string, k kmsiface.KMSAPI, opts ...AEADOption) (*AWSAEAD, error) {
+	a := newAWSAEAD(keyID, k, c.encryptionContextName)
+	a.fallback = c.encryptionContextFallback
+	a.staticContext = c.staticContext
+	a.grantTokens = c.grantTokens
+	for _, opt := range opts {
+		if err := opt.setAEAD(a); err != nil {
+			return nil, fmt.Errorf("failed setting option: %v", err)
+		}
+	}
+	replicas, err := c.multiRegionReplicas(keyID)
 	if err != nil {
 		return nil, err
 	}
+	a.replicas = replicas
+	return a, nil
+}
 
-	return kms.New(session), nil
+// createKMSClients makes the client create its KMS clients with the
+// credentials set by the credential options, or the default credentials. The
+// KMS client of the client is the one for the region of the URI prefix, if it
+// has one.
+func (c *Client) createKMSClients() error {
+	c.newRegionalKMS = c.newKMS
+	if c.localRegion == "" {
+		return nil
+	}
+	k, err := c.kmsForRegion(c.localRegion)
+	if err != nil {
+		return err
+	}
+	c.kms = k
+	return nil
 }
 
-func getKMSFromCredentialPath(uriPrefix string, credentialPath string) (*kms.KMS, error) {
-	r, err := getRegion(uriPrefix)
+// kmsForRegion returns the KMS client for region.
+//
+// Clients are created once per region and then reused. If the KMS client was
+// provided by the caller, it is used for regions without a client set with
+// [WithRegionalKMS].
+func (c *Client) kmsForRegion(region string) (kmsiface.KMSAPI, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if k, ok := c.regionalKMS[region]; ok {
+		return k, nil
+	}
+	if c.newRegionalKMS == nil {
+		return c.kms, nil
+	}
+	k, err := c.newRegionalKMS(region)
 	if err != nil {
 		return nil, err
 	}
+	c.regionalKMS[region] = k
+	return k, nil
+}
 
-	var creds *credentials.Credentials
+// wrapKMS returns k, the KMS client for region, with the request handling of
+// the client, such as retries set by client options, applied to it.
+func (c *Client) wrapKMS(k kmsiface.KMSAPI, region string) kmsiface.KMSAPI {
+	var middlewares []kmsMiddleware
+	if c.telemetry != nil {
+		middlewares = append(middlewares, c.telemetry.middleware)
+	}
+	middlewares = append(middlewares, errorsMiddleware)
+	if len(c.interceptors) > 0 {
+		middlewares = append(middlewares, interceptorsMiddleware(c.interceptors))
+	}
+	if c.retryPolicy != nil {
+		middlewares = append(middlewares, c.retryPolicy.middleware)
+	}
+	if c.rateLimiter != nil {
+		middlewares = append(middlewares, c.rateLimiter.middleware)
+	}
+	return &middlewareKMS{KMSAPI: k, region: region, middlewares: middlewares}
+}
+
+func getCredentialsFromPath(credentialPath string) (*credentialsSource, error) {
 	if len(credentialPath) == 0 {
 		return nil, errCred
 	}
-	c, err := extractCredsCSV(credentialPath)
-	switch err {
-	case nil:
-		creds = credentials.NewStaticCredentialsFromCreds(*c)
+	switch _, err := extractCredsCSV(credentialPath); err {
 	case errBadFile, errCredCSV:
 		return nil, err
-	default:
-		// Fallback to load the credential path as .ini shared credentials.
-		creds = credentials.NewSharedCredentials(credentialPath, "default")
 	}
-
-	session, err := session.NewSession(&aws.Config{
-		Credentials: creds,
-		Region:      aws.String(r),
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return kms.New(session), nil
+	// The credentials are shared by the KMS clients of all regions.
+	var once sync.Once
+	var creds *credentials.Credentials
+	return &credentialsSource{
+		option:       "WithCredentialPath",
+		readsProfile: true,
+		readsFile:    true,
+		get: func(c *Client, _ *session.Session) *credentials.Credentials {
+			once.Do(func() {
+				creds = credentials.NewCredentials(newFileCredentialsProvider(credentialPath, c.profile, c.credentialRefresh))
+			})
+			return creds
+		},
+	}, nil
 }
 
 // extractCredsCSV extracts credentials from a CSV file.
@@ -367,16 +522,3 @@ func extractCredsCSV(file string) (*credentials.Value, error) {
 		SecretAccessKey: lines[1][3],
 	}, nil
 }
-
-// getRegion extracts the region from keyURI.
-func getRegion(keyURI string) (string, error) {
-	re1, err := regexp.Compile(`aws-kms://arn:(aws[a-zA-Z0-9-_]*):kms:([a-z0-9-]+):`)
-	if err != nil {
-		return "", err
-	}
-	r := re1.FindStringSubmatch(keyURI)
-	if len(r) != 3 {
-		return "", errors.New("extracting region from URI failed")
-	}
-	return r[2], nil
-}
@@ -26,10 +26,41 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 	"github.com/tink-crypto/tink-go/v2/core/registry"
 	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
 )
 
+const (
+	symmetricKeyARN = "arn:aws:kms:us-east-2:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	symmetricKeyURI = "aws-kms://arn:aws:kms:us-east-2:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+
+	otherSymmetricKeyARN = "arn:aws:kms:us-east-2:235739564943:key/9b6c2f1e-0d4a-4c8e-b7f3-5a1d2e3c4b5f"
+	otherSymmetricKeyURI = "aws-kms://arn:aws:kms:us-east-2:235739564943:key/9b6c2f1e-0d4a-4c8e-b7f3-5a1d2e3c4b5f"
+
+	// The replicas of a multi-Region key.
+	eastReplicaARN = "arn:aws:kms:us-east-1:235739564943:key/mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+	eastReplicaURI = "aws-kms://arn:aws:kms:us-east-1:235739564943:key/mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+	westReplicaARN = "arn:aws:kms:us-west-2:235739564943:key/mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+	westReplicaURI = "aws-kms://arn:aws:kms:us-west-2:235739564943:key/mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+	euReplicaARN   = "arn:aws:kms:eu-west-1:235739564943:key/mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+	euReplicaURI   = "aws-kms://arn:aws:kms:eu-west-1:235739564943:key/mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"
+
+	// The principal which grants are given to.
+	granteeRoleARN = "arn:aws:iam::111122223333:role/grantee"
+)
+
+// newTestClient returns a client for the keys of us-east-2 which sends its
+// requests to k, usually a fake AWS KMS, with opts applied.
+func newTestClient(t *testing.T, k kmsiface.KMSAPI, opts ...ClientOption) *Client {
+	t.Helper()
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", append([]ClientOption{WithKMS(k)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	return client.(*Client)
+}
+
 func TestNewClientWithOptions_URIPrefix(t *testing.T) {
 	srcDir, ok := os.LookupEnv("TEST_SRCDIR")
 	if !ok {
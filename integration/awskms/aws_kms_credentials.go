@@ -0,0 +1,330 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+var (
+	roleARNPattern     = regexp.MustCompile(`^arn:aws[a-zA-Z0-9-]*:iam::[0-9]{12}:role/.+$`)
+	sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+)
+
+// credentialsSource is the source of the credentials of the KMS clients
+// created by a client, set by one of the credential options.
+type credentialsSource struct {
+	// option is the name of the option which set the source.
+	option string
+	// readsProfile is whether the source uses the profile set by
+	// [WithProfile].
+	readsProfile bool
+	// readsFile is whether the source reads a credentials file, which can be
+	// refreshed with [WithCredentialRefresh].
+	readsFile bool
+	// get returns the credentials of the KMS client of c with session sess.
+	get func(c *Client, sess *session.Session) *credentials.Credentials
+}
+
+// setCredentials sets the source of the credentials of the KMS clients created
+// by the client. Only one source can be set.
+func (c *Client) setCredentials(source *credentialsSource) error {
+	if c.kms != nil {
+		return fmt.Errorf("%s option cannot be used, KMS client already set", source.option)
+	}
+	if c.credentials != nil {
+		return fmt.Errorf("%s option cannot be used, credentials already set by %s", source.option, c.credentials.option)
+	}
+	c.credentials = source
+	return nil
+}
+
+// hasSessionOptions returns whether options which configure the KMS clients
+// created by the client, such as credential, endpoint and HTTP options, are
+// set. They can't be combined with a KMS client provided by the caller.
+func (c *Client) hasSessionOptions() bool {
+	return c.credentials != nil || c.profile != "" || c.assumeRole != nil || c.credentialRefresh != nil ||
+		c.endpoint != "" || c.fips || c.dualStack || c.httpClient != nil
+}
+
+// checkCredentialOptions checks that the credential options set on the client
+// can be combined.
+func (c *Client) checkCredentialOptions() error {
+	if c.profile != "" && c.credentials != nil && !c.credentials.readsProfile {
+		return fmt.Errorf("WithProfile option cannot be used with %s", c.credentials.option)
+	}
+	if c.credentialRefresh != nil && (c.credentials == nil || !c.credentials.readsFile) {
+		return errors.New("WithCredentialRefresh option cannot be used without WithCredentialPath")
+	}
+	return nil
+}
+
+// WithProfile makes the client use the named profile profile instead of the
+// "default" profile.
+//
+// With [WithCredentialPath] and an INI-style credentials file, the credentials
+// of the profile in this file are used. Otherwise, the profile is loaded from
+// the shared config and credentials files, see
+// https://docs.aws.amazon.com/sdkref/latest/guide/file-format.html. Profiles in
+// these files can also get credentials by assuming a role, from a web identity
+// token file, from AWS IAM Identity Center (SSO) or from a credential_process,
+// as supported by the AWS SDK.
+//
+// WithProfile can't be combined with the other credential options, except
+// [WithAssumeRole], for which the profile provides the source credentials.
+func WithProfile(profile string) ClientOption {
+	return option(func(a *Client) error {
+		if profile == "" {
+			return errors.New("WithProfile option cannot be used with an empty profile")
+		}
+		if a.kms != nil {
+			return errors.New("WithProfile option cannot be used, KMS client already set")
+		}
+		if a.profile != "" {
+			return errors.New("WithProfile option cannot be used, profile already set")
+		}
+		a.profile = profile
+		return nil
+	})
+}
+
+// AssumeRole specifies the IAM role assumed by a client, see [WithAssumeRole].
+type AssumeRole struct {
+	// RoleARN is the ARN of the role. It is required.
+	RoleARN string
+	// ExternalID is the external ID required by the trust policy of the role,
+	// if any.
+	ExternalID string
+	// RoleSessionName identifies the session of the role, for example in AWS
+	// CloudTrail logs. If empty, a name is generated by the AWS SDK.
+	RoleSessionName string
+	// Duration is the duration of the role session, between 15 minutes and 12
+	// hours. If zero, it is 15 minutes.
+	Duration time.Duration
+}
+
+func validateAssumeRole(r AssumeRole) error {
+	if !roleARNPattern.MatchString(r.RoleARN) {
+		return fmt.Errorf("RoleARN must be the ARN of an IAM role, got %q", r.RoleARN)
+	}
+	if r.RoleSessionName != "" && !sessionNamePattern.MatchString(r.RoleSessionName) {
+		return fmt.Errorf("invalid RoleSessionName %q", r.RoleSessionName)
+	}
+	if r.Duration != 0 && (r.Duration < 15*time.Minute || r.Duration > 12*time.Hour) {
+		return fmt.Errorf("Duration must be between 15m and 12h, got %v", r.Duration)
+	}
+	return nil
+}
+
+// WithAssumeRole makes the client use temporary credentials obtained by
+// assuming the IAM role specified by role with the AWS STS AssumeRole API. The
+// credentials are refreshed before they expire.
+//
+// The role is assumed with the credentials set by the other credential options,
+// such as [WithCredentialPath] or [WithProfile], or with the default
+// credentials.
+func WithAssumeRole(role AssumeRole) ClientOption {
+	return option(func(a *Client) error {
+		if a.kms != nil {
+			return errors.New("WithAssumeRole option cannot be used, KMS client already set")
+		}
+		if a.assumeRole != nil {
+			return errors.New("WithAssumeRole option cannot be used, role already set")
+		}
+		if err := validateAssumeRole(role); err != nil {
+			return fmt.Errorf("invalid role: %v", err)
+		}
+		a.assumeRole = &role
+		return nil
+	})
+}
+
+// assumeRoleCredentials returns the credentials of r, assumed with the
+// credentials of sess.
+func assumeRoleCredentials(sess *session.Session, r *AssumeRole) *credentials.Credentials {
+	return stscreds.NewCredentials(sess, r.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		if r.ExternalID != "" {
+			p.ExternalID = aws.String(r.ExternalID)
+		}
+		if r.RoleSessionName != "" {
+			p.RoleSessionName = r.RoleSessionName
+		}
+		if r.Duration != 0 {
+			p.Duration = r.Duration
+		}
+	})
+}
+
+// WebIdentity specifies the IAM role assumed by a client with a web identity
+// token, see [WithWebIdentity].
+type WebIdentity struct {
+	// RoleARN is the ARN of the role. If empty, it is read from the
+	// AWS_ROLE_ARN environment variable.
+	RoleARN string
+	// TokenFile is the path of the file containing the OAuth 2.0 access token
+	// or OpenID Connect ID token. It is read again each time the credentials
+	// are refreshed. If empty, it is read from the AWS_WEB_IDENTITY_TOKEN_FILE
+	// environment variable.
+	TokenFile string
+	// RoleSessionName identifies the session of the role. If empty, it is read
+	// from the AWS_ROLE_SESSION_NAME environment variable, or generated by the
+	// AWS SDK.
+	RoleSessionName string
+}
+
+// WithWebIdentity makes the client use temporary credentials obtained by
+// assuming the IAM role specified by identity with the AWS STS
+// AssumeRoleWithWebIdentity API. The credentials are refreshed before they
+// expire.
+//
+// With a WebIdentity without fields, the role and token file are those set by
+// IAM roles for service accounts (IRSA) of Amazon EKS in the environment.
+func WithWebIdentity(identity WebIdentity) ClientOption {
+	return option(func(a *Client) error {
+		if identity.RoleARN == "" {
+			identity.RoleARN = os.Getenv("AWS_ROLE_ARN")
+		}
+		if identity.TokenFile == "" {
+			identity.TokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+		if identity.RoleSessionName == "" {
+			identity.RoleSessionName = os.Getenv("AWS_ROLE_SESSION_NAME")
+		}
+		if !roleARNPattern.MatchString(identity.RoleARN) {
+			return fmt.Errorf("invalid web identity: RoleARN must be the ARN of an IAM role, got %q", identity.RoleARN)
+		}
+		if identity.TokenFile == "" {
+			return errors.New("invalid web identity: TokenFile must be set")
+		}
+		if identity.RoleSessionName != "" && !sessionNamePattern.MatchString(identity.RoleSessionName) {
+			return fmt.Errorf("invalid web identity: invalid RoleSessionName %q", identity.RoleSessionName)
+		}
+		return a.setCredentials(&credentialsSource{
+			option: "WithWebIdentity",
+			get: func(_ *Client, sess *session.Session) *credentials.Credentials {
+				return stscreds.NewWebIdentityCredentials(sess, identity.RoleARN, identity.RoleSessionName, identity.TokenFile)
+			},
+		})
+	})
+}
+
+// SSO specifies the AWS IAM Identity Center (SSO) role used by a client, see
+// [WithSSO]. All fields are required.
+type SSO struct {
+	// StartURL is the URL of the AWS access portal.
+	StartURL string
+	// Region is the region of the AWS access portal, which can differ from the
+	// regions of the keys.
+	Region string
+	// AccountID is the ID of the AWS account of the role.
+	AccountID string
+	// RoleName is the name of the permission set of the role.
+	RoleName string
+}
+
+// WithSSO makes the client use temporary credentials of the AWS IAM Identity
+// Center (SSO) role specified by sso. The credentials are obtained with the
+// access token cached by "aws sso login" for sso.StartURL, which the client
+// doesn't refresh.
+func WithSSO(sso SSO) ClientOption {
+	return option(func(a *Client) error {
+		if sso.StartURL == "" || sso.AccountID == "" || sso.RoleName == "" {
+			return errors.New("invalid SSO: StartURL, AccountID and RoleName must be set")
+		}
+		if !regionPattern.MatchString(sso.Region) {
+			return fmt.Errorf("invalid SSO: %v %q", ErrInvalidRegion, sso.Region)
+		}
+		return a.setCredentials(&credentialsSource{
+			option: "WithSSO",
+			get: func(_ *Client, sess *session.Session) *credentials.Credentials {
+				ssoSession := sess.Copy(&aws.Config{Region: aws.String(sso.Region)})
+				return ssocreds.NewCredentials(ssoSession, sso.AccountID, sso.RoleName, sso.StartURL)
+			},
+		})
+	})
+}
+
+// WithCredentialProcess makes the client use the credentials printed by
+// command, as for the credential_process setting of the shared config file.
+// The command is run again when the credentials expire.
+//
+// See https://docs.aws.amazon.com/sdkref/latest/guide/feature-process-credentials.html.
+func WithCredentialProcess(command string) ClientOption {
+	return option(func(a *Client) error {
+		if command == "" {
+			return errors.New("WithCredentialProcess option cannot be used with an empty command")
+		}
+		return a.setCredentials(&credentialsSource{
+			option: "WithCredentialProcess",
+			get: func(*Client, *session.Session) *credentials.Credentials {
+				return processcreds.NewCredentials(command)
+			},
+		})
+	})
+}
+
+// newKMS returns a new KMS client for region, with the credentials set by the
+// credential options and the endpoint set by the endpoint options.
+func (c *Client) newKMS(region string) (kmsiface.KMSAPI, error) {
+	sess, err := c.newSession(region)
+	if err != nil {
+		return nil, err
+	}
+	return kms.New(sess, c.kmsConfig(region)), nil
+}
+
+// newSession returns the session of the KMS client for region.
+//
+// The credentials of the session are obtained from the credential options with
+// a session for region, so that requests made to get them, for example to AWS
+// STS, use the region of the KMS client.
+func (c *Client) newSession(region string) (*session.Session, error) {
+	opts := session.Options{
+		Config: aws.Config{
+			Region:     aws.String(region),
+			HTTPClient: c.httpClient,
+		},
+	}
+	if c.profile != "" && c.credentials == nil {
+		opts.Profile = c.profile
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.credentials != nil {
+		sess = sess.Copy(&aws.Config{Credentials: c.credentials.get(c, sess)})
+	}
+	if c.assumeRole != nil {
+		sess = sess.Copy(&aws.Config{Credentials: assumeRoleCredentials(sess, c.assumeRole)})
+	}
+	return sess, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// DefaultCredentialRefreshInterval is the interval at which the credentials
+// file of [WithCredentialPath] is read again, unless another one is set with
+// [WithCredentialRefresh].
+const DefaultCredentialRefreshInterval = 5 * time.Minute
+
+// fileCredentialsProviderName is the name of the provider of the credentials
+// read from the file of [WithCredentialPath].
+const fileCredentialsProviderName = "TinkAWSKMSFileProvider"
+
+// CredentialRefresh specifies how the credentials file of [WithCredentialPath]
+// is read again, see [WithCredentialRefresh].
+type CredentialRefresh struct {
+	// Interval is the time after which the file is read again. If zero, it is
+	// DefaultCredentialRefreshInterval.
+	Interval time.Duration
+	// OnError, if not nil, is called with the errors reading or parsing the
+	// file once it was read successfully. These errors don't fail requests:
+	// the credentials last read from the file are used until it can be read
+	// again.
+	OnError func(credentialPath string, err error)
+}
+
+// WithCredentialRefresh sets how the credentials file of [WithCredentialPath]
+// is read again, so that the client picks up credentials rotated by rewriting
+// the file. It requires WithCredentialPath.
+//
+// The file is read again when refresh.Interval has passed since it was last
+// read, and when AWS reports that the credentials expired. Only the first
+// read of the file must succeed; later errors are reported to
+// refresh.OnError.
+func WithCredentialRefresh(refresh CredentialRefresh) ClientOption {
+	return option(func(a *Client) error {
+		if a.kms != nil {
+			return errors.New("WithCredentialRefresh option cannot be used, KMS client already set")
+		}
+		if a.credentialRefresh != nil {
+			return errors.New("WithCredentialRefresh option cannot be used, credential refresh already set")
+		}
+		if refresh.Interval < 0 {
+			return fmt.Errorf("invalid credential refresh: Interval must not be negative, got %v", refresh.Interval)
+		}
+		a.credentialRefresh = &refresh
+		return nil
+	})
+}
+
+// fileCredentialsProvider is a credentials.Provider which reads the credentials
+// file of [WithCredentialPath] and reads it again when it expires, keeping the
+// last credentials read if it can't.
+type fileCredentialsProvider struct {
+	path    string
+	profile string
+	refresh CredentialRefresh
+
+	mu sync.Mutex
+	// value are the credentials last read from the file, if valid.
+	value credentials.Value
+	valid bool
+	// expiration is the time after which the file must be read again.
+	expiration time.Time
+}
+
+var _ credentials.Provider = (*fileCredentialsProvider)(nil)
+
+// newFileCredentialsProvider returns a provider for the credentials of
+// profile, or of the "default" profile if empty, in the file path. refresh may
+// be nil for the default refresh interval.
+func newFileCredentialsProvider(path, profile string, refresh *CredentialRefresh) *fileCredentialsProvider {
+	p := &fileCredentialsProvider{path: path, profile: profile}
+	if refresh != nil {
+		p.refresh = *refresh
+	}
+	if p.refresh.Interval == 0 {
+		p.refresh.Interval = DefaultCredentialRefreshInterval
+	}
+	if p.profile == "" {
+		p.profile = "default"
+	}
+	return p
+}
+
+// Retrieve reads the credentials file. If it can't be read or parsed, the
+// credentials last read are returned and the error is reported, or returned if
+// the file was never read successfully.
+func (p *fileCredentialsProvider) Retrieve() (credentials.Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v, err := readCredentialsFile(p.path, p.profile)
+	p.expiration = time.Now().Add(p.refresh.Interval)
+	if err != nil {
+		if !p.valid {
+			return credentials.Value{}, err
+		}
+		if p.refresh.OnError != nil {
+			p.refresh.OnError(p.path, err)
+		}
+		return p.value, nil
+	}
+	p.value, p.valid = v, true
+	return v, nil
+}
+
+// IsExpired returns whether the refresh interval passed since the file was
+// last read.
+func (p *fileCredentialsProvider) IsExpired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !time.Now().Before(p.expiration)
+}
+
+// readCredentialsFile reads the credentials in the file path, which can be a
+// CSV file as provided in the IAM console or an INI-style credentials file,
+// in which case the credentials of profile are read.
+func readCredentialsFile(path, profile string) (credentials.Value, error) {
+	c, err := extractCredsCSV(path)
+	switch err {
+	case nil:
+		if !c.HasKeys() {
+			return credentials.Value{}, errCredCSV
+		}
+		c.ProviderName = fileCredentialsProviderName
+		return *c, nil
+	case errBadFile, errCredCSV:
+		return credentials.Value{}, err
+	default:
+		// Fallback to read the file as .ini shared credentials.
+		v, err := (&credentials.SharedCredentialsProvider{Filename: path, Profile: profile}).Retrieve()
+		if err != nil {
+			return credentials.Value{}, err
+		}
+		v.ProviderName = fileCredentialsProviderName
+		return v, nil
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+func credentialsCSV(accessKeyID string) string {
+	return "User name,Password,Access key ID,Secret access key,Console login link\nuser,," + accessKeyID + ",secret,https://console.aws.amazon.com\n"
+}
+
+func credentialsINI(profile, accessKeyID string) string {
+	return "[" + profile + "]\naws_access_key_id = " + accessKeyID + "\naws_secret_access_key = secret\n"
+}
+
+func mustRetrieve(t *testing.T, p *fileCredentialsProvider, wantAccessKeyID string) {
+	t.Helper()
+	v, err := p.Retrieve()
+	if err != nil {
+		t.Fatalf("p.Retrieve() err = %v, want nil", err)
+	}
+	if v.AccessKeyID != wantAccessKeyID || v.SecretAccessKey != "secret" {
+		t.Errorf("p.Retrieve() = %q, %q, want %q, %q", v.AccessKeyID, v.SecretAccessKey, wantAccessKeyID, "secret")
+	}
+}
+
+func TestFileCredentialsProviderReadsRotatedCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile string
+		content func(accessKeyID string) string
+	}{
+		{"CSV", "", credentialsCSV},
+		{"INI default profile", "", func(id string) string { return credentialsINI("default", id) }},
+		{"INI named profile", "dev", func(id string) string { return credentialsINI("default", "AKIDDEFAULT") + credentialsINI("dev", id) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeFile(t, "credentials", tc.content("AKID1"))
+			p := newFileCredentialsProvider(path, tc.profile, &CredentialRefresh{Interval: time.Hour})
+			mustRetrieve(t, p, "AKID1")
+			if p.IsExpired() {
+				t.Error("p.IsExpired() = true, want false")
+			}
+
+			if err := os.WriteFile(path, []byte(tc.content("AKID2")), 0600); err != nil {
+				t.Fatalf("os.WriteFile() err = %v", err)
+			}
+			p.refresh.Interval = 0
+			mustRetrieve(t, p, "AKID2")
+			if !p.IsExpired() {
+				t.Error("p.IsExpired() = false, want true")
+			}
+		})
+	}
+}
+
+func TestFileCredentialsProviderKeepsLastKnownGoodCredentials(t *testing.T) {
+	path := writeFile(t, "credentials.csv", credentialsCSV("AKID1"))
+	var reported []error
+	p := newFileCredentialsProvider(path, "", &CredentialRefresh{
+		OnError: func(credentialPath string, err error) {
+			if credentialPath != path {
+				t.Errorf("OnError() credentialPath = %q, want %q", credentialPath, path)
+			}
+			reported = append(reported, err)
+		},
+	})
+	mustRetrieve(t, p, "AKID1")
+
+	// A partially written file.
+	if err := os.WriteFile(path, []byte("User name,Password,Access key ID,Secret access key,Console login link\n"), 0600); err != nil {
+		t.Fatalf("os.WriteFile() err = %v", err)
+	}
+	mustRetrieve(t, p, "AKID1")
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("os.Remove() err = %v", err)
+	}
+	mustRetrieve(t, p, "AKID1")
+	if len(reported) != 2 || reported[0] == nil || reported[1] == nil {
+		t.Errorf("reported errors = %v, want 2 errors", reported)
+	}
+
+	if err := os.WriteFile(path, []byte(credentialsCSV("AKID2")), 0600); err != nil {
+		t.Fatalf("os.WriteFile() err = %v", err)
+	}
+	mustRetrieve(t, p, "AKID2")
+}
+
+func TestFileCredentialsProviderFailsWithoutValidCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile string
+		content string
+	}{
+		{"CSV without keys", "", "User name,Password,Access key ID,Secret access key,Console login link\nuser,,,,\n"},
+		{"INI without profile", "dev", credentialsINI("default", "AKID1")},
+		{"INI without keys", "", "[default]\nregion = us-east-2\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newFileCredentialsProvider(writeFile(t, "credentials", tc.content), tc.profile, nil)
+			if _, err := p.Retrieve(); err == nil {
+				t.Error("p.Retrieve() err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestWithCredentialRefresh(t *testing.T) {
+	path := writeFile(t, "credentials.csv", credentialsCSV("AKID1"))
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", WithCredentialRefresh(CredentialRefresh{Interval: time.Minute}), WithCredentialPath(path))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+	}
+	c := client.(*Client)
+	east, err := c.newSession("us-east-2")
+	if err != nil {
+		t.Fatalf("c.newSession() err = %v, want nil", err)
+	}
+	west, err := c.newSession("eu-west-1")
+	if err != nil {
+		t.Fatalf("c.newSession() err = %v, want nil", err)
+	}
+	if east.Config.Credentials != west.Config.Credentials {
+		t.Error("the credentials of the sessions of different regions differ, want them shared")
+	}
+	v, err := east.Config.Credentials.Get()
+	if err != nil {
+		t.Fatalf("Credentials.Get() err = %v, want nil", err)
+	}
+	if v.AccessKeyID != "AKID1" || v.ProviderName != fileCredentialsProviderName {
+		t.Errorf("Credentials.Get() = %q from %q, want %q from %q", v.AccessKeyID, v.ProviderName, "AKID1", fileCredentialsProviderName)
+	}
+}
+
+func TestWithCredentialRefreshFails(t *testing.T) {
+	path := writeFile(t, "credentials.csv", credentialsCSV("AKID1"))
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		opts []ClientOption
+	}{
+		{"negative interval", []ClientOption{WithCredentialPath(path), WithCredentialRefresh(CredentialRefresh{Interval: -time.Minute})}},
+		{"repeated", []ClientOption{WithCredentialPath(path), WithCredentialRefresh(CredentialRefresh{}), WithCredentialRefresh(CredentialRefresh{})}},
+		{"without credential path", []ClientOption{WithCredentialRefresh(CredentialRefresh{})}},
+		{"with credential process", []ClientOption{WithCredentialProcess("get-credentials"), WithCredentialRefresh(CredentialRefresh{})}},
+		{"with KMS client", []ClientOption{WithKMS(fakekms), WithCredentialRefresh(CredentialRefresh{})}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", tc.opts...); err == nil {
+				t.Error("NewClientWithOptions() err = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+const roleARN = "arn:aws:iam::235739564943:role/kms-user"
+
+// writeFile writes content to a new file in a temporary directory and returns
+// its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("os.WriteFile() err = %v", err)
+	}
+	return path
+}
+
+func TestCredentialOptions(t *testing.T) {
+	iniPath := writeFile(t, "credentials", "[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = secret\n\n[dev]\naws_access_key_id = AKIDDEV\naws_secret_access_key = secret\n")
+	csvPath := writeFile(t, "credentials.csv", "User name,Password,Access key ID,Secret access key,Console login link\nuser,,AKIDCSV,secret,https://console.aws.amazon.com\n")
+	tokenPath := writeFile(t, "token", "token")
+	t.Setenv("AWS_CONFIG_FILE", writeFile(t, "config", "[profile dev]\nregion = us-east-1\n"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", iniPath)
+
+	for _, tc := range []struct {
+		name string
+		opts []ClientOption
+	}{
+		{"profile", []ClientOption{WithProfile("dev")}},
+		{"credential path and profile", []ClientOption{WithProfile("dev"), WithCredentialPath(iniPath)}},
+		{"assume role", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: roleARN})}},
+		{"assume role with all fields", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: roleARN, ExternalID: "external-id", RoleSessionName: "tink", Duration: time.Hour})}},
+		{"credential path and assume role", []ClientOption{WithCredentialPath(csvPath), WithAssumeRole(AssumeRole{RoleARN: roleARN})}},
+		{"profile and assume role", []ClientOption{WithProfile("dev"), WithAssumeRole(AssumeRole{RoleARN: roleARN})}},
+		{"web identity", []ClientOption{WithWebIdentity(WebIdentity{RoleARN: roleARN, TokenFile: tokenPath, RoleSessionName: "tink"})}},
+		{"SSO", []ClientOption{WithSSO(SSO{StartURL: "https://example.awsapps.com/start", Region: "us-east-1", AccountID: "235739564943", RoleName: "KMSUser"})}},
+		{"credential process", []ClientOption{WithCredentialProcess("/usr/local/bin/get-credentials --json")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", tc.opts...)
+			if err != nil {
+				t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+			}
+			c := client.(*Client)
+			if c.kms == nil {
+				t.Error("KMS client of the URI prefix region not created")
+			}
+			for _, region := range []string{"us-east-2", "eu-west-1"} {
+				sess, err := c.newSession(region)
+				if err != nil {
+					t.Fatalf("c.newSession(%q) err = %v, want nil", region, err)
+				}
+				if got := aws.StringValue(sess.Config.Region); got != region {
+					t.Errorf("c.newSession(%q) region = %q, want %q", region, got, region)
+				}
+				if sess.Config.Credentials == nil {
+					t.Errorf("c.newSession(%q) credentials = nil, want not nil", region)
+				}
+			}
+		})
+	}
+}
+
+func TestWithWebIdentityReadsEnvironment(t *testing.T) {
+	t.Setenv("AWS_ROLE_ARN", roleARN)
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", writeFile(t, "token", "token"))
+	if _, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", WithWebIdentity(WebIdentity{})); err != nil {
+		t.Errorf("NewClientWithOptions() err = %v, want nil", err)
+	}
+}
+
+func TestCredentialOptionsFail(t *testing.T) {
+	t.Setenv("AWS_ROLE_ARN", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	t.Setenv("AWS_ROLE_SESSION_NAME", "")
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	sso := SSO{StartURL: "https://example.awsapps.com/start", Region: "us-east-1", AccountID: "235739564943", RoleName: "KMSUser"}
+
+	for _, tc := range []struct {
+		name string
+		opts []ClientOption
+	}{
+		{"empty profile", []ClientOption{WithProfile("")}},
+		{"repeated profile", []ClientOption{WithProfile("dev"), WithProfile("prod")}},
+		{"profile and SSO", []ClientOption{WithProfile("dev"), WithSSO(sso)}},
+		{"credential process and profile", []ClientOption{WithCredentialProcess("get-credentials"), WithProfile("dev")}},
+		{"missing role ARN", []ClientOption{WithAssumeRole(AssumeRole{})}},
+		{"malformed role ARN", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: "arn:aws:iam::235739564943:user/kms-user"})}},
+		{"invalid session name", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: roleARN, RoleSessionName: "tink session"})}},
+		{"too short duration", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: roleARN, Duration: time.Minute})}},
+		{"too long duration", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: roleARN, Duration: 13 * time.Hour})}},
+		{"repeated assume role", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: roleARN}), WithAssumeRole(AssumeRole{RoleARN: roleARN})}},
+		{"web identity without role", []ClientOption{WithWebIdentity(WebIdentity{TokenFile: "token"})}},
+		{"web identity without token file", []ClientOption{WithWebIdentity(WebIdentity{RoleARN: roleARN})}},
+		{"SSO without role", []ClientOption{WithSSO(SSO{StartURL: sso.StartURL, Region: sso.Region, AccountID: sso.AccountID})}},
+		{"SSO without region", []ClientOption{WithSSO(SSO{StartURL: sso.StartURL, AccountID: sso.AccountID, RoleName: sso.RoleName})}},
+		{"empty credential process", []ClientOption{WithCredentialProcess("")}},
+		{"two credential sources", []ClientOption{WithCredentialProcess("get-credentials"), WithSSO(sso)}},
+		{"KMS client and credentials", []ClientOption{WithKMS(fakekms), WithCredentialProcess("get-credentials")}},
+		{"credentials and KMS client", []ClientOption{WithSSO(sso), WithKMS(fakekms)}},
+		{"assume role and KMS client", []ClientOption{WithAssumeRole(AssumeRole{RoleARN: roleARN}), WithKMS(fakekms)}},
+		{"KMS client and profile", []ClientOption{WithKMS(fakekms), WithProfile("dev")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", tc.opts...); err == nil {
+				t.Error("NewClientWithOptions() err = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// WithEncryptionContext adds the pairs of ec to the encryption context of the
+// requests made by the AEADs of the client, such as {"tenant": "example"}. This
+// binds ciphertexts to the pairs, which can also be used in conditions of key
+// policies and appear in AWS CloudTrail logs.
+//
+// The pairs are merged with the associated data entry, whose name is set by
+// [WithEncryptionContextName]. ec must not contain the names "associatedData"
+// and "additionalData", which are reserved for associated data. Use
+// [WithAEADEncryptionContext] to set pairs for a single AEAD.
+func WithEncryptionContext(ec map[string]string) ClientOption {
+	return option(func(a *Client) error {
+		if a.staticContext != nil {
+			return errors.New("WithEncryptionContext option cannot be used, encryption context already set")
+		}
+		if err := validateEncryptionContext(ec); err != nil {
+			return err
+		}
+		a.staticContext = mergeEncryptionContexts(nil, ec)
+		return nil
+	})
+}
+
+// WithAEADEncryptionContext adds the pairs of ec to the encryption context of
+// the requests made by the AEAD, in addition to the pairs set by
+// [WithEncryptionContext]. Pairs of ec replace pairs of the client with the
+// same name. See [WithEncryptionContext] for details.
+func WithAEADEncryptionContext(ec map[string]string) AEADOption {
+	return aeadOption(func(a *AWSAEAD) error {
+		if err := validateEncryptionContext(ec); err != nil {
+			return err
+		}
+		a.staticContext = mergeEncryptionContexts(a.staticContext, ec)
+		return nil
+	})
+}
+
+// EncryptWithEncryptionContext encrypts the plaintext with the pairs of ec in
+// the encryption context, instead of associated data. Unlike associated data,
+// which is hex-encoded in a single pair, the pairs appear as is in AWS
+// CloudTrail logs and can be used in conditions of key policies.
+//
+// The pairs are merged with the pairs set by [WithEncryptionContext] and
+// [WithAEADEncryptionContext]. ec must not contain their names, nor the names
+// reserved for associated data. The ciphertext can be decrypted with
+// [AWSAEAD.DecryptWithEncryptionContext] and the same pairs.
+//
+// ctx is used for the KMS request, and opts are applied to it.
+func (a *AWSAEAD) EncryptWithEncryptionContext(ctx context.Context, plaintext []byte, ec map[string]string, opts ...request.Option) ([]byte, error) {
+	kmsContext, err := a.callEncryptionContext(ec)
+	if err != nil {
+		return nil, err
+	}
+	req := a.encryptInput(plaintext, nil)
+	req.EncryptionContext = kmsContext
+	return a.encrypt(ctx, req, opts...)
+}
+
+// DecryptWithEncryptionContext decrypts the ciphertext and verifies the pairs
+// of ec, which must be the ones it was encrypted with by
+// [AWSAEAD.EncryptWithEncryptionContext].
+//
+// ctx is used for the KMS request, and opts are applied to it.
+func (a *AWSAEAD) DecryptWithEncryptionContext(ctx context.Context, ciphertext []byte, ec map[string]string, opts ...request.Option) ([]byte, error) {
+	kmsContext, err := a.callEncryptionContext(ec)
+	if err != nil {
+		return nil, err
+	}
+	req := a.decryptInput(a.encryptionContextName, ciphertext, nil)
+	req.EncryptionContext = kmsContext
+	return a.decrypt(ctx, req, opts...)
+}
+
+// callEncryptionContext returns the encryption context of requests with the
+// pairs of ec, and the pairs of a.
+func (a *AWSAEAD) callEncryptionContext(ec map[string]string) (map[string]*string, error) {
+	if len(ec) == 0 {
+		return encryptionContext(a.staticContext, a.encryptionContextName, nil), nil
+	}
+	if err := validateEncryptionContext(ec); err != nil {
+		return nil, err
+	}
+	for name := range ec {
+		if _, ok := a.staticContext[name]; ok {
+			return nil, fmt.Errorf("encryption context name %q is already set for the AEAD", name)
+		}
+	}
+	return encryptionContext(mergeEncryptionContexts(a.staticContext, ec), a.encryptionContextName, nil), nil
+}
+
+// validateEncryptionContext checks that ec has pairs and that their names
+// aren't reserved.
+func validateEncryptionContext(ec map[string]string) error {
+	if len(ec) == 0 {
+		return errors.New("encryption context must not be empty")
+	}
+	for name := range ec {
+		if name == "" {
+			return errors.New("encryption context names must not be empty")
+		}
+		for _, reserved := range encryptionContextNames {
+			if name == reserved {
+				return fmt.Errorf("encryption context name %q is reserved for associated data", name)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeEncryptionContexts returns a new map with the pairs of a and b. The
+// pairs of b replace the ones of a with the same name.
+func mergeEncryptionContexts(a, b map[string]string) map[string]string {
+	merged := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
+
+// encryptionContextFallback configures the fallback to the other encryption
+// context name, see [WithEncryptionContextNameFallback].
+type encryptionContextFallback struct {
+	// report is called when decryption succeeds with the other name. It may
+	// be nil.
+	report func(keyURI string, name EncryptionContextName)
+}
+
+// other returns the encryption context name which is not n.
+func (n EncryptionContextName) other() EncryptionContextName {
+	if n == LegacyAdditionalData {
+		return AssociatedData
+	}
+	return LegacyAdditionalData
+}
+
+// WithEncryptionContextNameFallback enables a mode to migrate ciphertexts
+// between encryption context names: AEADs decrypt ciphertexts with the
+// encryption context name of the client, see [WithEncryptionContextName], and
+// when decryption fails with [ErrInvalidCiphertext], with the other name.
+// Encryption always uses the name of the client.
+//
+// When decryption succeeds with the other name, report is called, if not nil,
+// with the key URI of the AEAD and the name. This allows finding ciphertexts
+// which use the other name, to re-encrypt them. [AWSAEAD.DecryptAndReportName]
+// also returns the name.
+//
+// Falling back makes a second request to AWS KMS for ciphertexts which use the
+// other name, and for ciphertexts which are invalid. Ciphertexts without
+// associated data have no encryption context, so there is no fallback for
+// them.
+func WithEncryptionContextNameFallback(report func(keyURI string, name EncryptionContextName)) ClientOption {
+	return option(func(a *Client) error {
+		if a.encryptionContextFallback != nil {
+			return errors.New("WithEncryptionContextNameFallback option cannot be used, fallback already enabled")
+		}
+		a.encryptionContextFallback = &encryptionContextFallback{report: report}
+		return nil
+	})
+}
+
+// DecryptAndReportName decrypts the ciphertext and verifies the associated
+// data, like [AWSAEAD.DecryptWithContext]. It also returns the encryption
+// context name with which decryption succeeded, which is the name of the
+// client unless the client has [WithEncryptionContextNameFallback] and
+// decryption fell back to the other name. If decryption fails, the returned
+// name is 0.
+func (a *AWSAEAD) DecryptAndReportName(ctx context.Context, ciphertext, associatedData []byte, opts ...request.Option) ([]byte, EncryptionContextName, error) {
+	plaintext, err := a.decrypt(ctx, a.decryptInput(a.encryptionContextName, ciphertext, associatedData), opts...)
+	if err == nil {
+		return plaintext, a.encryptionContextName, nil
+	}
+	if a.fallback == nil || len(associatedData) == 0 || !errors.Is(err, ErrInvalidCiphertext) {
+		return nil, 0, err
+	}
+	name := a.encryptionContextName.other()
+	plaintext, fallbackErr := a.decrypt(ctx, a.decryptInput(name, ciphertext, associatedData), opts...)
+	if fallbackErr != nil {
+		// The error of the name of the client is more relevant for invalid
+		// ciphertexts.
+		return nil, 0, err
+	}
+	if a.fallback.report != nil {
+		a.fallback.report(awsPrefix+a.keyURI, name)
+	}
+	return plaintext, name, nil
+}
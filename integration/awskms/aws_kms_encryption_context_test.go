@@ -0,0 +1,377 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+func TestEncryptionContextNameFallback(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associated data")
+	for _, tc := range []struct {
+		name           string
+		encryptionName EncryptionContextName
+		decryptionName EncryptionContextName
+	}{
+		{"legacy ciphertext", LegacyAdditionalData, AssociatedData},
+		{"new ciphertext", AssociatedData, LegacyAdditionalData},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			encrypter, err := newTestClient(t, fakekms, WithEncryptionContextName(tc.encryptionName)).GetAEADWithOptions(symmetricKeyURI)
+			if err != nil {
+				t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+			}
+			ciphertext, err := encrypter.Encrypt(plaintext, associatedData)
+			if err != nil {
+				t.Fatalf("encrypter.Encrypt() err = %v, want nil", err)
+			}
+
+			var reportedURI string
+			var reportedName EncryptionContextName
+			report := func(keyURI string, name EncryptionContextName) {
+				reportedURI, reportedName = keyURI, name
+			}
+			a, err := newTestClient(t, fakekms, WithEncryptionContextName(tc.decryptionName), WithEncryptionContextNameFallback(report)).GetAEADWithOptions(symmetricKeyURI)
+			if err != nil {
+				t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+			}
+			decrypted, err := a.Decrypt(ciphertext, associatedData)
+			if err != nil {
+				t.Fatalf("a.Decrypt() err = %v, want nil", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("a.Decrypt() = %q, want %q", decrypted, plaintext)
+			}
+			if want := "aws-kms://" + symmetricKeyARN; reportedURI != want || reportedName != tc.encryptionName {
+				t.Errorf("reported (%q, %v), want (%q, %v)", reportedURI, reportedName, want, tc.encryptionName)
+			}
+
+			_, name, err := a.DecryptAndReportName(context.Background(), ciphertext, associatedData)
+			if err != nil {
+				t.Fatalf("a.DecryptAndReportName() err = %v, want nil", err)
+			}
+			if name != tc.encryptionName {
+				t.Errorf("a.DecryptAndReportName() name = %v, want %v", name, tc.encryptionName)
+			}
+		})
+	}
+}
+
+func TestDecryptAndReportNameWithoutFallback(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	associatedData := []byte("associated data")
+	legacy, err := newTestClient(t, fakekms, WithEncryptionContextName(LegacyAdditionalData)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	legacyCiphertext, err := legacy.Encrypt([]byte("plaintext"), associatedData)
+	if err != nil {
+		t.Fatalf("legacy.Encrypt() err = %v, want nil", err)
+	}
+
+	a, err := newTestClient(t, fakekms).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), associatedData)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if _, name, err := a.DecryptAndReportName(context.Background(), ciphertext, associatedData); err != nil || name != AssociatedData {
+		t.Errorf("a.DecryptAndReportName() = (%v, %v), want (%v, nil)", name, err, AssociatedData)
+	}
+	if _, _, err := a.DecryptAndReportName(context.Background(), legacyCiphertext, associatedData); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("a.DecryptAndReportName() err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestEncryptionContextNameFallbackFailsWithInvalidCiphertext(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	reported := false
+	a, err := newTestClient(t, fakekms, WithEncryptionContextNameFallback(func(string, EncryptionContextName) { reported = true })).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), []byte("associated data"))
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	_, name, err := a.DecryptAndReportName(context.Background(), ciphertext, []byte("wrong associated data"))
+	if !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("a.DecryptAndReportName() err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+	if name != 0 {
+		t.Errorf("a.DecryptAndReportName() name = %v, want 0", name)
+	}
+	if reported {
+		t.Error("report was called, want not called")
+	}
+}
+
+func TestWithEncryptionContextNameFallbackTwiceFails(t *testing.T) {
+	if _, err := NewClientWithOptions("aws-kms://", WithEncryptionContextNameFallback(nil), WithEncryptionContextNameFallback(nil)); err == nil {
+		t.Error("NewClientWithOptions() err = nil, want error")
+	}
+}
+
+// recordEncryptionContexts returns an interceptor which records the encryption
+// contexts of Encrypt requests.
+func recordEncryptionContexts(contexts *[]map[string]string) Interceptor {
+	return func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		if req, ok := call.Request.(*kms.EncryptInput); ok {
+			*contexts = append(*contexts, aws.StringValueMap(req.EncryptionContext))
+		}
+		return invoke(ctx, call)
+	}
+}
+
+func TestWithEncryptionContext(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var contexts []map[string]string
+	client := newTestClient(t, fakekms,
+		WithEncryptionContext(map[string]string{"tenant": "example", "service": "billing"}),
+		WithInterceptors(recordEncryptionContexts(&contexts)))
+	a, err := client.GetAEADWithOptions(symmetricKeyURI,
+		WithAEADEncryptionContext(map[string]string{"service": "payments", "purpose": "test"}))
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions() err = %v, want nil", err)
+	}
+
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associated data")
+	ciphertext, err := a.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	want := map[string]string{
+		"tenant":         "example",
+		"service":        "payments",
+		"purpose":        "test",
+		"associatedData": "6173736f6369617465642064617461",
+	}
+	if len(contexts) != 1 || len(contexts[0]) != len(want) {
+		t.Fatalf("encryption contexts = %v, want [%v]", contexts, want)
+	}
+	for k, v := range want {
+		if contexts[0][k] != v {
+			t.Errorf("encryption context %q = %q, want %q", k, contexts[0][k], v)
+		}
+	}
+
+	decrypted, err := a.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Decrypt() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("a.Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+	// The ciphertext is bound to the encryption context.
+	other, err := newTestClient(t, fakekms, WithEncryptionContext(map[string]string{"tenant": "example"})).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := other.Decrypt(ciphertext, associatedData); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("other.Decrypt() err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestWithEncryptionContextWithoutAssociatedData(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var contexts []map[string]string
+	a, err := newTestClient(t, fakekms,
+		WithEncryptionContext(map[string]string{"tenant": "example"}),
+		WithInterceptors(recordEncryptionContexts(&contexts))).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if len(contexts) != 1 || len(contexts[0]) != 1 || contexts[0]["tenant"] != "example" {
+		t.Errorf("encryption contexts = %v, want [map[tenant:example]]", contexts)
+	}
+	if _, err := a.Decrypt(ciphertext, nil); err != nil {
+		t.Errorf("a.Decrypt() err = %v, want nil", err)
+	}
+}
+
+func TestReEncryptWithEncryptionContext(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	c := newTestClient(t, fakekms, WithEncryptionContext(map[string]string{"tenant": "example"}))
+	associatedData := []byte("associated data")
+	source, err := c.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	destination, err := c.GetAEADWithOptions(otherSymmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(otherSymmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := source.Encrypt([]byte("plaintext"), associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	reEncrypted, err := c.ReEncrypt(context.Background(), "aws-kms://"+symmetricKeyARN, "aws-kms://"+otherSymmetricKeyARN, ciphertext, associatedData, associatedData)
+	if err != nil {
+		t.Fatalf("c.ReEncrypt() err = %v, want nil", err)
+	}
+	if _, err := destination.Decrypt(reEncrypted, associatedData); err != nil {
+		t.Errorf("Decrypt() err = %v, want nil", err)
+	}
+}
+
+func TestEncryptionContextWithReservedNamesFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	for _, ec := range []map[string]string{
+		nil,
+		{"": "value"},
+		{"associatedData": "value"},
+		{"tenant": "example", "additionalData": "value"},
+	} {
+		if _, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithEncryptionContext(ec)); err == nil {
+			t.Errorf("NewClientWithOptions() with WithEncryptionContext(%v) err = nil, want error", ec)
+		}
+		if _, err := newTestClient(t, fakekms).GetAEADWithOptions(symmetricKeyURI, WithAEADEncryptionContext(ec)); err == nil {
+			t.Errorf("client.GetAEADWithOptions() with WithAEADEncryptionContext(%v) err = nil, want error", ec)
+		}
+	}
+}
+
+func TestWithEncryptionContextTwiceFails(t *testing.T) {
+	ec := map[string]string{"tenant": "example"}
+	if _, err := NewClientWithOptions("aws-kms://", WithEncryptionContext(ec), WithEncryptionContext(ec)); err == nil {
+		t.Error("NewClientWithOptions() err = nil, want error")
+	}
+}
+
+func TestEncryptWithEncryptionContext(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var contexts []map[string]string
+	a, err := newTestClient(t, fakekms,
+		WithEncryptionContext(map[string]string{"tenant": "example"}),
+		WithInterceptors(recordEncryptionContexts(&contexts))).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	ec := map[string]string{"table": "users", "column": "email"}
+	ciphertext, err := a.EncryptWithEncryptionContext(context.Background(), plaintext, ec)
+	if err != nil {
+		t.Fatalf("a.EncryptWithEncryptionContext() err = %v, want nil", err)
+	}
+	want := map[string]string{"tenant": "example", "table": "users", "column": "email"}
+	if len(contexts) != 1 || len(contexts[0]) != len(want) {
+		t.Fatalf("encryption contexts = %v, want [%v]", contexts, want)
+	}
+	for k, v := range want {
+		if contexts[0][k] != v {
+			t.Errorf("encryption context %q = %q, want %q", k, contexts[0][k], v)
+		}
+	}
+
+	// The order of the pairs doesn't matter.
+	decrypted, err := a.DecryptWithEncryptionContext(context.Background(), ciphertext, map[string]string{"column": "email", "table": "users"})
+	if err != nil {
+		t.Fatalf("a.DecryptWithEncryptionContext() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("a.DecryptWithEncryptionContext() = %q, want %q", decrypted, plaintext)
+	}
+	if _, err := a.DecryptWithEncryptionContext(context.Background(), ciphertext, map[string]string{"table": "users"}); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("a.DecryptWithEncryptionContext() with other pairs err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+	if _, err := a.Decrypt(ciphertext, nil); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("a.Decrypt() err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestEncryptWithEncryptionContextWithoutPairs(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.EncryptWithEncryptionContext(context.Background(), []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.EncryptWithEncryptionContext() err = %v, want nil", err)
+	}
+	// Without pairs, ciphertexts are compatible with the tink.AEAD methods
+	// without associated data.
+	if _, err := a.Decrypt(ciphertext, nil); err != nil {
+		t.Errorf("a.Decrypt() err = %v, want nil", err)
+	}
+}
+
+func TestEncryptWithEncryptionContextFailsWithInvalidPairs(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithEncryptionContext(map[string]string{"tenant": "example"})).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	for _, ec := range []map[string]string{
+		{"associatedData": "value"},
+		{"additionalData": "value"},
+		{"tenant": "other"},
+	} {
+		if _, err := a.EncryptWithEncryptionContext(context.Background(), []byte("plaintext"), ec); err == nil {
+			t.Errorf("a.EncryptWithEncryptionContext(%v) err = nil, want error", ec)
+		}
+		if _, err := a.DecryptWithEncryptionContext(context.Background(), []byte("ciphertext"), ec); err == nil {
+			t.Errorf("a.DecryptWithEncryptionContext(%v) err = nil, want error", ec)
+		}
+	}
+}
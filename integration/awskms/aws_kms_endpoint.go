@@ -0,0 +1,182 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// regionPlaceholder is replaced by the region of the KMS client in the
+// endpoint of [WithEndpoint].
+const regionPlaceholder = "{region}"
+
+var (
+	// fipsPartitions are the partitions in which AWS KMS has FIPS endpoints.
+	fipsPartitions = map[string]bool{"aws": true, "aws-us-gov": true}
+	// dualStackPartitions are the partitions in which AWS KMS has dual-stack
+	// endpoints.
+	dualStackPartitions = map[string]bool{"aws": true, "aws-cn": true, "aws-us-gov": true}
+	// endpointPartitions maps the DNS suffixes of AWS endpoints to the
+	// partitions which use them.
+	endpointPartitions = map[string][]string{
+		".amazonaws.com":                {"aws", "aws-us-gov"},
+		".api.aws":                      {"aws", "aws-us-gov"},
+		".amazonaws.com.cn":             {"aws-cn"},
+		".api.amazonwebservices.com.cn": {"aws-cn"},
+		".c2s.ic.gov":                   {"aws-iso"},
+		".sc2s.sgov.gov":                {"aws-iso-b"},
+	}
+)
+
+// WithEndpoint makes the KMS clients created by the client send their requests
+// to endpoint instead of the endpoint of their region, for example to an
+// interface VPC endpoint (AWS PrivateLink) or to a local KMS emulator.
+//
+// endpoint is a URL such as
+// "https://vpce-0123456789abcdef0-abcdefgh.kms.us-east-2.vpce.amazonaws.com".
+// If it contains "{region}", it is replaced by the region of each KMS client,
+// so that keys of several regions can use different endpoints. Requests are
+// still signed for the region of the key.
+//
+// The endpoint must belong to the partition of the URI prefix and of the key
+// URIs passed to the client, if it is an AWS endpoint. WithEndpoint can't be
+// combined with [WithFIPS] or [WithDualStack].
+func WithEndpoint(endpoint string) ClientOption {
+	return option(func(a *Client) error {
+		if a.kms != nil {
+			return errors.New("WithEndpoint option cannot be used, KMS client already set")
+		}
+		if a.endpoint != "" {
+			return errors.New("WithEndpoint option cannot be used, endpoint already set")
+		}
+		if _, err := endpointHost(endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+		}
+		a.endpoint = endpoint
+		return nil
+	})
+}
+
+// WithFIPS makes the KMS clients created by the client use the FIPS endpoints
+// of AWS KMS, such as kms-fips.us-east-2.amazonaws.com.
+//
+// AWS KMS has FIPS endpoints in the aws and aws-us-gov partitions only. The
+// URI prefix and key URIs passed to the client must be in one of them.
+func WithFIPS() ClientOption {
+	return option(func(a *Client) error {
+		if a.kms != nil {
+			return errors.New("WithFIPS option cannot be used, KMS client already set")
+		}
+		if a.fips {
+			return errors.New("WithFIPS option cannot be used, FIPS endpoints already enabled")
+		}
+		a.fips = true
+		return nil
+	})
+}
+
+// WithDualStack makes the KMS clients created by the client use the
+// dual-stack endpoints of AWS KMS, which support both IPv4 and IPv6, such as
+// kms.us-east-2.api.aws. It can be combined with [WithFIPS].
+//
+// AWS KMS has dual-stack endpoints in the aws, aws-cn and aws-us-gov
+// partitions only. The URI prefix and key URIs passed to the client must be in
+// one of them.
+func WithDualStack() ClientOption {
+	return option(func(a *Client) error {
+		if a.kms != nil {
+			return errors.New("WithDualStack option cannot be used, KMS client already set")
+		}
+		if a.dualStack {
+			return errors.New("WithDualStack option cannot be used, dual-stack endpoints already enabled")
+		}
+		a.dualStack = true
+		return nil
+	})
+}
+
+// endpointHost validates the endpoint of [WithEndpoint] and returns its host.
+func endpointHost(endpoint string) (string, error) {
+	u, err := url.Parse(strings.ReplaceAll(endpoint, regionPlaceholder, "region"))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", errors.New("the scheme must be https or http")
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("the host must not be empty")
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", errors.New("the URL must not have a query, fragment or user")
+	}
+	return u.Hostname(), nil
+}
+
+// checkEndpointOptions checks that the endpoint options set on the client can
+// be combined, and that they are supported in partition, if not empty.
+func (c *Client) checkEndpointOptions(partition string) error {
+	if c.endpoint != "" && (c.fips || c.dualStack) {
+		return errors.New("WithEndpoint option cannot be used with WithFIPS or WithDualStack")
+	}
+	if partition == "" {
+		return nil
+	}
+	if c.fips && !fipsPartitions[partition] {
+		return fmt.Errorf("WithFIPS option cannot be used, AWS KMS has no FIPS endpoints in partition %s", partition)
+	}
+	if c.dualStack && !dualStackPartitions[partition] {
+		return fmt.Errorf("WithDualStack option cannot be used, AWS KMS has no dual-stack endpoints in partition %s", partition)
+	}
+	if c.endpoint == "" {
+		return nil
+	}
+	host, err := endpointHost(c.endpoint)
+	if err != nil {
+		return err
+	}
+	for suffix, partitions := range endpointPartitions {
+		if strings.HasSuffix(host, suffix) && !slices.Contains(partitions, partition) {
+			return fmt.Errorf("WithEndpoint option cannot be used, endpoint %s is not in partition %s", c.endpoint, partition)
+		}
+	}
+	return nil
+}
+
+// kmsConfig returns the configuration of the KMS client for region set by the
+// endpoint options. It only applies to AWS KMS requests, not to the requests
+// made to get credentials.
+func (c *Client) kmsConfig(region string) *aws.Config {
+	cfg := &aws.Config{}
+	if c.endpoint != "" {
+		cfg.Endpoint = aws.String(strings.ReplaceAll(c.endpoint, regionPlaceholder, region))
+	}
+	if c.fips {
+		cfg.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if c.dualStack {
+		cfg.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+	return cfg
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+func TestWithEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		endpoint     string
+		wantEndpoint string
+	}{
+		{"emulator", "http://localhost:4566", "http://localhost:4566"},
+		{"VPC endpoint", "https://vpce-0123456789abcdef0-abcdefgh.kms.eu-west-1.vpce.amazonaws.com", "https://vpce-0123456789abcdef0-abcdefgh.kms.eu-west-1.vpce.amazonaws.com"},
+		{"region placeholder", "https://kms.{region}.example.com", "https://kms.eu-west-1.example.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClientWithOptions("aws-kms://arn:aws:kms:eu-west-1:", WithEndpoint(tc.endpoint))
+			if err != nil {
+				t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+			}
+			cfg := client.(*Client).kmsConfig("eu-west-1")
+			if got := aws.StringValue(cfg.Endpoint); got != tc.wantEndpoint {
+				t.Errorf("kmsConfig().Endpoint = %q, want %q", got, tc.wantEndpoint)
+			}
+		})
+	}
+}
+
+func TestWithFIPSAndDualStack(t *testing.T) {
+	for _, uriPrefix := range []string{
+		"aws-kms://",
+		"aws-kms://arn:aws:kms:us-east-2:",
+		"aws-kms://arn:aws-us-gov:kms:us-gov-west-1:",
+	} {
+		client, err := NewClientWithOptions(uriPrefix, WithFIPS(), WithDualStack())
+		if err != nil {
+			t.Fatalf("NewClientWithOptions(%q) err = %v, want nil", uriPrefix, err)
+		}
+		cfg := client.(*Client).kmsConfig("us-east-2")
+		if cfg.UseFIPSEndpoint != endpoints.FIPSEndpointStateEnabled {
+			t.Errorf("kmsConfig().UseFIPSEndpoint = %v, want %v", cfg.UseFIPSEndpoint, endpoints.FIPSEndpointStateEnabled)
+		}
+		if cfg.UseDualStackEndpoint != endpoints.DualStackEndpointStateEnabled {
+			t.Errorf("kmsConfig().UseDualStackEndpoint = %v, want %v", cfg.UseDualStackEndpoint, endpoints.DualStackEndpointStateEnabled)
+		}
+		if cfg.Endpoint != nil {
+			t.Errorf("kmsConfig().Endpoint = %q, want nil", aws.StringValue(cfg.Endpoint))
+		}
+	}
+}
+
+func TestEndpointOptionsAreValidatedAgainstKeyURIPartition(t *testing.T) {
+	client, err := NewClientWithOptions("aws-kms://", WithFIPS())
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+	}
+	if _, err := client.GetAEAD("aws-kms://" + symmetricKeyARN); err != nil {
+		t.Errorf("client.GetAEAD() in partition aws err = %v, want nil", err)
+	}
+	if _, err := client.GetAEAD("aws-kms://arn:aws-cn:kms:cn-north-1:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"); err == nil {
+		t.Error("client.GetAEAD() in partition aws-cn err = nil, want error")
+	}
+}
+
+func TestEndpointOptionsFail(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name      string
+		uriPrefix string
+		opts      []ClientOption
+	}{
+		{"empty endpoint", "aws-kms://", []ClientOption{WithEndpoint("")}},
+		{"endpoint without scheme", "aws-kms://", []ClientOption{WithEndpoint("kms.example.com")}},
+		{"endpoint with unsupported scheme", "aws-kms://", []ClientOption{WithEndpoint("ftp://kms.example.com")}},
+		{"endpoint with query", "aws-kms://", []ClientOption{WithEndpoint("https://kms.example.com?region=us-east-2")}},
+		{"repeated endpoint", "aws-kms://", []ClientOption{WithEndpoint("https://kms.example.com"), WithEndpoint("https://kms.example.com")}},
+		{"endpoint and FIPS", "aws-kms://", []ClientOption{WithEndpoint("https://kms.example.com"), WithFIPS()}},
+		{"dual-stack and endpoint", "aws-kms://", []ClientOption{WithDualStack(), WithEndpoint("https://kms.example.com")}},
+		{"endpoint of another partition", "aws-kms://arn:aws:kms:us-east-2:", []ClientOption{WithEndpoint("https://kms.cn-north-1.amazonaws.com.cn")}},
+		{"repeated FIPS", "aws-kms://", []ClientOption{WithFIPS(), WithFIPS()}},
+		{"FIPS in partition aws-cn", "aws-kms://arn:aws-cn:kms:cn-north-1:", []ClientOption{WithFIPS()}},
+		{"repeated dual-stack", "aws-kms://", []ClientOption{WithDualStack(), WithDualStack()}},
+		{"dual-stack in partition aws-iso", "aws-kms://arn:aws-iso:kms:us-iso-east-1:", []ClientOption{WithDualStack()}},
+		{"KMS client and endpoint", "aws-kms://", []ClientOption{WithKMS(fakekms), WithEndpoint("https://kms.example.com")}},
+		{"FIPS and KMS client", "aws-kms://", []ClientOption{WithFIPS(), WithKMS(fakekms)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions(tc.uriPrefix, tc.opts...); err == nil {
+				t.Error("NewClientWithOptions() err = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go/v2/aead/subtle"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+const (
+	// envelopeVersion1 is the version byte of ciphertexts produced by
+	// [EnvelopeAEAD].
+	envelopeVersion1 = 0x01
+	// envelopeHeaderSize is the size of the version byte and the encrypted data
+	// key length.
+	envelopeHeaderSize = 1 + 4
+	// maxEncryptedDataKeySize is the largest ciphertext blob AWS KMS returns.
+	maxEncryptedDataKeySize = 6144
+	// dataKeySize is the size of AES-256 data keys.
+	dataKeySize = 32
+)
+
+// EnvelopeAEAD is an implementation of the AEAD interface which performs
+// envelope encryption with data keys generated by AWS KMS.
+//
+// Each call to Encrypt requests a new 256-bit data key via the AWS KMS
+// GenerateDataKey API and encrypts the plaintext locally with AES-256-GCM
+// under it. The data key is never stored; the ciphertext carries the data key
+// encrypted by the KMS key instead, which Decrypt unwraps via the AWS KMS
+// Decrypt API. Every data key is thus generated with KMS entropy and recorded
+// in AWS CloudTrail.
+//
+// Ciphertexts have the following format, version 1:
+//
+//	version (1 byte, 0x01)
+//	|| encrypted data key length (4 bytes, big-endian)
+//	|| encrypted data key (KMS ciphertext blob)
+//	|| AES-256-GCM ciphertext (12 bytes IV || ciphertext || 16 bytes tag)
+//
+// The associated data of AES-GCM is the header, made of all the bytes before
+// the AES-GCM ciphertext, followed by the associated data of the caller, so
+// the header cannot be altered either. Associated data is not sent to AWS KMS.
+// Data keys are requested with the encryption context set by
+// [WithEncryptionContext] and the grant tokens set by [WithGrantTokens].
+//
+// Ciphertexts are not compatible with those of aead.NewKMSEnvelopeAEAD2.
+type EnvelopeAEAD struct {
+	keyURI        string
+	kms           kmsiface.KMSAPI
+	staticContext map[string]string
+	grantTokens   []string
+}
+
+var _ tink.AEAD = (*EnvelopeAEAD)(nil)
+
+// Encrypt encrypts the plaintext with associatedData.
+func (e *EnvelopeAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	return e.EncryptWithContext(context.Background(), plaintext, associatedData)
+}
+
+// EncryptWithContext encrypts the plaintext with associatedData.
+//
+// ctx is used for the GenerateDataKey request, and opts are applied to it.
+func (e *EnvelopeAEAD) EncryptWithContext(ctx context.Context, plaintext, associatedData []byte, opts ...request.Option) ([]byte, error) {
+	resp, err := e.generateDataKey(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(resp.Plaintext)
+	return encryptEnvelope(resp.Plaintext, resp.CiphertextBlob, plaintext, associatedData)
+}
+
+// generateDataKey requests a new data key via the AWS KMS GenerateDataKey API.
+func (e *EnvelopeAEAD) generateDataKey(ctx context.Context, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	resp, err := e.kms.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(e.keyURI),
+		KeySpec:           aws.String(kms.DataKeySpecAes256),
+		EncryptionContext: e.dataKeyEncryptionContext(),
+		GrantTokens:       kmsGrantTokens(e.grantTokens),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Plaintext) != dataKeySize {
+		zeroize(resp.Plaintext)
+		return nil, fmt.Errorf("data key has %d bytes, want %d", len(resp.Plaintext), dataKeySize)
+	}
+	return resp, nil
+}
+
+// NewEncryptedDataKey returns a new data key encrypted under the KMS key, via
+// the AWS KMS GenerateDataKeyWithoutPlaintext API.
+//
+// This allows data keys to be provisioned ahead of time, by principals which
+// are not allowed to see them. Use [EnvelopeAEAD.EncryptWithDataKey] to
+// encrypt with such a key.
+//
+// ctx is used for the KMS request, and opts are applied to it.
+func (e *EnvelopeAEAD) NewEncryptedDataKey(ctx context.Context, opts ...request.Option) ([]byte, error) {
+	resp, err := e.kms.GenerateDataKeyWithoutPlaintextWithContext(ctx, &kms.GenerateDataKeyWithoutPlaintextInput{
+		KeyId:             aws.String(e.keyURI),
+		KeySpec:           aws.String(kms.DataKeySpecAes256),
+		EncryptionContext: e.dataKeyEncryptionContext(),
+		GrantTokens:       kmsGrantTokens(e.grantTokens),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// EncryptWithDataKey encrypts the plaintext with associatedData under
+// encryptedDataKey, a data key returned by [EnvelopeAEAD.NewEncryptedDataKey].
+//
+// The data key is decrypted via the AWS KMS Decrypt API. The ciphertext has
+// the same format as those returned by Encrypt. As all ciphertexts encrypted
+// with the same data key share it, callers must not encrypt more than 2^32
+// messages with one data key.
+//
+// ctx is used for the Decrypt request, and opts are applied to it.
+func (e *EnvelopeAEAD) EncryptWithDataKey(ctx context.Context, encryptedDataKey, plaintext, associatedData []byte, opts ...request.Option) ([]byte, error) {
+	dataKey, err := e.decryptDataKey(ctx, encryptedDataKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(dataKey)
+	return encryptEnvelope(dataKey, encryptedDataKey, plaintext, associatedData)
+}
+
+// Decrypt decrypts the ciphertext and verifies the associated data.
+func (e *EnvelopeAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	return e.DecryptWithContext(context.Background(), ciphertext, associatedData)
+}
+
+// DecryptWithContext decrypts the ciphertext and verifies the associated data.
+//
+// ctx is used for the KMS Decrypt request, and opts are applied to it.
+func (e *EnvelopeAEAD) DecryptWithContext(ctx context.Context, ciphertext, associatedData []byte, opts ...request.Option) ([]byte, error) {
+	header, encryptedDataKey, payload, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.decryptDataKey(ctx, encryptedDataKey, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroize(dataKey)
+	return decryptPayload(dataKey, header, payload, associatedData)
+}
+
+func (e *EnvelopeAEAD) decryptDataKey(ctx context.Context, encryptedDataKey []byte, opts ...request.Option) ([]byte, error) {
+	resp, err := e.kms.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(e.keyURI),
+		CiphertextBlob:    encryptedDataKey,
+		EncryptionContext: e.dataKeyEncryptionContext(),
+		GrantTokens:       kmsGrantTokens(e.grantTokens),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Plaintext) != dataKeySize {
+		zeroize(resp.Plaintext)
+		return nil, fmt.Errorf("data key has %d bytes, want %d", len(resp.Plaintext), dataKeySize)
+	}
+	return resp.Plaintext, nil
+}
+
+// dataKeyEncryptionContext returns the encryption context of the AWS KMS
+// requests for data keys, made of the pairs set by [WithEncryptionContext]
+// only.
+func (e *EnvelopeAEAD) dataKeyEncryptionContext() map[string]*string {
+	return encryptionContext(e.staticContext, AssociatedData, nil)
+}
+
+// encryptEnvelope encrypts plaintext under dataKey and returns the ciphertext
+// in the version 1 format.
+func encryptEnvelope(dataKey, encryptedDataKey, plaintext, associatedData []byte) ([]byte, error) {
+	if len(encryptedDataKey) == 0 || len(encryptedDataKey) > maxEncryptedDataKeySize {
+		return nil, fmt.Errorf("invalid encrypted data key length %d", len(encryptedDataKey))
+	}
+	dem, err := subtle.NewAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(encryptedDataKey))
+	header[0] = envelopeVersion1
+	binary.BigEndian.PutUint32(header[1:envelopeHeaderSize], uint32(len(encryptedDataKey)))
+	header = append(header, encryptedDataKey...)
+	payload, err := dem.Encrypt(plaintext, envelopeAssociatedData(header, associatedData))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, payload...), nil
+}
+
+// parseEnvelope splits a version 1 ciphertext into its header, the encrypted
+// data key within the header, and the AES-GCM ciphertext.
+func parseEnvelope(ciphertext []byte) (header, encryptedDataKey, payload []byte, err error) {
+	if len(ciphertext) < envelopeHeaderSize {
+		return nil, nil, nil, errors.New("ciphertext too short")
+	}
+	if ciphertext[0] != envelopeVersion1 {
+		return nil, nil, nil, fmt.Errorf("unsupported ciphertext version %d", ciphertext[0])
+	}
+	n := binary.BigEndian.Uint32(ciphertext[1:envelopeHeaderSize])
+	if n == 0 || n > maxEncryptedDataKeySize || int(n) > len(ciphertext)-envelopeHeaderSize {
+		return nil, nil, nil, fmt.Errorf("invalid encrypted data key length %d", n)
+	}
+	headerSize := envelopeHeaderSize + int(n)
+	return ciphertext[:headerSize], ciphertext[envelopeHeaderSize:headerSize], ciphertext[headerSize:], nil
+}
+
+func decryptPayload(dataKey, header, payload, associatedData []byte) ([]byte, error) {
+	dem, err := subtle.NewAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return dem.Decrypt(payload, envelopeAssociatedData(header, associatedData))
+}
+
+// envelopeAssociatedData returns the associated data of the AES-GCM ciphertext
+// of an envelope with header and associatedData.
+func envelopeAssociatedData(header, associatedData []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(associatedData))
+	ad = append(ad, header...)
+	return append(ad, associatedData...)
+}
+
+// zeroize overwrites b with zeros.
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// GetEnvelopeAEAD returns an [*EnvelopeAEAD] which performs envelope
+// encryption with data keys generated by AWS KMS under the key keyURI.
+//
+// keyURI must be supported by this client and must have the same format as
+// for [Client.GetAEAD].
+func (c *Client) GetEnvelopeAEAD(keyURI string) (*EnvelopeAEAD, error) {
+	uri, k, err := c.keyFor(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvelopeAEAD{
+		keyURI:        uri,
+		kms:           k,
+		staticContext: c.staticContext,
+		grantTokens:   c.grantTokens,
+	}, nil
+}
@@ -0,0 +1,331 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// dataKeyRecordingKMS records the data keys returned by GenerateDataKey.
+type dataKeyRecordingKMS struct {
+	kmsiface.KMSAPI
+	dataKeys [][]byte
+}
+
+func (d *dataKeyRecordingKMS) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	resp, err := d.KMSAPI.GenerateDataKeyWithContext(ctx, input, opts...)
+	if err == nil {
+		d.dataKeys = append(d.dataKeys, resp.Plaintext)
+	}
+	return resp, err
+}
+
+func TestEnvelopeAEADEncryptDecrypt(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	recordingKMS := &dataKeyRecordingKMS{KMSAPI: fakekms}
+	a, err := newTestClient(t, recordingKMS).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associatedData")
+	ciphertext, err := a.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Encrypt(plaintext, associatedData) err = %v, want nil", err)
+	}
+	decrypted, err := a.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Decrypt(ciphertext, associatedData) err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+	if _, err := a.Decrypt(ciphertext, []byte("invalidAssociatedData")); err == nil {
+		t.Error("a.Decrypt(ciphertext, invalidAssociatedData) err = nil, want error")
+	}
+
+	if len(recordingKMS.dataKeys) != 1 {
+		t.Fatalf("len(recordingKMS.dataKeys) = %d, want 1", len(recordingKMS.dataKeys))
+	}
+	if !bytes.Equal(recordingKMS.dataKeys[0], make([]byte, 32)) {
+		t.Error("data key was not zeroized after encryption")
+	}
+}
+
+func TestEnvelopeAEADCiphertextFormat(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	plaintext := []byte("plaintext")
+	ciphertext, err := a.Encrypt(plaintext, nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt(plaintext, nil) err = %v, want nil", err)
+	}
+	if ciphertext[0] != 0x01 {
+		t.Errorf("ciphertext[0] = %d, want 1", ciphertext[0])
+	}
+	n := int(binary.BigEndian.Uint32(ciphertext[1:5]))
+	encryptedDataKey := ciphertext[5 : 5+n]
+	payload := ciphertext[5+n:]
+	if got, want := len(payload), 12+len(plaintext)+16; got != want {
+		t.Errorf("len(payload) = %d, want %d", got, want)
+	}
+	resp, err := fakekms.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(symmetricKeyARN),
+		CiphertextBlob: encryptedDataKey,
+	})
+	if err != nil {
+		t.Fatalf("fakekms.Decrypt(encryptedDataKey) err = %v, want nil", err)
+	}
+	if len(resp.Plaintext) != 32 {
+		t.Errorf("len(dataKey) = %d, want 32", len(resp.Plaintext))
+	}
+}
+
+func TestEnvelopeAEADDecryptInvalidCiphertextFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	unsupportedVersion := append([]byte{0x02}, ciphertext[1:]...)
+	tooLongDataKey := append([]byte{}, ciphertext...)
+	binary.BigEndian.PutUint32(tooLongDataKey[1:5], uint32(len(ciphertext)))
+	tamperedPayload := append([]byte{}, ciphertext...)
+	tamperedPayload[len(tamperedPayload)-1] ^= 1
+
+	for _, tc := range []struct {
+		name       string
+		ciphertext []byte
+	}{
+		{"empty", nil},
+		{"header only", ciphertext[:5]},
+		{"unsupported version", unsupportedVersion},
+		{"too long data key", tooLongDataKey},
+		{"tampered payload", tamperedPayload},
+		{"truncated", ciphertext[:len(ciphertext)-1]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := a.Decrypt(tc.ciphertext, nil); err == nil {
+				t.Error("a.Decrypt() err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestEnvelopeAEADEncryptWithDataKey(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+
+	ctx := context.Background()
+	encryptedDataKey, err := a.NewEncryptedDataKey(ctx)
+	if err != nil {
+		t.Fatalf("a.NewEncryptedDataKey() err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associatedData")
+	ciphertext, err := a.EncryptWithDataKey(ctx, encryptedDataKey, plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("a.EncryptWithDataKey() err = %v, want nil", err)
+	}
+	decrypted, err := a.DecryptWithContext(ctx, ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("a.DecryptWithContext() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+
+	if _, err := a.EncryptWithDataKey(ctx, []byte("invalid"), plaintext, associatedData); err == nil {
+		t.Error("a.EncryptWithDataKey(invalid) err = nil, want error")
+	}
+}
+
+// shortDataKeyKMS returns data keys of 16 bytes from GenerateDataKey.
+type shortDataKeyKMS struct {
+	kmsiface.KMSAPI
+}
+
+func (s *shortDataKeyKMS) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	input.KeySpec = aws.String(kms.DataKeySpecAes128)
+	return s.KMSAPI.GenerateDataKeyWithContext(ctx, input, opts...)
+}
+
+func TestEnvelopeAEADEncryptWithShortDataKeyFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, &shortDataKeyKMS{KMSAPI: fakekms}).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err == nil {
+		t.Error("a.Encrypt() err = nil, want error")
+	}
+}
+
+func TestEnvelopeAEADAuthenticatesEncryptedDataKey(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ctx := context.Background()
+	encryptedDataKey, err := a.NewEncryptedDataKey(ctx)
+	if err != nil {
+		t.Fatalf("a.NewEncryptedDataKey() err = %v, want nil", err)
+	}
+	ciphertext, err := a.EncryptWithDataKey(ctx, encryptedDataKey, []byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.EncryptWithDataKey() err = %v, want nil", err)
+	}
+
+	// Wraps the same data key again, into another encrypted data key which
+	// AWS KMS decrypts successfully.
+	dataKey, err := fakekms.Decrypt(&kms.DecryptInput{KeyId: aws.String(symmetricKeyARN), CiphertextBlob: encryptedDataKey})
+	if err != nil {
+		t.Fatalf("fakekms.Decrypt() err = %v, want nil", err)
+	}
+	rewrapped, err := fakekms.Encrypt(&kms.EncryptInput{KeyId: aws.String(symmetricKeyARN), Plaintext: dataKey.Plaintext})
+	if err != nil {
+		t.Fatalf("fakekms.Encrypt() err = %v, want nil", err)
+	}
+	header, _, payload, err := parseEnvelope(ciphertext)
+	if err != nil {
+		t.Fatalf("parseEnvelope() err = %v, want nil", err)
+	}
+	substituted := append([]byte{header[0], 0, 0, 0, 0}, rewrapped.CiphertextBlob...)
+	binary.BigEndian.PutUint32(substituted[1:5], uint32(len(rewrapped.CiphertextBlob)))
+	substituted = append(substituted, payload...)
+
+	if _, err := a.Decrypt(substituted, nil); err == nil {
+		t.Error("a.Decrypt() with a substituted encrypted data key err = nil, want error")
+	}
+}
+
+func TestEnvelopeAEADWithEncryptionContextAndGrantTokens(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	grant, err := fakekms.CreateGrant(&kms.CreateGrantInput{
+		KeyId:            aws.String(symmetricKeyARN),
+		GranteePrincipal: aws.String(granteeRoleARN),
+		Operations:       aws.StringSlice([]string{kms.GrantOperationGenerateDataKey, kms.GrantOperationDecrypt}),
+	})
+	if err != nil {
+		t.Fatalf("fakekms.CreateGrant() err = %v, want nil", err)
+	}
+	token := aws.StringValue(grant.GrantToken)
+	var (
+		contexts []map[string]string
+		tokens   [][]string
+	)
+	record := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		switch req := call.Request.(type) {
+		case *kms.GenerateDataKeyInput:
+			contexts = append(contexts, aws.StringValueMap(req.EncryptionContext))
+			tokens = append(tokens, aws.StringValueSlice(req.GrantTokens))
+		case *kms.DecryptInput:
+			contexts = append(contexts, aws.StringValueMap(req.EncryptionContext))
+			tokens = append(tokens, aws.StringValueSlice(req.GrantTokens))
+		}
+		return invoke(ctx, call)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithEncryptionContext(map[string]string{"tenant": "a"}), WithGrantTokens(token), WithInterceptors(record))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.(*Client).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD() err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), []byte("associatedData"))
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if _, err := a.Decrypt(ciphertext, []byte("associatedData")); err != nil {
+		t.Fatalf("a.Decrypt() err = %v, want nil", err)
+	}
+	if len(contexts) != 2 {
+		t.Fatalf("made %d requests, want 2", len(contexts))
+	}
+	for i := range contexts {
+		if len(contexts[i]) != 1 || contexts[i]["tenant"] != "a" {
+			t.Errorf("request %d encryption context = %v, want map[tenant:a]", i, contexts[i])
+		}
+		if len(tokens[i]) != 1 || tokens[i][0] != token {
+			t.Errorf("request %d grant tokens = %q, want [%s]", i, tokens[i], token)
+		}
+	}
+
+	// The data key is bound to the encryption context.
+	other, err := newTestClient(t, fakekms).GetEnvelopeAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetEnvelopeAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := other.Decrypt(ciphertext, []byte("associatedData")); err == nil {
+		t.Error("Decrypt() without the encryption context err = nil, want error")
+	}
+}
+
+func TestGetEnvelopeAEADWithUnsupportedKeyURIFails(t *testing.T) {
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-west-2:", WithKMS(&dataKeyRecordingKMS{}))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	if _, err := client.(*Client).GetEnvelopeAEAD(symmetricKeyURI); err == nil {
+		t.Error("client.GetEnvelopeAEAD(symmetricKeyURI) err = nil, want error")
+	}
+}
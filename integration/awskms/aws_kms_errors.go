@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Errors for the failures of AWS KMS requests which callers commonly handle.
+// Errors returned by AWS KMS requests are [*KMSError]s, which match these
+// errors with [errors.Is] when applicable.
+var (
+	// ErrAccessDenied is returned when the caller is not authorized to use the
+	// key.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrKeyDisabled is returned when the key is disabled, pending deletion or
+	// otherwise in a state in which it cannot be used.
+	ErrKeyDisabled = errors.New("key disabled")
+	// ErrInvalidCiphertext is returned when a ciphertext cannot be decrypted,
+	// because it is malformed, it was encrypted with another key or with other
+	// associated data.
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	// ErrThrottled is returned when the request rate exceeds the quota of the
+	// account.
+	ErrThrottled = errors.New("request throttled")
+	// ErrKeyNotFound is returned when the key doesn't exist.
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// errorKinds maps AWS KMS error codes to the errors above.
+var errorKinds = map[string]error{
+	accessDeniedException:                 ErrAccessDenied,
+	kms.ErrCodeDisabledException:          ErrKeyDisabled,
+	kms.ErrCodeInvalidStateException:      ErrKeyDisabled,
+	kms.ErrCodeInvalidCiphertextException: ErrInvalidCiphertext,
+	kms.ErrCodeIncorrectKeyException:      ErrInvalidCiphertext,
+	throttlingException:                   ErrThrottled,
+	kms.ErrCodeNotFoundException:          ErrKeyNotFound,
+}
+
+// KMSError is the error returned when a request to AWS KMS fails.
+//
+// It wraps the error returned by the AWS SDK, which can be inspected with
+// [errors.As], and the matching error above, if any.
+type KMSError struct {
+	// Op is the name of the AWS KMS API, such as "Decrypt".
+	Op string
+	// KeyID is the KeyId of the request, or "" if it has none.
+	KeyID string
+	// Kind is one of the errors above, or nil if the failure doesn't match
+	// any of them.
+	Kind error
+	// Err is the error returned by the AWS SDK.
+	Err error
+}
+
+func (e *KMSError) Error() string {
+	if e.KeyID == "" {
+		return fmt.Sprintf("AWS KMS %s failed: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("AWS KMS %s with key %s failed: %v", e.Op, e.KeyID, e.Err)
+}
+
+// Unwrap returns Kind, if set, and Err.
+func (e *KMSError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// newKMSError returns a KMSError for err, returned by call.
+func newKMSError(call *kmsCall, err error) error {
+	var kmsErr *KMSError
+	if errors.As(err, &kmsErr) {
+		return err
+	}
+	e := &KMSError{Op: call.op, KeyID: call.keyID, Err: err}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		e.Kind = errorKinds[aerr.Code()]
+	}
+	return e
+}
+
+// errorsMiddleware returns errors of AWS KMS requests as [*KMSError]s.
+func errorsMiddleware(ctx context.Context, call *kmsCall, next kmsHandler) error {
+	if err := next(ctx); err != nil {
+		return newKMSError(call, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+func TestKMSErrors(t *testing.T) {
+	disabledKeyARN := "arn:aws:kms:us-east-2:235739564943:key/0d6c3f4a-7b1e-4f0a-9a52-1e3f5c7b9d2e"
+	deniedKeyARN := "arn:aws:kms:us-east-2:235739564943:key/5c1b8e2d-4f6a-4b3c-9d7e-8a2f0c6e1b4d"
+	pendingDeletionKeyARN := "arn:aws:kms:us-east-2:235739564943:key/7a0e3dbd-3b5e-4e3e-8c1f-2b8a6d1c0f5a"
+	unknownKeyARN := "arn:aws:kms:us-east-2:235739564943:key/b3ca2efd-a8fb-47f2-b541-7e20f8c5cd11"
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{
+		symmetricKeyARN:       kms.KeySpecSymmetricDefault,
+		disabledKeyARN:        kms.KeySpecSymmetricDefault,
+		deniedKeyARN:          kms.KeySpecSymmetricDefault,
+		pendingDeletionKeyARN: kms.KeySpecSymmetricDefault,
+	},
+		fakeawskms.WithKeyState(disabledKeyARN, kms.KeyStateDisabled),
+		fakeawskms.WithKeyState(pendingDeletionKeyARN, kms.KeyStatePendingDeletion),
+		fakeawskms.WithAccessDenied(deniedKeyARN))
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		keyARN   string
+		wantKind error
+		wantCode string
+	}{
+		{disabledKeyARN, ErrKeyDisabled, kms.ErrCodeDisabledException},
+		{pendingDeletionKeyARN, ErrKeyDisabled, kms.ErrCodeInvalidStateException},
+		{deniedKeyARN, ErrAccessDenied, accessDeniedException},
+		{unknownKeyARN, ErrKeyNotFound, kms.ErrCodeNotFoundException},
+	} {
+		t.Run(tc.wantCode, func(t *testing.T) {
+			a, err := client.GetAEAD(awsPrefix + tc.keyARN)
+			if err != nil {
+				t.Fatalf("client.GetAEAD() err = %v, want nil", err)
+			}
+			_, err = a.Encrypt([]byte("plaintext"), nil)
+			if !errors.Is(err, tc.wantKind) {
+				t.Errorf("a.Encrypt() err = %v, want %v", err, tc.wantKind)
+			}
+			var aerr awserr.Error
+			if !errors.As(err, &aerr) || aerr.Code() != tc.wantCode {
+				t.Errorf("a.Encrypt() err = %v, want awserr.Error with code %s", err, tc.wantCode)
+			}
+			var kmsErr *KMSError
+			if !errors.As(err, &kmsErr) {
+				t.Fatalf("a.Encrypt() err = %v, want *KMSError", err)
+			}
+			if kmsErr.Op != "Encrypt" || kmsErr.KeyID != tc.keyARN {
+				t.Errorf("kmsErr.Op, kmsErr.KeyID = %q, %q, want %q, %q", kmsErr.Op, kmsErr.KeyID, "Encrypt", tc.keyARN)
+			}
+		})
+	}
+}
+
+func TestKMSErrorsInvalidCiphertext(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), []byte("associatedData"))
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if _, err := a.Decrypt(ciphertext, []byte("invalidAssociatedData")); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("a.Decrypt() err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+	if _, err := a.Decrypt([]byte("invalid"), nil); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("a.Decrypt() err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestKMSErrorsThrottled(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{symmetricKeyARN: kms.KeySpecSymmetricDefault}, fakeawskms.WithThrottledRequests(1))
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := a.(*AWSAEAD).EncryptWithContext(context.Background(), []byte("plaintext"), nil); !errors.Is(err, ErrThrottled) {
+		t.Errorf("a.EncryptWithContext() err = %v, want %v", err, ErrThrottled)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+		t.Errorf("a.Encrypt() err = %v, want nil", err)
+	}
+}
+
+func TestKMSErrorsWithOtherErrors(t *testing.T) {
+	err := newKMSError(&kmsCall{op: "Decrypt"}, context.Canceled)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	for _, kind := range []error{ErrAccessDenied, ErrKeyDisabled, ErrInvalidCiphertext, ErrThrottled, ErrKeyNotFound} {
+		if errors.Is(err, kind) {
+			t.Errorf("errors.Is(err, %v) = true, want false", kind)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// maxGrantTokens is the maximum number of grant tokens of AWS KMS requests.
+const maxGrantTokens = 10
+
+// WithGrantTokens makes the AEADs of the client pass tokens in the GrantTokens
+// field of their requests to AWS KMS, and of the requests of
+// [Client.ReEncrypt].
+//
+// Grant tokens give access to keys through grants which may not be available
+// yet, as grants are eventually consistent. See
+// https://docs.aws.amazon.com/kms/latest/developerguide/grant-manage.html#using-grant-token.
+func WithGrantTokens(tokens ...string) ClientOption {
+	return option(func(a *Client) error {
+		if a.grantTokens != nil {
+			return errors.New("WithGrantTokens option cannot be used, grant tokens already set")
+		}
+		if err := validateGrantTokens(tokens); err != nil {
+			return err
+		}
+		a.grantTokens = append([]string(nil), tokens...)
+		return nil
+	})
+}
+
+// WithAEADGrantTokens makes the AEAD pass tokens in the GrantTokens field of
+// its requests to AWS KMS, in addition to the tokens set by [WithGrantTokens].
+func WithAEADGrantTokens(tokens ...string) AEADOption {
+	return aeadOption(func(a *AWSAEAD) error {
+		if err := validateGrantTokens(tokens); err != nil {
+			return err
+		}
+		grantTokens := append(append([]string(nil), a.grantTokens...), tokens...)
+		if len(grantTokens) > maxGrantTokens {
+			return fmt.Errorf("requests can have at most %d grant tokens, got %d", maxGrantTokens, len(grantTokens))
+		}
+		a.grantTokens = grantTokens
+		return nil
+	})
+}
+
+func validateGrantTokens(tokens []string) error {
+	if len(tokens) == 0 {
+		return errors.New("grant tokens must not be empty")
+	}
+	if len(tokens) > maxGrantTokens {
+		return fmt.Errorf("requests can have at most %d grant tokens, got %d", maxGrantTokens, len(tokens))
+	}
+	for _, token := range tokens {
+		if token == "" {
+			return errors.New("grant tokens must not be empty")
+		}
+	}
+	return nil
+}
+
+// kmsGrantTokens returns tokens for the GrantTokens field of requests, or nil
+// if there are none.
+func kmsGrantTokens(tokens []string) []*string {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return aws.StringSlice(tokens)
+}
+
+// Grant describes a grant created by [Client.CreateGrant].
+type Grant struct {
+	// GranteePrincipal is the principal which receives the permissions of
+	// the grant, such as the ARN of an IAM role. It is required.
+	GranteePrincipal string
+	// RetiringPrincipal is the principal which can retire the grant, or ""
+	// if there is none.
+	RetiringPrincipal string
+	// Operations are the operations the grant allows, such as
+	// kms.GrantOperationEncrypt. If empty, the grant allows Encrypt and
+	// Decrypt.
+	Operations []string
+	// Name is the name of the grant, or "" if it has none.
+	Name string
+	// EncryptionContextSubset restricts the grant to requests whose
+	// encryption context includes these pairs, such as the pairs set by
+	// [WithEncryptionContext]. If empty, the grant is not restricted.
+	EncryptionContextSubset map[string]string
+}
+
+// CreateGrant creates grant for the key of keyURI with the AWS KMS CreateGrant
+// API. It returns an AEAD for keyURI which passes the token of the grant in its
+// requests, as set by [WithAEADGrantTokens], and the ID of the grant, which is
+// needed to retire or revoke it.
+//
+// keyURI must be supported by the client and have the same format as for
+// [Client.GetAEAD]. Aliases are resolved once, so the grant and the AEAD are
+// for the same key.
+//
+// opts are validated before the grant is created. If an error occurs after the
+// grant was created, the ID of the grant is returned with the error, so that
+// the caller can retire it.
+func (c *Client) CreateGrant(ctx context.Context, keyURI string, grant Grant, opts ...AEADOption) (*AWSAEAD, string, error) {
+	if grant.GranteePrincipal == "" {
+		return nil, "", errors.New("GranteePrincipal must be set")
+	}
+	keyID, k, err := c.keyFor(keyURI)
+	if err != nil {
+		return nil, "", err
+	}
+	a, err := c.newAEAD(keyID, k, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(a.grantTokens) >= maxGrantTokens {
+		return nil, "", fmt.Errorf("requests can have at most %d grant tokens, the AEAD already has %d", maxGrantTokens, len(a.grantTokens))
+	}
+	operations := grant.Operations
+	if len(operations) == 0 {
+		operations = []string{kms.GrantOperationEncrypt, kms.GrantOperationDecrypt}
+	}
+	req := &kms.CreateGrantInput{
+		KeyId:            aws.String(keyID),
+		GranteePrincipal: aws.String(grant.GranteePrincipal),
+		Operations:       aws.StringSlice(operations),
+		GrantTokens:      kmsGrantTokens(c.grantTokens),
+	}
+	if grant.RetiringPrincipal != "" {
+		req.RetiringPrincipal = aws.String(grant.RetiringPrincipal)
+	}
+	if grant.Name != "" {
+		req.Name = aws.String(grant.Name)
+	}
+	if len(grant.EncryptionContextSubset) > 0 {
+		req.Constraints = &kms.GrantConstraints{EncryptionContextSubset: aws.StringMap(grant.EncryptionContextSubset)}
+	}
+	resp, err := k.CreateGrantWithContext(ctx, req)
+	if err != nil {
+		return nil, "", err
+	}
+	grantID := aws.StringValue(resp.GrantId)
+	if err := WithAEADGrantTokens(aws.StringValue(resp.GrantToken)).setAEAD(a); err != nil {
+		return nil, grantID, fmt.Errorf("failed setting the token of grant %s: %v", grantID, err)
+	}
+	return a, grantID, nil
+}
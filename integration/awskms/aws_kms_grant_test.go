@@ -0,0 +1,216 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// recordGrantTokens returns an interceptor which records the grant tokens of
+// Encrypt and Decrypt requests.
+func recordGrantTokens(tokens *[][]string) Interceptor {
+	return func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		switch req := call.Request.(type) {
+		case *kms.EncryptInput:
+			*tokens = append(*tokens, aws.StringValueSlice(req.GrantTokens))
+		case *kms.DecryptInput:
+			*tokens = append(*tokens, aws.StringValueSlice(req.GrantTokens))
+		}
+		return invoke(ctx, call)
+	}
+}
+
+func TestCreateGrant(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var tokens [][]string
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithInterceptors(recordGrantTokens(&tokens)))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, grantID, err := client.(*Client).CreateGrant(context.Background(), "aws-kms://"+symmetricKeyARN, Grant{GranteePrincipal: granteeRoleARN})
+	if err != nil {
+		t.Fatalf("client.CreateGrant() err = %v, want nil", err)
+	}
+	if grantID == "" {
+		t.Error("client.CreateGrant() grant ID = \"\", want not empty")
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), []byte("associated data"))
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if _, err := a.Decrypt(ciphertext, []byte("associated data")); err != nil {
+		t.Fatalf("a.Decrypt() err = %v, want nil", err)
+	}
+	if len(tokens) != 2 || len(tokens[0]) != 1 || len(tokens[1]) != 1 || tokens[0][0] == "" || tokens[0][0] != tokens[1][0] {
+		t.Errorf("grant tokens = %q, want the grant token in both requests", tokens)
+	}
+}
+
+func TestCreateGrantFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	c := client.(*Client)
+	unknownKeyURI := "aws-kms://arn:aws:kms:us-east-2:235739564943:key/9b6c2f1e-0d4a-4c8e-b7f3-5a1d2e3c4b5f"
+	if _, _, err := c.CreateGrant(context.Background(), unknownKeyURI, Grant{GranteePrincipal: granteeRoleARN}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("c.CreateGrant() with unknown key err = %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, _, err := c.CreateGrant(context.Background(), "aws-kms://"+symmetricKeyARN, Grant{}); err == nil {
+		t.Error("c.CreateGrant() without grantee err = nil, want error")
+	}
+	if _, _, err := c.CreateGrant(context.Background(), "gcp-kms://key", Grant{GranteePrincipal: granteeRoleARN}); err == nil {
+		t.Error("c.CreateGrant() with unsupported key URI err = nil, want error")
+	}
+}
+
+func TestWithGrantTokens(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var tokens [][]string
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithGrantTokens("client-token"), WithInterceptors(recordGrantTokens(&tokens)))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.(*Client).GetAEADWithOptions("aws-kms://"+symmetricKeyARN, WithAEADGrantTokens("aead-token"))
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions() err = %v, want nil", err)
+	}
+	// The fake rejects unknown grant tokens.
+	_, err = a.Encrypt([]byte("plaintext"), nil)
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != kms.ErrCodeInvalidGrantTokenException {
+		t.Errorf("a.Encrypt() err = %v, want %s", err, kms.ErrCodeInvalidGrantTokenException)
+	}
+	if len(tokens) != 1 || len(tokens[0]) != 2 || tokens[0][0] != "client-token" || tokens[0][1] != "aead-token" {
+		t.Errorf("grant tokens = %q, want [[client-token aead-token]]", tokens)
+	}
+}
+
+func TestWithGrantTokensFailsWithInvalidTokens(t *testing.T) {
+	tooMany := make([]string, 11)
+	for i := range tooMany {
+		tooMany[i] = "token"
+	}
+	for _, tc := range []struct {
+		name   string
+		tokens []string
+	}{
+		{"no tokens", nil},
+		{"empty token", []string{"token", ""}},
+		{"too many tokens", tooMany},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions("aws-kms://", WithGrantTokens(tc.tokens...)); err == nil {
+				t.Error("NewClientWithOptions() err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestWithAEADGrantTokensFailsWithTooManyTokens(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithGrantTokens("1", "2", "3", "4", "5", "6"))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	if _, err := client.(*Client).GetAEADWithOptions("aws-kms://"+symmetricKeyARN, WithAEADGrantTokens("7", "8", "9", "10", "11")); err == nil {
+		t.Error("client.GetAEADWithOptions() err = nil, want error")
+	}
+}
+
+func TestWithGrantTokensTwiceFails(t *testing.T) {
+	if _, err := NewClientWithOptions("aws-kms://", WithGrantTokens("token"), WithGrantTokens("token")); err == nil {
+		t.Error("NewClientWithOptions() err = nil, want error")
+	}
+}
+
+func TestCreateGrantValidatesOptionsBeforeCreatingTheGrant(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var ops []string
+	record := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		ops = append(ops, call.Operation)
+		return invoke(ctx, call)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithGrantTokens("1", "2", "3", "4", "5", "6", "7", "8", "9", "10"), WithInterceptors(record))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	c := client.(*Client)
+	keyURI := "aws-kms://" + symmetricKeyARN
+	if _, _, err := c.CreateGrant(context.Background(), keyURI, Grant{GranteePrincipal: granteeRoleARN}); err == nil {
+		t.Error("c.CreateGrant() with the maximum number of grant tokens err = nil, want error")
+	}
+	if _, _, err := c.CreateGrant(context.Background(), keyURI, Grant{GranteePrincipal: granteeRoleARN}, WithAEADGrantTokens("")); err == nil {
+		t.Error("c.CreateGrant() with an invalid option err = nil, want error")
+	}
+	if len(ops) != 0 {
+		t.Errorf("requests = %q, want none", ops)
+	}
+}
+
+func TestCreateGrantResolvesAliasOnce(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{symmetricKeyARN: kms.KeySpecSymmetricDefault}, fakeawskms.WithAlias("alias/grant-test", symmetricKeyARN))
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	var ops []string
+	var grantKeyID string
+	record := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		ops = append(ops, call.Operation)
+		if req, ok := call.Request.(*kms.CreateGrantInput); ok {
+			grantKeyID = aws.StringValue(req.KeyId)
+		}
+		return invoke(ctx, call)
+	}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", WithKMS(fakekms), WithAliasResolution(), WithInterceptors(record))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, _, err := client.(*Client).CreateGrant(context.Background(), "aws-kms://arn:aws:kms:us-east-2:235739564943:alias/grant-test", Grant{GranteePrincipal: granteeRoleARN})
+	if err != nil {
+		t.Fatalf("CreateGrant() err = %v, want nil", err)
+	}
+	if want := []string{"DescribeKey", "CreateGrant"}; len(ops) != len(want) || ops[0] != want[0] || ops[1] != want[1] {
+		t.Errorf("requests = %q, want %q", ops, want)
+	}
+	if grantKeyID != symmetricKeyARN || a.KeyID() != symmetricKeyARN {
+		t.Errorf("grant key = %q, AEAD key = %q, want both %q", grantKeyID, a.KeyID(), symmetricKeyARN)
+	}
+}
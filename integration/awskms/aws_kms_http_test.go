@@ -0,0 +1,165 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+func TestHTTPConfigCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	client, err := newHTTPClient(HTTPConfig{CABundle: caBundle})
+	if err != nil {
+		t.Fatalf("newHTTPClient() err = %v, want nil", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("client.Get() with the CA bundle err = %v, want nil", err)
+	}
+	resp.Body.Close()
+
+	client, err = newHTTPClient(HTTPConfig{})
+	if err != nil {
+		t.Fatalf("newHTTPClient() err = %v, want nil", err)
+	}
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("client.Get() without the CA bundle err = nil, want error")
+	}
+}
+
+func TestHTTPConfigProxyURL(t *testing.T) {
+	var gotHost, gotAuthorization string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		gotAuthorization = r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(HTTPConfig{ProxyURL: strings.Replace(proxy.URL, "http://", "http://user:password@", 1)})
+	if err != nil {
+		t.Fatalf("newHTTPClient() err = %v, want nil", err)
+	}
+	resp, err := client.Get("http://kms.us-east-2.amazonaws.com/")
+	if err != nil {
+		t.Fatalf("client.Get() err = %v, want nil", err)
+	}
+	resp.Body.Close()
+	if gotHost != "kms.us-east-2.amazonaws.com" {
+		t.Errorf("host of the proxied request = %q, want %q", gotHost, "kms.us-east-2.amazonaws.com")
+	}
+	if !strings.HasPrefix(gotAuthorization, "Basic ") {
+		t.Errorf("Proxy-Authorization = %q, want basic credentials", gotAuthorization)
+	}
+}
+
+func TestHTTPConfigTimeoutsAndLimits(t *testing.T) {
+	client, err := newHTTPClient(HTTPConfig{
+		Timeout:               time.Minute,
+		DialTimeout:           time.Second,
+		TLSHandshakeTimeout:   2 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+		IdleConnTimeout:       4 * time.Second,
+		MaxIdleConns:          5,
+		MaxIdleConnsPerHost:   6,
+		MaxConnsPerHost:       7,
+	})
+	if err != nil {
+		t.Fatalf("newHTTPClient() err = %v, want nil", err)
+	}
+	if client.Timeout != time.Minute {
+		t.Errorf("client.Timeout = %v, want %v", client.Timeout, time.Minute)
+	}
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSHandshakeTimeout != 2*time.Second || transport.ResponseHeaderTimeout != 3*time.Second || transport.IdleConnTimeout != 4*time.Second {
+		t.Errorf("transport timeouts = %v, %v, %v, want 2s, 3s, 4s", transport.TLSHandshakeTimeout, transport.ResponseHeaderTimeout, transport.IdleConnTimeout)
+	}
+	if transport.MaxIdleConns != 5 || transport.MaxIdleConnsPerHost != 6 || transport.MaxConnsPerHost != 7 {
+		t.Errorf("transport limits = %d, %d, %d, want 5, 6, 7", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+	if transport.Proxy == nil {
+		t.Error("transport.Proxy = nil, want the proxy of the environment")
+	}
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	httpClient := &http.Client{}
+	for _, tc := range []struct {
+		name string
+		opt  ClientOption
+	}{
+		{"HTTP client", WithHTTPClient(httpClient)},
+		{"HTTP config", WithHTTPConfig(HTTPConfig{MaxIdleConns: 10})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", tc.opt)
+			if err != nil {
+				t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+			}
+			c := client.(*Client)
+			east, err := c.newSession("us-east-2")
+			if err != nil {
+				t.Fatalf("c.newSession() err = %v, want nil", err)
+			}
+			west, err := c.newSession("eu-west-1")
+			if err != nil {
+				t.Fatalf("c.newSession() err = %v, want nil", err)
+			}
+			if east.Config.HTTPClient == nil || east.Config.HTTPClient != c.httpClient || west.Config.HTTPClient != c.httpClient {
+				t.Error("the sessions don't share the HTTP client of the client")
+			}
+		})
+	}
+}
+
+func TestHTTPOptionsFail(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		opts []ClientOption
+	}{
+		{"nil HTTP client", []ClientOption{WithHTTPClient(nil)}},
+		{"HTTP client and config", []ClientOption{WithHTTPClient(&http.Client{}), WithHTTPConfig(HTTPConfig{})}},
+		{"repeated HTTP config", []ClientOption{WithHTTPConfig(HTTPConfig{}), WithHTTPConfig(HTTPConfig{})}},
+		{"invalid CA bundle", []ClientOption{WithHTTPConfig(HTTPConfig{CABundle: []byte("not a certificate")})}},
+		{"proxy URL without host", []ClientOption{WithHTTPConfig(HTTPConfig{ProxyURL: "http://"})}},
+		{"proxy URL with unsupported scheme", []ClientOption{WithHTTPConfig(HTTPConfig{ProxyURL: "ftp://proxy.example.com"})}},
+		{"negative timeout", []ClientOption{WithHTTPConfig(HTTPConfig{Timeout: -time.Second})}},
+		{"negative connection limit", []ClientOption{WithHTTPConfig(HTTPConfig{MaxIdleConns: -1})}},
+		{"KMS client and HTTP client", []ClientOption{WithKMS(fakekms), WithHTTPClient(&http.Client{})}},
+		{"HTTP config and KMS client", []ClientOption{WithHTTPConfig(HTTPConfig{}), WithKMS(fakekms)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", tc.opts...); err == nil {
+				t.Error("NewClientWithOptions() err = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+)
+
+// KMSCall is a request to AWS KMS seen by an [Interceptor].
+type KMSCall struct {
+	// Operation is the name of the AWS KMS API, such as "Encrypt".
+	Operation string
+	// KeyURI is the key URI of the key the request uses, such as
+	// "aws-kms://arn:aws:kms:us-east-2:111122223333:key/...", or "" if the
+	// request has no key.
+	KeyURI string
+	// Region is the region of the KMS client sending the request, or "" if it
+	// is unknown.
+	Region string
+	// Request is the input of the request, such as a *kms.EncryptInput.
+	// Interceptors may modify it, but must not replace it.
+	Request any
+	// Response is the output of the request, such as a *kms.EncryptOutput. It
+	// is nil until the request succeeded. Interceptors may set or replace it,
+	// with a value of the same type.
+	Response any
+}
+
+// KMSInvoker sends the request of call to AWS KMS, through the remaining
+// interceptors, and sets the response of call when the request succeeds.
+type KMSInvoker func(ctx context.Context, call *KMSCall) error
+
+// Interceptor intercepts requests to AWS KMS. It calls invoke to continue
+// with the request, and can inspect or modify the request before and the
+// response and error after.
+//
+// An interceptor can short-circuit a request by returning without calling
+// invoke. It must then either return an error or set the response of call.
+type Interceptor func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error
+
+// WithInterceptors makes the client pass the requests it makes to AWS KMS
+// through interceptors, in order: the first interceptor is the outermost one.
+// Interceptors of later WithInterceptors options run after the ones of earlier
+// options.
+//
+// Each request is seen once by the interceptors, before the retries made with
+// [WithRetryPolicy]. Errors returned by interceptors are returned as
+// [*KMSError]s, like the errors of AWS KMS.
+func WithInterceptors(interceptors ...Interceptor) ClientOption {
+	return option(func(a *Client) error {
+		for _, i := range interceptors {
+			if i == nil {
+				return errors.New("interceptors must not be nil")
+			}
+		}
+		a.interceptors = append(a.interceptors, interceptors...)
+		return nil
+	})
+}
+
+// interceptorsMiddleware returns a middleware passing requests through
+// interceptors, see [WithInterceptors].
+func interceptorsMiddleware(interceptors []Interceptor) kmsMiddleware {
+	return func(ctx context.Context, call *kmsCall, next kmsHandler) error {
+		c := &KMSCall{
+			Operation: call.op,
+			Region:    call.region,
+			Request:   call.input,
+		}
+		if call.keyID != "" {
+			c.KeyURI = awsPrefix + call.keyID
+		}
+		invoke := func(ctx context.Context, c *KMSCall) error {
+			if err := next(ctx); err != nil {
+				return err
+			}
+			c.Response = call.output
+			return nil
+		}
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], KMSInvoker(invoke)
+			invoke = func(ctx context.Context, c *KMSCall) error { return interceptor(ctx, c, next) }
+		}
+		if err := invoke(ctx, c); err != nil {
+			return err
+		}
+		call.output = c.Response
+		return nil
+	}
+}
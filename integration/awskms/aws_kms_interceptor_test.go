@@ -0,0 +1,200 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+func TestInterceptorsRunInOrder(t *testing.T) {
+	var events []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+			events = append(events, name+" "+call.Operation)
+			err := invoke(ctx, call)
+			events = append(events, name+" done")
+			return err
+		}
+	}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithInterceptors(record("a"), record("b")), WithInterceptors(record("c"))).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	want := []string{"a Encrypt", "b Encrypt", "c Encrypt", "c done", "b done", "a done"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %q, want %q", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %q, want %q", events, want)
+		}
+	}
+}
+
+func TestInterceptorSeesRequestAndResponse(t *testing.T) {
+	var got KMSCall
+	interceptor := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		err := invoke(ctx, call)
+		got = *call
+		return err
+	}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithInterceptors(interceptor)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	ciphertext, err := a.EncryptWithContext(context.Background(), plaintext, nil)
+	if err != nil {
+		t.Fatalf("a.EncryptWithContext() err = %v, want nil", err)
+	}
+	if got.Operation != "Encrypt" {
+		t.Errorf("call.Operation = %q, want %q", got.Operation, "Encrypt")
+	}
+	if want := "aws-kms://" + symmetricKeyARN; got.KeyURI != want {
+		t.Errorf("call.KeyURI = %q, want %q", got.KeyURI, want)
+	}
+	if got.Region != "us-east-2" {
+		t.Errorf("call.Region = %q, want %q", got.Region, "us-east-2")
+	}
+	req, ok := got.Request.(*kms.EncryptInput)
+	if !ok || !bytes.Equal(req.Plaintext, plaintext) {
+		t.Errorf("call.Request = %v, want *kms.EncryptInput of the plaintext", got.Request)
+	}
+	resp, ok := got.Response.(*kms.EncryptOutput)
+	if !ok || !bytes.Equal(resp.CiphertextBlob, ciphertext) {
+		t.Errorf("call.Response = %v, want *kms.EncryptOutput of the ciphertext", got.Response)
+	}
+}
+
+func TestInterceptorCanShortCircuit(t *testing.T) {
+	var reached bool
+	shortCircuit := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		call.Response = &kms.EncryptOutput{CiphertextBlob: []byte("canned ciphertext")}
+		return nil
+	}
+	inner := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		reached = true
+		return invoke(ctx, call)
+	}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithInterceptors(shortCircuit, inner)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if want := []byte("canned ciphertext"); !bytes.Equal(ciphertext, want) {
+		t.Errorf("a.Encrypt() = %q, want %q", ciphertext, want)
+	}
+	if reached {
+		t.Error("inner interceptor was called, want not called")
+	}
+}
+
+func TestInterceptorCanInjectFaults(t *testing.T) {
+	errInjected := errors.New("injected failure")
+	fail := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		if call.Operation == "Decrypt" {
+			return errInjected
+		}
+		return invoke(ctx, call)
+	}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithInterceptors(fail)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	_, err = a.Decrypt(ciphertext, nil)
+	var kmsErr *KMSError
+	if !errors.As(err, &kmsErr) || !errors.Is(err, errInjected) {
+		t.Errorf("a.Decrypt() err = %v, want *KMSError wrapping %v", err, errInjected)
+	}
+}
+
+func TestInterceptorCanTagRequests(t *testing.T) {
+	tag := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		if req, ok := call.Request.(*kms.EncryptInput); ok {
+			req.KeyId = aws.String("alias/unknown")
+		}
+		return invoke(ctx, call)
+	}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithInterceptors(tag)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("a.Encrypt() err = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestInterceptorResponseOfWrongTypeFails(t *testing.T) {
+	wrongType := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		call.Response = &kms.DecryptOutput{}
+		return nil
+	}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithInterceptors(wrongType)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err == nil {
+		t.Error("a.Encrypt() err = nil, want error")
+	}
+}
+
+func TestWithNilInterceptorFails(t *testing.T) {
+	if _, err := NewClientWithOptions("aws-kms://", WithInterceptors(nil)); err == nil {
+		t.Error("NewClientWithOptions() err = nil, want error")
+	}
+}
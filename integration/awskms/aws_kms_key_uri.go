@@ -0,0 +1,397 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// accessDeniedException is the error code returned by AWS KMS when the caller
+// is not authorized to perform an operation.
+const accessDeniedException = "AccessDeniedException"
+
+// Errors returned by [ParseKeyURI], wrapped in a [*KeyURIError].
+var (
+	ErrInvalidScheme        = errors.New("key URI must start with " + awsPrefix)
+	ErrMalformedARN         = errors.New("malformed AWS KMS ARN")
+	ErrInvalidKeyID         = errors.New("invalid key ID")
+	ErrInvalidAliasName     = errors.New("invalid alias name")
+	ErrInvalidRegion        = errors.New("invalid region")
+	ErrUnsupportedParameter = errors.New("unsupported parameter")
+)
+
+// KeyURIError is the error returned for malformed key URIs. Err is one of the
+// errors above, possibly wrapped with details.
+type KeyURIError struct {
+	URI string
+	Err error
+}
+
+func (e *KeyURIError) Error() string {
+	return fmt.Sprintf("invalid key URI %q: %v", e.URI, e.Err)
+}
+
+func (e *KeyURIError) Unwrap() error { return e.Err }
+
+var (
+	keyARNPattern = regexp.MustCompile(`^arn:(aws[a-zA-Z0-9-_]*):kms:([a-z0-9-]+):([0-9]{12}):(key|alias)/(.+)$`)
+	// keyURIPrefixPattern matches the beginning of URI prefixes which contain
+	// a region.
+	keyURIPrefixPattern = regexp.MustCompile(`^arn:(aws[a-zA-Z0-9-_]*):kms:([a-z0-9-]+):`)
+	// keyIDPattern matches key IDs of single-region and multi-region keys.
+	keyIDPattern     = regexp.MustCompile(`^(mrk-[0-9a-f]{32}|[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
+	aliasNamePattern = regexp.MustCompile(`^[a-zA-Z0-9/_-]+$`)
+	regionPattern    = regexp.MustCompile(`^[a-z0-9-]+$`)
+)
+
+// ResourceType is the type of the resource a key URI refers to.
+type ResourceType string
+
+const (
+	// ResourceTypeKey is the resource type of key URIs which refer to a key by
+	// its ARN or key ID.
+	ResourceTypeKey ResourceType = "key"
+	// ResourceTypeAlias is the resource type of key URIs which refer to a key
+	// through an alias.
+	ResourceTypeAlias ResourceType = "alias"
+)
+
+// KeyURI is a parsed AWS KMS key URI. See [ParseKeyURI] for the supported
+// formats.
+type KeyURI struct {
+	// Partition is the partition of the key, such as "aws", or "" if the URI
+	// is not an ARN.
+	Partition string
+	// Region is the region of the key, or "" if the URI is not an ARN and has
+	// no region parameter.
+	Region string
+	// Account is the AWS account of the key, or "" if the URI is not an ARN.
+	Account string
+	// ResourceType is the type of the resource, a key or an alias.
+	ResourceType ResourceType
+	// ID is the key ID, or the alias name without the "alias/" prefix.
+	ID string
+}
+
+// ParseKeyURI parses uri, which must have one of the following formats:
+//
+//	aws-kms://arn:<partition>:kms:<region>:<account>:key/<key-id>
+//	aws-kms://arn:<partition>:kms:<region>:<account>:alias/<alias>
+//	aws-kms://<key-id>[?region=<region>]
+//	aws-kms://alias/<alias>[?region=<region>]
+//
+// ARNs may also have a region parameter, which must then match the region of
+// the ARN. Like in other URIs, the scheme is case-insensitive.
+//
+// If uri is malformed, the returned error is a [*KeyURIError].
+func ParseKeyURI(uri string) (*KeyURI, error) {
+	u, err := parseKeyURI(uri)
+	if err != nil {
+		return nil, &KeyURIError{URI: uri, Err: err}
+	}
+	return u, nil
+}
+
+func parseKeyURI(uri string) (*KeyURI, error) {
+	rest, ok := trimScheme(uri)
+	if !ok {
+		return nil, ErrInvalidScheme
+	}
+	id, query, hasQuery := strings.Cut(rest, "?")
+	u := &KeyURI{}
+	if hasQuery {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedParameter, err)
+		}
+		for name, v := range values {
+			if name != "region" {
+				return nil, fmt.Errorf("%w %q", ErrUnsupportedParameter, name)
+			}
+			if len(v) != 1 || !regionPattern.MatchString(v[0]) {
+				return nil, fmt.Errorf("%w: the region parameter must have a single valid value", ErrInvalidRegion)
+			}
+			u.Region = v[0]
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(id, "arn:"):
+		m := keyARNPattern.FindStringSubmatch(id)
+		if m == nil {
+			return nil, ErrMalformedARN
+		}
+		if u.Region != "" && u.Region != m[2] {
+			return nil, fmt.Errorf("%w: the region parameter %s doesn't match the region %s of the ARN", ErrInvalidRegion, u.Region, m[2])
+		}
+		u.Partition, u.Region, u.Account, u.ResourceType, u.ID = m[1], m[2], m[3], ResourceType(m[4]), m[5]
+		if u.ResourceType == ResourceTypeKey && !keyIDPattern.MatchString(u.ID) {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedARN, ErrInvalidKeyID)
+		}
+		if u.ResourceType == ResourceTypeAlias && !aliasNamePattern.MatchString(u.ID) {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedARN, ErrInvalidAliasName)
+		}
+	case strings.HasPrefix(id, "alias/"):
+		u.ResourceType, u.ID = ResourceTypeAlias, strings.TrimPrefix(id, "alias/")
+		if !aliasNamePattern.MatchString(u.ID) {
+			return nil, ErrInvalidAliasName
+		}
+	default:
+		u.ResourceType, u.ID = ResourceTypeKey, id
+		if !keyIDPattern.MatchString(u.ID) {
+			return nil, ErrInvalidKeyID
+		}
+	}
+	return u, nil
+}
+
+// IsARN returns whether u refers to the key by an ARN.
+func (u *KeyURI) IsARN() bool {
+	return u.Partition != ""
+}
+
+// KeyID returns the identifier of the key in AWS KMS requests: the key ARN,
+// the alias ARN, the key ID or the alias name.
+func (u *KeyURI) KeyID() string {
+	resource := u.ID
+	if u.ResourceType == ResourceTypeAlias {
+		resource = "alias/" + u.ID
+	}
+	if !u.IsARN() {
+		return resource
+	}
+	if u.ResourceType == ResourceTypeKey {
+		resource = "key/" + u.ID
+	}
+	return fmt.Sprintf("arn:%s:kms:%s:%s:%s", u.Partition, u.Region, u.Account, resource)
+}
+
+// String returns the key URI of u.
+func (u *KeyURI) String() string {
+	if u.IsARN() || u.Region == "" {
+		return awsPrefix + u.KeyID()
+	}
+	return awsPrefix + u.KeyID() + "?region=" + u.Region
+}
+
+// trimScheme returns uri without the aws-kms:// scheme, and whether uri starts
+// with it.
+func trimScheme(uri string) (string, bool) {
+	if len(uri) < len(awsPrefix) || !strings.EqualFold(uri[:len(awsPrefix)], awsPrefix) {
+		return "", false
+	}
+	return uri[len(awsPrefix):], true
+}
+
+// hasKeyURIPrefix returns whether keyURI starts with uriPrefix. The schemes are
+// compared case-insensitively.
+func hasKeyURIPrefix(keyURI, uriPrefix string) bool {
+	uri, ok := trimScheme(keyURI)
+	if !ok {
+		return false
+	}
+	prefix, ok := trimScheme(uriPrefix)
+	return ok && strings.HasPrefix(uri, prefix)
+}
+
+// parseKeyURIPrefix validates the URI prefix of a client and returns its
+// partition and region, or "" if the prefix doesn't contain them.
+func parseKeyURIPrefix(uriPrefix string) (partition, region string, err error) {
+	prefix, ok := trimScheme(uriPrefix)
+	if !ok {
+		return "", "", &KeyURIError{URI: uriPrefix, Err: ErrInvalidScheme}
+	}
+	m := keyURIPrefixPattern.FindStringSubmatch(prefix)
+	if m == nil {
+		return "", "", nil
+	}
+	return m[1], m[2], nil
+}
+
+// WithAliasResolution makes the client resolve keys referred to through an
+// alias to the key the alias refers to when a primitive is created. The
+// primitive then always uses this key, even if the alias is updated to refer to
+// another key. Use [Client.ResolveKeyURI] to get the key URI of this key.
+//
+// Aliases are resolved with the AWS KMS DescribeKey API. If the caller is not
+// authorized to call it, the ListAliases API is used instead.
+func WithAliasResolution() ClientOption {
+	return option(func(a *Client) error {
+		if a.resolveAliases {
+			return errors.New("WithAliasResolution option cannot be used, alias resolution already enabled")
+		}
+		a.resolveAliases = true
+		return nil
+	})
+}
+
+// ResolveKeyURI returns the key URI of the key keyURI refers to.
+//
+// If keyURI refers to the key through an alias, the alias is resolved as
+// described in [WithAliasResolution] and the URI of the key ARN is returned.
+// Otherwise, keyURI is returned as is.
+//
+// keyURI must be supported by this client and must have the same format as
+// for [Client.GetAEAD].
+func (c *Client) ResolveKeyURI(keyURI string) (string, error) {
+	u, err := c.parseSupportedKeyURI(keyURI)
+	if err != nil {
+		return "", err
+	}
+	if u.ResourceType != ResourceTypeAlias {
+		return keyURI, nil
+	}
+	k, err := c.kmsFor(u)
+	if err != nil {
+		return "", err
+	}
+	keyID, err := resolveAlias(context.Background(), k, u.KeyID())
+	if err != nil {
+		return "", err
+	}
+	return awsPrefix + keyID, nil
+}
+
+// parseSupportedKeyURI parses keyURI and checks that it is supported by the
+// client.
+func (c *Client) parseSupportedKeyURI(keyURI string) (*KeyURI, error) {
+	u, err := ParseKeyURI(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	if !hasKeyURIPrefix(keyURI, c.keyURIPrefix) && !c.isMultiRegionReplica(u) {
+		return nil, fmt.Errorf("keyURI must start with prefix %s, but got %s", c.keyURIPrefix, keyURI)
+	}
+	return u, nil
+}
+
+// keyFor returns the key ID to use in requests for keyURI and the KMS client
+// of its region.
+func (c *Client) keyFor(keyURI string) (string, kmsiface.KMSAPI, error) {
+	u, err := c.parseSupportedKeyURI(keyURI)
+	if err != nil {
+		return "", nil, err
+	}
+	return c.resolveKey(u)
+}
+
+// resolveKey returns the key ID and the KMS client for u, a key URI supported
+// by the client, like keyFor.
+func (c *Client) resolveKey(u *KeyURI) (string, kmsiface.KMSAPI, error) {
+	k, err := c.kmsFor(u)
+	if err != nil {
+		return "", nil, err
+	}
+	if !c.resolveAliases || u.ResourceType != ResourceTypeAlias {
+		return u.KeyID(), k, nil
+	}
+	keyID, err := resolveAlias(context.Background(), k, u.KeyID())
+	if err != nil {
+		return "", nil, err
+	}
+	return keyID, k, nil
+}
+
+// regionOf returns the region of the key of u, or "" if it is unknown.
+func (c *Client) regionOf(u *KeyURI) string {
+	if u.Region == "" {
+		return c.localRegion
+	}
+	return u.Region
+}
+
+// kmsFor returns the KMS client for the region of u.
+//
+// The region of the URI prefix goes through kmsForRegion like the others, so
+// that a client set for it with [WithRegionalKMS] is used on every call path.
+func (c *Client) kmsFor(u *KeyURI) (kmsiface.KMSAPI, error) {
+	if err := c.checkEndpointOptions(u.Partition); err != nil {
+		return nil, err
+	}
+	region := c.regionOf(u)
+	if region == "" {
+		if c.kms == nil {
+			return nil, fmt.Errorf("key %s has no region and the URI prefix %s has none either", u.KeyID(), c.keyURIPrefix)
+		}
+		return c.wrapKMS(c.kms, ""), nil
+	}
+	k, err := c.kmsForRegion(region)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrapKMS(k, region), nil
+}
+
+// resolveAlias returns the ARN of the key the alias aliasID refers to. aliasID
+// is an alias name or an alias ARN.
+func resolveAlias(ctx context.Context, k kmsiface.KMSAPI, aliasID string) (string, error) {
+	resp, err := k.DescribeKeyWithContext(ctx, &kms.DescribeKeyInput{
+		KeyId: aws.String(aliasID),
+	})
+	if err == nil {
+		if resp.KeyMetadata == nil || resp.KeyMetadata.Arn == nil {
+			return "", fmt.Errorf("no key metadata returned for %s", aliasID)
+		}
+		return aws.StringValue(resp.KeyMetadata.Arn), nil
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != accessDeniedException {
+		return "", fmt.Errorf("cannot resolve alias %s: %v", aliasID, err)
+	}
+	return resolveAliasFromList(ctx, k, aliasID)
+}
+
+// resolveAliasFromList resolves aliasID by listing the aliases of the account.
+func resolveAliasFromList(ctx context.Context, k kmsiface.KMSAPI, aliasID string) (string, error) {
+	input := &kms.ListAliasesInput{}
+	for {
+		resp, err := k.ListAliasesWithContext(ctx, input)
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve alias %s: %v", aliasID, err)
+		}
+		for _, a := range resp.Aliases {
+			if aws.StringValue(a.AliasName) != aliasID && aws.StringValue(a.AliasArn) != aliasID {
+				continue
+			}
+			targetKeyID := aws.StringValue(a.TargetKeyId)
+			if targetKeyID == "" {
+				return "", fmt.Errorf("alias %s doesn't refer to a key", aliasID)
+			}
+			// Build the key ARN from the alias ARN, which only differs in the
+			// resource.
+			aliasARN := aws.StringValue(a.AliasArn)
+			if i := strings.Index(aliasARN, ":alias/"); i >= 0 {
+				return aliasARN[:i] + ":key/" + targetKeyID, nil
+			}
+			return targetKeyID, nil
+		}
+		if !aws.BoolValue(resp.Truncated) || resp.NextMarker == nil {
+			return "", fmt.Errorf("alias %s not found", aliasID)
+		}
+		input.Marker = resp.NextMarker
+	}
+}
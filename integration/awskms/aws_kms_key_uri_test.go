@@ -0,0 +1,366 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+const (
+	aliasARN = "arn:aws:kms:us-east-2:235739564943:alias/unit-and-integration-testing"
+	aliasURI = "aws-kms://arn:aws:kms:us-east-2:235739564943:alias/unit-and-integration-testing"
+)
+
+func TestParseKeyURI(t *testing.T) {
+	for _, tc := range []struct {
+		uri       string
+		want      KeyURI
+		wantKeyID string
+	}{
+		{
+			uri:       symmetricKeyURI,
+			want:      KeyURI{Partition: "aws", Region: "us-east-2", Account: "235739564943", ResourceType: ResourceTypeKey, ID: "3ee50705-5a82-4f5b-9753-05c4f473922f"},
+			wantKeyID: symmetricKeyARN,
+		},
+		{
+			uri:       aliasURI,
+			want:      KeyURI{Partition: "aws", Region: "us-east-2", Account: "235739564943", ResourceType: ResourceTypeAlias, ID: "unit-and-integration-testing"},
+			wantKeyID: aliasARN,
+		},
+		{
+			uri:       symmetricKeyURI + "?region=us-east-2",
+			want:      KeyURI{Partition: "aws", Region: "us-east-2", Account: "235739564943", ResourceType: ResourceTypeKey, ID: "3ee50705-5a82-4f5b-9753-05c4f473922f"},
+			wantKeyID: symmetricKeyARN,
+		},
+		{
+			uri:       "AWS-KMS://" + symmetricKeyARN,
+			want:      KeyURI{Partition: "aws", Region: "us-east-2", Account: "235739564943", ResourceType: ResourceTypeKey, ID: "3ee50705-5a82-4f5b-9753-05c4f473922f"},
+			wantKeyID: symmetricKeyARN,
+		},
+		{
+			uri:       "aws-kms://arn:aws-us-gov:kms:us-gov-east-1:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f",
+			want:      KeyURI{Partition: "aws-us-gov", Region: "us-gov-east-1", Account: "235739564943", ResourceType: ResourceTypeKey, ID: "3ee50705-5a82-4f5b-9753-05c4f473922f"},
+			wantKeyID: "arn:aws-us-gov:kms:us-gov-east-1:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f",
+		},
+		{
+			uri:       eastReplicaURI,
+			want:      KeyURI{Partition: "aws", Region: "us-east-1", Account: "235739564943", ResourceType: ResourceTypeKey, ID: "mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"},
+			wantKeyID: eastReplicaARN,
+		},
+		{
+			uri:       "aws-kms://3ee50705-5a82-4f5b-9753-05c4f473922f",
+			want:      KeyURI{ResourceType: ResourceTypeKey, ID: "3ee50705-5a82-4f5b-9753-05c4f473922f"},
+			wantKeyID: "3ee50705-5a82-4f5b-9753-05c4f473922f",
+		},
+		{
+			uri:       "aws-kms://mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9?region=eu-west-1",
+			want:      KeyURI{Region: "eu-west-1", ResourceType: ResourceTypeKey, ID: "mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9"},
+			wantKeyID: "mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9",
+		},
+		{
+			uri:       "aws-kms://alias/unit-and-integration-testing",
+			want:      KeyURI{ResourceType: ResourceTypeAlias, ID: "unit-and-integration-testing"},
+			wantKeyID: "alias/unit-and-integration-testing",
+		},
+		{
+			uri:       "aws-kms://alias/unit-and-integration-testing?region=us-west-2",
+			want:      KeyURI{Region: "us-west-2", ResourceType: ResourceTypeAlias, ID: "unit-and-integration-testing"},
+			wantKeyID: "alias/unit-and-integration-testing",
+		},
+	} {
+		t.Run(tc.uri, func(t *testing.T) {
+			u, err := ParseKeyURI(tc.uri)
+			if err != nil {
+				t.Fatalf("ParseKeyURI(%q) err = %v, want nil", tc.uri, err)
+			}
+			if *u != tc.want {
+				t.Errorf("ParseKeyURI(%q) = %+v, want %+v", tc.uri, *u, tc.want)
+			}
+			if got := u.KeyID(); got != tc.wantKeyID {
+				t.Errorf("u.KeyID() = %q, want %q", got, tc.wantKeyID)
+			}
+			// String returns a canonical key URI, which parses to the same value.
+			u2, err := ParseKeyURI(u.String())
+			if err != nil {
+				t.Fatalf("ParseKeyURI(%q) err = %v, want nil", u.String(), err)
+			}
+			if *u2 != *u {
+				t.Errorf("ParseKeyURI(%q) = %+v, want %+v", u.String(), *u2, *u)
+			}
+		})
+	}
+}
+
+func TestParseKeyURI_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		uri     string
+		wantErr error
+	}{
+		{"", ErrInvalidScheme},
+		{"gcp-kms://3ee50705-5a82-4f5b-9753-05c4f473922f", ErrInvalidScheme},
+		{"aws-kms://", ErrInvalidKeyID},
+		{"aws-kms://not-a-key-id", ErrInvalidKeyID},
+		{"aws-kms://arn:aws:kms:us-east-2:235739564943:", ErrMalformedARN},
+		{"aws-kms://arn:aws:s3:::bucket", ErrMalformedARN},
+		{"aws-kms://arn:aws:kms:us-east-2:2357:key/3ee50705-5a82-4f5b-9753-05c4f473922f", ErrMalformedARN},
+		{"aws-kms://arn:aws:kms:us-east-2:235739564943:key/not-a-key-id", ErrInvalidKeyID},
+		{"aws-kms://arn:aws:kms:us-east-2:235739564943:alias/name with spaces", ErrInvalidAliasName},
+		{"aws-kms://alias/", ErrInvalidAliasName},
+		{"aws-kms://alias/name with spaces", ErrInvalidAliasName},
+		{symmetricKeyURI + "?region=us-west-2", ErrInvalidRegion},
+		{"aws-kms://alias/name?region=", ErrInvalidRegion},
+		{"aws-kms://alias/name?region=us-east-1&region=us-east-2", ErrInvalidRegion},
+		{"aws-kms://alias/name?profile=default", ErrUnsupportedParameter},
+		{"aws-kms://alias/name?region=%zz", ErrUnsupportedParameter},
+	} {
+		t.Run(tc.uri, func(t *testing.T) {
+			_, err := ParseKeyURI(tc.uri)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("ParseKeyURI(%q) err = %v, want %v", tc.uri, err, tc.wantErr)
+			}
+			var uriErr *KeyURIError
+			if !errors.As(err, &uriErr) || uriErr.URI != tc.uri {
+				t.Errorf("ParseKeyURI(%q) err = %v, want *KeyURIError for %q", tc.uri, err, tc.uri)
+			}
+		})
+	}
+}
+
+func TestSupportedIgnoresSchemeCase(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("AWS-KMS://arn:aws:kms:us-east-2:", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	for _, keyURI := range []string{symmetricKeyURI, "Aws-Kms://" + symmetricKeyARN} {
+		if !client.Supported(keyURI) {
+			t.Errorf("client.Supported(%q) = false, want true", keyURI)
+		}
+		if _, err := client.GetAEAD(keyURI); err != nil {
+			t.Errorf("client.GetAEAD(%q) err = %v, want nil", keyURI, err)
+		}
+	}
+	if client.Supported(eastReplicaURI) {
+		t.Errorf("client.Supported(%q) = true, want false", eastReplicaURI)
+	}
+}
+
+func TestSupportedDoesNotValidateKeyURI(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-2:", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	keyURI := "aws-kms://arn:aws:kms:us-east-2:235739564943:key/not-a-key-id"
+	if !client.Supported(keyURI) {
+		t.Errorf("client.Supported(%q) = false, want true", keyURI)
+	}
+	_, err = client.GetAEAD(keyURI)
+	var uriErr *KeyURIError
+	if !errors.As(err, &uriErr) {
+		t.Errorf("client.GetAEAD(%q) err = %v, want a *KeyURIError", keyURI, err)
+	}
+}
+
+func TestNewClientWithOptionsWithInvalidPrefixFails(t *testing.T) {
+	_, err := NewClientWithOptions("gcp-kms://")
+	if !errors.Is(err, ErrInvalidScheme) {
+		t.Errorf("NewClientWithOptions(\"gcp-kms://\") err = %v, want %v", err, ErrInvalidScheme)
+	}
+}
+
+func TestGetAEADWithKeyIDsAndAliases(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{symmetricKeyARN: kms.KeySpecSymmetricDefault}, fakeawskms.WithAlias("alias/unit-and-integration-testing", symmetricKeyARN))
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(symmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associatedData")
+	ciphertext, err := a.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Encrypt(plaintext, associatedData) err = %v, want nil", err)
+	}
+
+	for _, keyURI := range []string{
+		aliasURI,
+		"aws-kms://3ee50705-5a82-4f5b-9753-05c4f473922f",
+		"aws-kms://alias/unit-and-integration-testing",
+	} {
+		t.Run(keyURI, func(t *testing.T) {
+			a, err := client.GetAEAD(keyURI)
+			if err != nil {
+				t.Fatalf("client.GetAEAD(%q) err = %v, want nil", keyURI, err)
+			}
+			decrypted, err := a.Decrypt(ciphertext, associatedData)
+			if err != nil {
+				t.Fatalf("a.Decrypt(ciphertext, associatedData) err = %v, want nil", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+			}
+		})
+	}
+}
+
+func TestGetAEADWithRegionParameterUsesRegionalKMS(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{euReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	local := &regionalKMS{KMSAPI: fakekms}
+	eu := &regionalKMS{KMSAPI: fakekms}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(local), WithRegionalKMS("eu-west-1", eu))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD("aws-kms://mrk-0a1b2c3d4e5f60718293a4b5c6d7e8f9?region=eu-west-1")
+	if err != nil {
+		t.Fatalf("client.GetAEAD() err = %v, want nil", err)
+	}
+	if _, err := a.(*AWSAEAD).EncryptWithContext(context.Background(), []byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.EncryptWithContext() err = %v, want nil", err)
+	}
+	if eu.encryptRequests != 1 || local.encryptRequests != 0 {
+		t.Errorf("eu.encryptRequests = %d, local.encryptRequests = %d, want 1 and 0", eu.encryptRequests, local.encryptRequests)
+	}
+}
+
+func TestGetAEADWithInvalidKeyURIFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	keyURI := symmetricKeyURI + "?region=us-west-2"
+	if _, err := client.GetAEAD(keyURI); err == nil {
+		t.Errorf("client.GetAEAD(%q) err = nil, want error", keyURI)
+	}
+}
+
+// accessDeniedKMS fails DescribeKey requests with AccessDeniedException.
+type accessDeniedKMS struct {
+	kmsiface.KMSAPI
+	describeKeyRequests int
+}
+
+func (k *accessDeniedKMS) DescribeKeyWithContext(aws.Context, *kms.DescribeKeyInput, ...request.Option) (*kms.DescribeKeyOutput, error) {
+	k.describeKeyRequests++
+	return nil, awserr.New(accessDeniedException, "not authorized to perform kms:DescribeKey", nil)
+}
+
+func TestWithAliasResolution(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{symmetricKeyARN: kms.KeySpecSymmetricDefault}, fakeawskms.WithAlias("alias/unit-and-integration-testing", symmetricKeyARN))
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		kms  kmsiface.KMSAPI
+	}{
+		{"DescribeKey", fakekms},
+		{"ListAliases", &accessDeniedKMS{KMSAPI: fakekms}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewClientWithOptions("aws-kms://", WithKMS(tc.kms), WithAliasResolution())
+			if err != nil {
+				t.Fatalf("NewClientWithOptions() failed: %v", err)
+			}
+			client := c.(*Client)
+			for _, keyURI := range []string{aliasURI, "aws-kms://alias/unit-and-integration-testing"} {
+				a, err := client.GetAEAD(keyURI)
+				if err != nil {
+					t.Fatalf("client.GetAEAD(%q) err = %v, want nil", keyURI, err)
+				}
+				if got := a.(*AWSAEAD).KeyID(); got != symmetricKeyARN {
+					t.Errorf("a.KeyID() = %q, want %q", got, symmetricKeyARN)
+				}
+				resolved, err := client.ResolveKeyURI(keyURI)
+				if err != nil {
+					t.Fatalf("client.ResolveKeyURI(%q) err = %v, want nil", keyURI, err)
+				}
+				if resolved != symmetricKeyURI {
+					t.Errorf("client.ResolveKeyURI(%q) = %q, want %q", keyURI, resolved, symmetricKeyURI)
+				}
+			}
+		})
+	}
+}
+
+func TestWithAliasResolution_UnknownAliasFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	for _, k := range []kmsiface.KMSAPI{fakekms, &accessDeniedKMS{KMSAPI: fakekms}} {
+		client, err := NewClientWithOptions("aws-kms://", WithKMS(k), WithAliasResolution())
+		if err != nil {
+			t.Fatalf("NewClientWithOptions() failed: %v", err)
+		}
+		if _, err := client.GetAEAD(aliasURI); err == nil {
+			t.Errorf("client.GetAEAD(aliasURI) err = nil, want error")
+		}
+	}
+}
+
+func TestWithoutAliasResolutionAliasesArePassedAsIs(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{symmetricKeyARN: kms.KeySpecSymmetricDefault}, fakeawskms.WithAlias("alias/unit-and-integration-testing", symmetricKeyARN))
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	k := &accessDeniedKMS{KMSAPI: fakekms}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(k))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD(aliasURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(aliasURI) err = %v, want nil", err)
+	}
+	if got := a.(*AWSAEAD).KeyID(); got != aliasARN {
+		t.Errorf("a.KeyID() = %q, want %q", got, aliasARN)
+	}
+	if k.describeKeyRequests != 0 {
+		t.Errorf("k.describeKeyRequests = %d, want 0", k.describeKeyRequests)
+	}
+}
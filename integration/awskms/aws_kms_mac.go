@@ -0,0 +1,183 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+// maxMACMessageSize is the largest message AWS KMS accepts in GenerateMac and
+// VerifyMac requests.
+const maxMACMessageSize = 4096
+
+// macAlgorithms maps the KMS HMAC key specs to the MAC algorithm supported by
+// keys of that spec.
+var macAlgorithms = map[string]string{
+	kms.KeySpecHmac224: kms.MacAlgorithmSpecHmacSha224,
+	kms.KeySpecHmac256: kms.MacAlgorithmSpecHmacSha256,
+	kms.KeySpecHmac384: kms.MacAlgorithmSpecHmacSha384,
+	kms.KeySpecHmac512: kms.MacAlgorithmSpecHmacSha512,
+}
+
+func validateMACAlgorithm(macAlgorithm string) error {
+	for _, a := range kms.MacAlgorithmSpec_Values() {
+		if a == macAlgorithm {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported MAC algorithm %q", macAlgorithm)
+}
+
+func validateMACMessage(data []byte) error {
+	if len(data) > maxMACMessageSize {
+		return fmt.Errorf("data must be at most %d bytes, got %d", maxMACMessageSize, len(data))
+	}
+	return nil
+}
+
+// AWSMAC is an implementation of the MAC interface which computes and verifies
+// MACs remotely via the AWS KMS GenerateMac and VerifyMac APIs using an HMAC
+// key.
+//
+// AWS KMS limits data to 4096 bytes.
+type AWSMAC struct {
+	keyURI       string
+	kms          kmsiface.KMSAPI
+	macAlgorithm string
+}
+
+// ComputeMAC computes a MAC for data.
+func (m *AWSMAC) ComputeMAC(data []byte) ([]byte, error) {
+	return m.ComputeMACWithContext(context.Background(), data)
+}
+
+// ComputeMACWithContext computes a MAC for data.
+//
+// ctx is used for the KMS request, and opts are applied to it.
+func (m *AWSMAC) ComputeMACWithContext(ctx context.Context, data []byte, opts ...request.Option) ([]byte, error) {
+	if err := validateMACMessage(data); err != nil {
+		return nil, err
+	}
+	resp, err := m.kms.GenerateMacWithContext(ctx, &kms.GenerateMacInput{
+		KeyId:        aws.String(m.keyURI),
+		MacAlgorithm: aws.String(m.macAlgorithm),
+		Message:      data,
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Mac, nil
+}
+
+// VerifyMAC verifies whether mac is a correct MAC for data.
+func (m *AWSMAC) VerifyMAC(mac, data []byte) error {
+	return m.VerifyMACWithContext(context.Background(), mac, data)
+}
+
+// VerifyMACWithContext verifies whether mac is a correct MAC for data.
+//
+// ctx is used for the KMS request, and opts are applied to it.
+func (m *AWSMAC) VerifyMACWithContext(ctx context.Context, mac, data []byte, opts ...request.Option) error {
+	if err := validateMACMessage(data); err != nil {
+		return err
+	}
+	resp, err := m.kms.VerifyMacWithContext(ctx, &kms.VerifyMacInput{
+		KeyId:        aws.String(m.keyURI),
+		Mac:          mac,
+		MacAlgorithm: aws.String(m.macAlgorithm),
+		Message:      data,
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	if !aws.BoolValue(resp.MacValid) {
+		return errors.New("invalid MAC")
+	}
+	return nil
+}
+
+// GetMAC returns an implementation of the MAC interface which computes and
+// verifies MACs remotely via AWS KMS using the HMAC key keyURI.
+//
+// The MAC algorithm is the one supported by the key. It is looked up once via
+// the AWS KMS DescribeKey API, which fails if keyURI is not an HMAC key. Use
+// [Client.GetMACWithAlgorithm] to avoid this request.
+//
+// keyURI must be supported by this client and must have the same format as
+// for [Client.GetAEAD]. The returned MAC is an [*AWSMAC].
+func (c *Client) GetMAC(keyURI string) (tink.MAC, error) {
+	uri, k, err := c.keyFor(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.DescribeKeyWithContext(context.Background(), &kms.DescribeKeyInput{
+		KeyId: aws.String(uri),
+	})
+	if err != nil {
+		return nil, err
+	}
+	md := resp.KeyMetadata
+	if md == nil {
+		return nil, fmt.Errorf("no key metadata returned for %s", uri)
+	}
+	if usage := aws.StringValue(md.KeyUsage); usage != kms.KeyUsageTypeGenerateVerifyMac {
+		return nil, fmt.Errorf("key %s with key usage %s cannot be used as a MAC, key usage %s is required", uri, usage, kms.KeyUsageTypeGenerateVerifyMac)
+	}
+	macAlgorithm, ok := macAlgorithms[aws.StringValue(md.KeySpec)]
+	if !ok {
+		return nil, fmt.Errorf("key %s has unsupported key spec %s for MAC", uri, aws.StringValue(md.KeySpec))
+	}
+	return &AWSMAC{
+		keyURI:       uri,
+		kms:          k,
+		macAlgorithm: macAlgorithm,
+	}, nil
+}
+
+// GetMACWithAlgorithm returns an implementation of the MAC interface which
+// computes and verifies MACs remotely via AWS KMS using the HMAC key keyURI
+// and macAlgorithm.
+//
+// macAlgorithm must be one of the KMS MacAlgorithmSpec values, such as
+// kms.MacAlgorithmSpecHmacSha256, and must be supported by the key. Unlike
+// [Client.GetMAC], this doesn't call AWS KMS; using a key which is not an HMAC
+// key fails when computing or verifying MACs.
+//
+// keyURI must be supported by this client and must have the same format as
+// for [Client.GetAEAD]. The returned MAC is an [*AWSMAC].
+func (c *Client) GetMACWithAlgorithm(keyURI, macAlgorithm string) (tink.MAC, error) {
+	if err := validateMACAlgorithm(macAlgorithm); err != nil {
+		return nil, err
+	}
+	uri, k, err := c.keyFor(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	return &AWSMAC{
+		keyURI:       uri,
+		kms:          k,
+		macAlgorithm: macAlgorithm,
+	}, nil
+}
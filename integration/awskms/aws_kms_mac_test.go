@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+const (
+	hmacKeyARN = "arn:aws:kms:us-east-2:235739564943:key/5c1b8e2d-4f6a-4b3c-9d7e-8a2f0c6e1b4d"
+	hmacKeyURI = "aws-kms://arn:aws:kms:us-east-2:235739564943:key/5c1b8e2d-4f6a-4b3c-9d7e-8a2f0c6e1b4d"
+)
+
+func TestGetMACComputeVerify(t *testing.T) {
+	for _, tc := range []struct {
+		keySpec      string
+		macAlgorithm string
+		macSize      int
+	}{
+		{kms.KeySpecHmac224, kms.MacAlgorithmSpecHmacSha224, 28},
+		{kms.KeySpecHmac256, kms.MacAlgorithmSpecHmacSha256, 32},
+		{kms.KeySpecHmac384, kms.MacAlgorithmSpecHmacSha384, 48},
+		{kms.KeySpecHmac512, kms.MacAlgorithmSpecHmacSha512, 64},
+	} {
+		t.Run(tc.keySpec, func(t *testing.T) {
+			fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{hmacKeyARN: tc.keySpec})
+			if err != nil {
+				t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+			}
+			client := newTestClient(t, fakekms)
+			mac, err := client.GetMAC(hmacKeyURI)
+			if err != nil {
+				t.Fatalf("client.GetMAC(hmacKeyURI) err = %v, want nil", err)
+			}
+			macWithAlgorithm, err := client.GetMACWithAlgorithm(hmacKeyURI, tc.macAlgorithm)
+			if err != nil {
+				t.Fatalf("client.GetMACWithAlgorithm(hmacKeyURI, %q) err = %v, want nil", tc.macAlgorithm, err)
+			}
+
+			data := []byte("data")
+			tag, err := mac.ComputeMAC(data)
+			if err != nil {
+				t.Fatalf("mac.ComputeMAC(data) err = %v, want nil", err)
+			}
+			if len(tag) != tc.macSize {
+				t.Errorf("len(tag) = %d, want %d", len(tag), tc.macSize)
+			}
+			if err := macWithAlgorithm.VerifyMAC(tag, data); err != nil {
+				t.Errorf("macWithAlgorithm.VerifyMAC(tag, data) err = %v, want nil", err)
+			}
+			otherTag, err := macWithAlgorithm.ComputeMAC(data)
+			if err != nil {
+				t.Fatalf("macWithAlgorithm.ComputeMAC(data) err = %v, want nil", err)
+			}
+			if !bytes.Equal(tag, otherTag) {
+				t.Errorf("macWithAlgorithm.ComputeMAC(data) = %x, want %x", otherTag, tag)
+			}
+
+			if err := mac.VerifyMAC(tag, []byte("invalidData")); err == nil {
+				t.Error("mac.VerifyMAC(tag, invalidData) err = nil, want error")
+			}
+			if _, err := mac.ComputeMAC(make([]byte, 4097)); err == nil {
+				t.Error("mac.ComputeMAC(4097 bytes) err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestGetMACWithSymmetricEncryptionKeyFails(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{
+		hmacKeyARN:      kms.KeySpecHmac256,
+		symmetricKeyARN: kms.KeySpecSymmetricDefault,
+	})
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+
+	if _, err := client.GetMAC(symmetricKeyURI); err == nil {
+		t.Error("client.GetMAC(symmetricKeyURI) err = nil, want error")
+	}
+	mac, err := client.GetMACWithAlgorithm(symmetricKeyURI, kms.MacAlgorithmSpecHmacSha256)
+	if err != nil {
+		t.Fatalf("client.GetMACWithAlgorithm(symmetricKeyURI, _) err = %v, want nil", err)
+	}
+	if _, err := mac.ComputeMAC([]byte("data")); err == nil {
+		t.Error("mac.ComputeMAC(data) err = nil, want error")
+	}
+}
+
+func TestGetMACWithAlgorithmWithUnsupportedAlgorithmFails(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{hmacKeyARN: kms.KeySpecHmac256})
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+
+	if _, err := client.GetMACWithAlgorithm(hmacKeyURI, "HMAC_SHA_1"); err == nil {
+		t.Error("client.GetMACWithAlgorithm(hmacKeyURI, \"HMAC_SHA_1\") err = nil, want error")
+	}
+	mac, err := client.GetMACWithAlgorithm(hmacKeyURI, kms.MacAlgorithmSpecHmacSha512)
+	if err != nil {
+		t.Fatalf("client.GetMACWithAlgorithm(hmacKeyURI, _) err = %v, want nil", err)
+	}
+	if _, err := mac.ComputeMAC([]byte("data")); err == nil {
+		t.Error("mac.ComputeMAC(data) with mismatched algorithm err = nil, want error")
+	}
+}
+
+func TestGetMACWithUnsupportedKeyURIFails(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{hmacKeyARN: kms.KeySpecHmac256})
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-west-2:", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+
+	if _, err := client.(*Client).GetMAC(hmacKeyURI); err == nil {
+		t.Error("client.GetMAC(hmacKeyURI) err = nil, want error")
+	}
+	if _, err := client.(*Client).GetMACWithAlgorithm(hmacKeyURI, kms.MacAlgorithmSpecHmacSha256); err == nil {
+		t.Error("client.GetMACWithAlgorithm(hmacKeyURI, _) err = nil, want error")
+	}
+}
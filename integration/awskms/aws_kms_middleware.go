@@ -0,0 +1,209 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// kmsCall describes a request to AWS KMS.
+type kmsCall struct {
+	// op is the name of the AWS KMS API, such as "Encrypt".
+	op string
+	// keyID is the KeyId of the request, or "" if it has none. For ReEncrypt
+	// requests, it is the DestinationKeyId.
+	keyID string
+	// region is the region of the KMS client sending the request, or "" if it
+	// is unknown.
+	region string
+	// payloadSize is the size in bytes of the data processed by the request,
+	// such as the plaintext of Encrypt requests, or -1 if it has none.
+	payloadSize int
+	// input is the input of the request, such as a *kms.EncryptInput.
+	input any
+	// output is the output of the request, such as a *kms.EncryptOutput, once
+	// it succeeded.
+	output any
+}
+
+// kmsHandler sends a request to AWS KMS, or to the next middleware.
+type kmsHandler func(ctx context.Context) error
+
+// kmsMiddleware handles a request to AWS KMS, calling next to continue with it.
+type kmsMiddleware func(ctx context.Context, call *kmsCall, next kmsHandler) error
+
+// middlewareKMS passes the requests made to the underlying KMS client through
+// middlewares. The first middleware is the outermost one.
+type middlewareKMS struct {
+	kmsiface.KMSAPI
+	region      string
+	middlewares []kmsMiddleware
+}
+
+// invoke sends a request described by call with send, through the middlewares
+// of k. It returns the output of the request, which is the response of the last
+// call to send unless a middleware replaced it.
+func invoke[T any](ctx context.Context, k *middlewareKMS, call *kmsCall, send func(ctx context.Context) (T, error)) (T, error) {
+	call.region = k.region
+	h := func(ctx context.Context) error {
+		resp, err := send(ctx)
+		if err != nil {
+			return err
+		}
+		call.output = resp
+		return nil
+	}
+	for i := len(k.middlewares) - 1; i >= 0; i-- {
+		m, next := k.middlewares[i], kmsHandler(h)
+		h = func(ctx context.Context) error { return m(ctx, call, next) }
+	}
+	var zero T
+	if err := h(ctx); err != nil {
+		return zero, err
+	}
+	resp, ok := call.output.(T)
+	if !ok {
+		return zero, fmt.Errorf("AWS KMS %s returned a response of type %T, want %T", call.op, call.output, zero)
+	}
+	return resp, nil
+}
+
+// Encrypt implements kmsiface.KMSAPI.
+func (k *middlewareKMS) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	call := &kmsCall{op: "Encrypt", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.Plaintext), input: input}
+	return invoke(context.Background(), k, call, func(context.Context) (*kms.EncryptOutput, error) {
+		return k.KMSAPI.Encrypt(input)
+	})
+}
+
+// EncryptWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	call := &kmsCall{op: "Encrypt", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.Plaintext), input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.EncryptOutput, error) {
+		return k.KMSAPI.EncryptWithContext(ctx, input, opts...)
+	})
+}
+
+// Decrypt implements kmsiface.KMSAPI.
+func (k *middlewareKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	call := &kmsCall{op: "Decrypt", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.CiphertextBlob), input: input}
+	return invoke(context.Background(), k, call, func(context.Context) (*kms.DecryptOutput, error) {
+		return k.KMSAPI.Decrypt(input)
+	})
+}
+
+// DecryptWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	call := &kmsCall{op: "Decrypt", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.CiphertextBlob), input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.DecryptOutput, error) {
+		return k.KMSAPI.DecryptWithContext(ctx, input, opts...)
+	})
+}
+
+// ReEncryptWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) ReEncryptWithContext(ctx aws.Context, input *kms.ReEncryptInput, opts ...request.Option) (*kms.ReEncryptOutput, error) {
+	call := &kmsCall{op: "ReEncrypt", keyID: aws.StringValue(input.DestinationKeyId), payloadSize: len(input.CiphertextBlob), input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.ReEncryptOutput, error) {
+		return k.KMSAPI.ReEncryptWithContext(ctx, input, opts...)
+	})
+}
+
+// GenerateDataKeyWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	call := &kmsCall{op: "GenerateDataKey", keyID: aws.StringValue(input.KeyId), payloadSize: -1, input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.GenerateDataKeyOutput, error) {
+		return k.KMSAPI.GenerateDataKeyWithContext(ctx, input, opts...)
+	})
+}
+
+// GenerateDataKeyWithoutPlaintextWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) GenerateDataKeyWithoutPlaintextWithContext(ctx aws.Context, input *kms.GenerateDataKeyWithoutPlaintextInput, opts ...request.Option) (*kms.GenerateDataKeyWithoutPlaintextOutput, error) {
+	call := &kmsCall{op: "GenerateDataKeyWithoutPlaintext", keyID: aws.StringValue(input.KeyId), payloadSize: -1, input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.GenerateDataKeyWithoutPlaintextOutput, error) {
+		return k.KMSAPI.GenerateDataKeyWithoutPlaintextWithContext(ctx, input, opts...)
+	})
+}
+
+// SignWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) SignWithContext(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error) {
+	call := &kmsCall{op: "Sign", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.Message), input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.SignOutput, error) {
+		return k.KMSAPI.SignWithContext(ctx, input, opts...)
+	})
+}
+
+// VerifyWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) VerifyWithContext(ctx aws.Context, input *kms.VerifyInput, opts ...request.Option) (*kms.VerifyOutput, error) {
+	call := &kmsCall{op: "Verify", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.Message), input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.VerifyOutput, error) {
+		return k.KMSAPI.VerifyWithContext(ctx, input, opts...)
+	})
+}
+
+// GetPublicKeyWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) GetPublicKeyWithContext(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+	call := &kmsCall{op: "GetPublicKey", keyID: aws.StringValue(input.KeyId), payloadSize: -1, input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.GetPublicKeyOutput, error) {
+		return k.KMSAPI.GetPublicKeyWithContext(ctx, input, opts...)
+	})
+}
+
+// GenerateMacWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) GenerateMacWithContext(ctx aws.Context, input *kms.GenerateMacInput, opts ...request.Option) (*kms.GenerateMacOutput, error) {
+	call := &kmsCall{op: "GenerateMac", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.Message), input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.GenerateMacOutput, error) {
+		return k.KMSAPI.GenerateMacWithContext(ctx, input, opts...)
+	})
+}
+
+// VerifyMacWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) VerifyMacWithContext(ctx aws.Context, input *kms.VerifyMacInput, opts ...request.Option) (*kms.VerifyMacOutput, error) {
+	call := &kmsCall{op: "VerifyMac", keyID: aws.StringValue(input.KeyId), payloadSize: len(input.Message), input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.VerifyMacOutput, error) {
+		return k.KMSAPI.VerifyMacWithContext(ctx, input, opts...)
+	})
+}
+
+// CreateGrantWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) CreateGrantWithContext(ctx aws.Context, input *kms.CreateGrantInput, opts ...request.Option) (*kms.CreateGrantOutput, error) {
+	call := &kmsCall{op: "CreateGrant", keyID: aws.StringValue(input.KeyId), payloadSize: -1, input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.CreateGrantOutput, error) {
+		return k.KMSAPI.CreateGrantWithContext(ctx, input, opts...)
+	})
+}
+
+// DescribeKeyWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+	call := &kmsCall{op: "DescribeKey", keyID: aws.StringValue(input.KeyId), payloadSize: -1, input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.DescribeKeyOutput, error) {
+		return k.KMSAPI.DescribeKeyWithContext(ctx, input, opts...)
+	})
+}
+
+// ListAliasesWithContext implements kmsiface.KMSAPI.
+func (k *middlewareKMS) ListAliasesWithContext(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error) {
+	call := &kmsCall{op: "ListAliases", keyID: aws.StringValue(input.KeyId), payloadSize: -1, input: input}
+	return invoke(ctx, k, call, func(ctx context.Context) (*kms.ListAliasesOutput, error) {
+		return k.KMSAPI.ListAliasesWithContext(ctx, input, opts...)
+	})
+}
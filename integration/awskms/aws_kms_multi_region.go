@@ -0,0 +1,230 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	kmsv2 "github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// multiRegionKey is a replica of a multi-region key.
+type multiRegionKey struct {
+	partition string
+	region    string
+	account   string
+	keyID     string
+}
+
+// multiRegionKeyOf returns the multi-region key u refers to, if u is the ARN
+// of a key whose key ID starts with "mrk-".
+func multiRegionKeyOf(u *KeyURI) (*multiRegionKey, bool) {
+	if u.Partition == "" || u.ResourceType != ResourceTypeKey || !strings.HasPrefix(u.ID, "mrk-") {
+		return nil, false
+	}
+	return &multiRegionKey{
+		partition: u.Partition,
+		region:    u.Region,
+		account:   u.Account,
+		keyID:     u.ID,
+	}, true
+}
+
+// id identifies the multi-region key independently of the region.
+func (k *multiRegionKey) id() string {
+	return k.partition + ":" + k.account + ":" + k.keyID
+}
+
+func (k *multiRegionKey) arn() string {
+	return fmt.Sprintf("arn:%s:kms:%s:%s:key/%s", k.partition, k.region, k.account, k.keyID)
+}
+
+// WithMultiRegionKeyReplicas registers keyURIs as the replicas of a
+// multi-region key. The option can be given several times to register
+// several keys.
+//
+// keyURIs must be ARNs of the same multi-region key, whose key ID starts with
+// "mrk-", in at least two different regions, prefixed with "aws-kms://". They
+// are supported by the client even if they don't start with its URI prefix.
+//
+// AEADs returned by [Client.GetAEAD] for any of the replicas send requests to
+// the replica in the region of the URI prefix of the client first, if any,
+// then to the replica requested, then to the other replicas in the order of
+// keyURIs. Encryption and decryption fail over to the next replica when a
+// request fails, unless the error is one which all replicas would return, such
+// as an invalid ciphertext, or ctx is done.
+//
+// The client creates one KMS client per region, unless the KMS client was
+// set with [WithKMS] or [WithKMSV2]. Then, use [WithRegionalKMS] or
+// [WithRegionalKMSV2] to set the KMS clients of the other regions.
+func WithMultiRegionKeyReplicas(keyURIs ...string) ClientOption {
+	return option(func(a *Client) error {
+		if len(keyURIs) < 2 {
+			return fmt.Errorf("a multi-region key needs at least 2 replicas, got %d", len(keyURIs))
+		}
+		var replicas []*multiRegionKey
+		regions := make(map[string]bool)
+		for _, keyURI := range keyURIs {
+			u, err := ParseKeyURI(keyURI)
+			if err != nil {
+				return err
+			}
+			k, ok := multiRegionKeyOf(u)
+			if !ok {
+				return fmt.Errorf("%q is not the ARN of a multi-region key", keyURI)
+			}
+			if len(replicas) > 0 && k.id() != replicas[0].id() {
+				return fmt.Errorf("%q is not a replica of the same key as %q", keyURI, keyURIs[0])
+			}
+			if regions[k.region] {
+				return fmt.Errorf("more than one replica in region %s", k.region)
+			}
+			regions[k.region] = true
+			replicas = append(replicas, k)
+		}
+		if _, ok := a.multiRegionKeys[replicas[0].id()]; ok {
+			return fmt.Errorf("replicas of %s already set", replicas[0].keyID)
+		}
+		a.multiRegionKeys[replicas[0].id()] = replicas
+		return nil
+	})
+}
+
+// WithRegionalKMS sets the underlying AWS KMS client for region to kms.
+//
+// Requests to keys in region, such as replicas of multi-region keys, use kms.
+// If region is the region of the URI prefix of the client, kms is used instead
+// of the KMS client set with [WithKMS] or [WithKMSV2]. It's the callers
+// responsibility to ensure that the configured region of kms is region.
+func WithRegionalKMS(region string, kms kmsiface.KMSAPI) ClientOption {
+	return option(func(a *Client) error {
+		if kms == nil {
+			return errors.New("WithRegionalKMS option cannot be used with a nil client")
+		}
+		return a.setRegionalKMS("WithRegionalKMS", region, kms)
+	})
+}
+
+// WithRegionalKMSV2 sets the underlying AWS KMS client for region to client,
+// a preexisting AWS SDK for Go v2 KMS client instance, like
+// [WithRegionalKMS] does for v1 clients.
+func WithRegionalKMSV2(region string, client *kmsv2.Client) ClientOption {
+	return option(func(a *Client) error {
+		if client == nil {
+			return errors.New("WithRegionalKMSV2 option cannot be used with a nil client")
+		}
+		return a.setRegionalKMS("WithRegionalKMSV2", region, newKMSV2Adapter(client))
+	})
+}
+
+// setRegionalKMS sets the KMS client for region to kms, on behalf of the
+// option named name.
+func (c *Client) setRegionalKMS(name, region string, kms kmsiface.KMSAPI) error {
+	if region == "" {
+		return fmt.Errorf("%s option cannot be used with an empty region", name)
+	}
+	if _, ok := c.regionalKMS[region]; ok {
+		return fmt.Errorf("%s option cannot be used, KMS client for region %s already set", name, region)
+	}
+	c.regionalKMS[region] = kms
+	return nil
+}
+
+// isMultiRegionReplica returns whether u is a replica registered with
+// [WithMultiRegionKeyReplicas].
+func (c *Client) isMultiRegionReplica(u *KeyURI) bool {
+	k, ok := multiRegionKeyOf(u)
+	if !ok {
+		return false
+	}
+	for _, r := range c.multiRegionKeys[k.id()] {
+		if r.region == k.region {
+			return true
+		}
+	}
+	return false
+}
+
+// awsReplica is a replica of a multi-region key and the KMS client of its
+// region.
+type awsReplica struct {
+	keyURI string
+	kms    kmsiface.KMSAPI
+}
+
+// multiRegionReplicas returns the replicas to use for keyARN, in the order in
+// which they are tried. It returns nil if keyARN is not a registered
+// multi-region key.
+func (c *Client) multiRegionReplicas(keyARN string) ([]awsReplica, error) {
+	u, err := ParseKeyURI(awsPrefix + keyARN)
+	if err != nil {
+		return nil, nil
+	}
+	k, ok := multiRegionKeyOf(u)
+	if !ok {
+		return nil, nil
+	}
+	registered, ok := c.multiRegionKeys[k.id()]
+	if !ok {
+		return nil, nil
+	}
+
+	ordered := []*multiRegionKey{k}
+	for _, r := range registered {
+		if r.region != k.region {
+			ordered = append(ordered, r)
+		}
+	}
+	// Prefer the replica in the local region.
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].region == c.localRegion && ordered[j].region != c.localRegion
+	})
+
+	replicas := make([]awsReplica, 0, len(ordered))
+	for _, r := range ordered {
+		kms, err := c.kmsForRegion(r.region)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, awsReplica{keyURI: r.arn(), kms: c.wrapKMS(kms, r.region)})
+	}
+	return replicas, nil
+}
+
+// shouldFailOver returns whether a request which failed with err should be
+// sent to another replica.
+func shouldFailOver(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case kms.ErrCodeInvalidCiphertextException, kms.ErrCodeIncorrectKeyException, request.CanceledErrorCode:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,301 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// regionalKMS counts the requests sent to a region and fails them with err,
+// if set.
+type regionalKMS struct {
+	kmsiface.KMSAPI
+	err             error
+	encryptRequests int
+	decryptRequests int
+}
+
+func (r *regionalKMS) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	r.encryptRequests++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.KMSAPI.EncryptWithContext(ctx, input, opts...)
+}
+
+func (r *regionalKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	r.decryptRequests++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.KMSAPI.DecryptWithContext(ctx, input, opts...)
+}
+
+type multiRegionTestSetup struct {
+	client *Client
+	east   *regionalKMS
+	west   *regionalKMS
+	eu     *regionalKMS
+}
+
+func newMultiRegionTestSetup(t *testing.T, uriPrefix string) *multiRegionTestSetup {
+	t.Helper()
+	fakekms, err := fakeawskms.New([]string{eastReplicaARN, westReplicaARN, euReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	s := &multiRegionTestSetup{
+		east: &regionalKMS{KMSAPI: fakekms},
+		west: &regionalKMS{KMSAPI: fakekms},
+		eu:   &regionalKMS{KMSAPI: fakekms},
+	}
+	client, err := NewClientWithOptions(uriPrefix,
+		WithRegionalKMS("us-east-1", s.east),
+		WithRegionalKMS("us-west-2", s.west),
+		WithRegionalKMS("eu-west-1", s.eu),
+		WithMultiRegionKeyReplicas(eastReplicaURI, westReplicaURI, euReplicaURI))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	s.client = client.(*Client)
+	return s
+}
+
+func TestMultiRegionKeyEncryptionPrefersLocalRegion(t *testing.T) {
+	s := newMultiRegionTestSetup(t, "aws-kms://arn:aws:kms:us-west-2:")
+
+	for _, keyURI := range []string{eastReplicaURI, westReplicaURI, euReplicaURI} {
+		a, err := s.client.GetAEAD(keyURI)
+		if err != nil {
+			t.Fatalf("client.GetAEAD(%q) err = %v, want nil", keyURI, err)
+		}
+		if _, err := a.Encrypt([]byte("plaintext"), []byte("associatedData")); err != nil {
+			t.Fatalf("a.Encrypt() err = %v, want nil", err)
+		}
+	}
+	if s.west.encryptRequests != 3 || s.east.encryptRequests != 0 || s.eu.encryptRequests != 0 {
+		t.Errorf("encrypt requests (west, east, eu) = (%d, %d, %d), want (3, 0, 0)", s.west.encryptRequests, s.east.encryptRequests, s.eu.encryptRequests)
+	}
+}
+
+func TestMultiRegionKeyDecryptionFailsOver(t *testing.T) {
+	s := newMultiRegionTestSetup(t, "aws-kms://arn:aws:kms:us-east-1:")
+	a, err := s.client.GetAEAD(westReplicaURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(westReplicaURI) err = %v, want nil", err)
+	}
+
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associatedData")
+	ciphertext, err := a.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+
+	s.east.err = awserr.New("ThrottlingException", "Rate exceeded", nil)
+	s.west.err = awserr.New(kms.ErrCodeKeyUnavailableException, "unavailable", nil)
+	decrypted, err := a.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Decrypt() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+	// Local region first, then the requested replica, then the others.
+	if s.east.decryptRequests != 1 || s.west.decryptRequests != 1 || s.eu.decryptRequests != 1 {
+		t.Errorf("decrypt requests (east, west, eu) = (%d, %d, %d), want (1, 1, 1)", s.east.decryptRequests, s.west.decryptRequests, s.eu.decryptRequests)
+	}
+
+	s.eu.err = s.west.err
+	if _, err := a.Decrypt(ciphertext, associatedData); !errors.Is(err, s.eu.err) {
+		t.Errorf("a.Decrypt() err = %v, want %v", err, s.eu.err)
+	}
+}
+
+func TestMultiRegionKeyEncryptionFailsOver(t *testing.T) {
+	s := newMultiRegionTestSetup(t, "aws-kms://arn:aws:kms:us-east-1:")
+	a, err := s.client.GetAEAD(eastReplicaURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(eastReplicaURI) err = %v, want nil", err)
+	}
+
+	s.east.err = awserr.New(kms.ErrCodeInternalException, "internal error", nil)
+	ciphertext, err := a.Encrypt([]byte("plaintext"), nil)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	s.east.err = nil
+	if _, err := a.Decrypt(ciphertext, nil); err != nil {
+		t.Errorf("a.Decrypt() err = %v, want nil", err)
+	}
+	if s.west.encryptRequests != 1 {
+		t.Errorf("s.west.encryptRequests = %d, want 1", s.west.encryptRequests)
+	}
+}
+
+func TestMultiRegionKeyDoesNotFailOverOnInvalidCiphertext(t *testing.T) {
+	s := newMultiRegionTestSetup(t, "aws-kms://arn:aws:kms:us-east-1:")
+	a, err := s.client.GetAEAD(eastReplicaURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(eastReplicaURI) err = %v, want nil", err)
+	}
+
+	s.east.err = awserr.New(kms.ErrCodeInvalidCiphertextException, "invalid ciphertext", nil)
+	if _, err := a.Decrypt([]byte("ciphertext"), nil); err == nil {
+		t.Fatal("a.Decrypt() err = nil, want error")
+	}
+	if s.west.decryptRequests != 0 || s.eu.decryptRequests != 0 {
+		t.Errorf("decrypt requests (west, eu) = (%d, %d), want (0, 0)", s.west.decryptRequests, s.eu.decryptRequests)
+	}
+
+	s.east.err = awserr.New("ThrottlingException", "Rate exceeded", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.(*AWSAEAD).DecryptWithContext(ctx, []byte("ciphertext"), nil); err == nil {
+		t.Fatal("a.DecryptWithContext(canceledCtx) err = nil, want error")
+	}
+	if s.west.decryptRequests != 0 {
+		t.Errorf("s.west.decryptRequests = %d, want 0", s.west.decryptRequests)
+	}
+}
+
+func TestMultiRegionKeyWithKMS(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{eastReplicaARN, westReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	east := &regionalKMS{KMSAPI: fakekms, err: awserr.New("ThrottlingException", "Rate exceeded", nil)}
+	west := &regionalKMS{KMSAPI: fakekms}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-1:",
+		WithKMS(east),
+		WithRegionalKMS("us-west-2", west),
+		WithMultiRegionKeyReplicas(eastReplicaURI, westReplicaURI))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD(eastReplicaURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(eastReplicaURI) err = %v, want nil", err)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if east.encryptRequests != 1 || west.encryptRequests != 1 {
+		t.Errorf("encrypt requests (east, west) = (%d, %d), want (1, 1)", east.encryptRequests, west.encryptRequests)
+	}
+}
+
+func TestWithRegionalKMSForLocalRegionOverridesWithKMS(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{eastReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	local := &regionalKMS{KMSAPI: fakekms}
+	east := &regionalKMS{KMSAPI: fakekms}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-east-1:", WithKMS(local), WithRegionalKMS("us-east-1", east))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD(eastReplicaURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(eastReplicaURI) err = %v, want nil", err)
+	}
+	if _, err := a.(*AWSAEAD).EncryptWithContext(context.Background(), []byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.EncryptWithContext() err = %v, want nil", err)
+	}
+	if east.encryptRequests != 1 || local.encryptRequests != 0 {
+		t.Errorf("encrypt requests (east, local) = (%d, %d), want (1, 0)", east.encryptRequests, local.encryptRequests)
+	}
+}
+
+func TestUnregisteredMultiRegionKeyDoesNotFailOver(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{eastReplicaARN, westReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	east := &regionalKMS{KMSAPI: fakekms, err: awserr.New("ThrottlingException", "Rate exceeded", nil)}
+	client, err := NewClientWithOptions("aws-kms://", WithKMS(east))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	a, err := client.GetAEAD(eastReplicaURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(eastReplicaURI) err = %v, want nil", err)
+	}
+	if _, err := a.(*AWSAEAD).EncryptWithContext(context.Background(), []byte("plaintext"), nil); err == nil {
+		t.Error("a.EncryptWithContext() err = nil, want error")
+	}
+}
+
+func TestWithMultiRegionKeyReplicasInvalidReplicasFails(t *testing.T) {
+	otherKeyURI := "aws-kms://arn:aws:kms:eu-west-1:235739564943:key/mrk-ffffffffffffffffffffffffffffffff"
+	singleRegionKeyURI := "aws-kms://arn:aws:kms:eu-west-1:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	fakekms, err := fakeawskms.New([]string{eastReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name    string
+		keyURIs []string
+	}{
+		{"no replicas", nil},
+		{"single replica", []string{eastReplicaURI}},
+		{"missing prefix", []string{eastReplicaARN, westReplicaURI}},
+		{"not a multi-region key", []string{eastReplicaURI, singleRegionKeyURI}},
+		{"different keys", []string{eastReplicaURI, otherKeyURI}},
+		{"same region", []string{eastReplicaURI, eastReplicaURI}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithMultiRegionKeyReplicas(tc.keyURIs...)); err == nil {
+				t.Error("NewClientWithOptions() err = nil, want error")
+			}
+		})
+	}
+
+	_, err = NewClientWithOptions("aws-kms://", WithKMS(fakekms),
+		WithMultiRegionKeyReplicas(eastReplicaURI, westReplicaURI),
+		WithMultiRegionKeyReplicas(euReplicaURI, westReplicaURI))
+	if err == nil {
+		t.Error("NewClientWithOptions() with repeated key err = nil, want error")
+	}
+}
+
+func TestWithRegionalKMSInvalidArgumentsFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{eastReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	if _, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithRegionalKMS("", fakekms)); err == nil {
+		t.Error("WithRegionalKMS(\"\", _) err = nil, want error")
+	}
+	if _, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithRegionalKMS("us-east-1", nil)); err == nil {
+		t.Error("WithRegionalKMS(_, nil) err = nil, want error")
+	}
+	if _, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithRegionalKMS("us-east-1", fakekms), WithRegionalKMS("us-east-1", fakekms)); err == nil {
+		t.Error("repeated WithRegionalKMS() err = nil, want error")
+	}
+}
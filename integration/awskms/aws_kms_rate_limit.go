@@ -0,0 +1,241 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit specifies how the requests of a client to AWS KMS are limited,
+// see [WithRateLimit].
+type RateLimit struct {
+	// RequestsPerSecond is the rate at which requests can be made, per region
+	// or per key if PerKey is set. If zero, the rate is not limited.
+	RequestsPerSecond float64
+	// Burst is the number of requests which can be made at once when no
+	// requests were made for a while. If zero, it is RequestsPerSecond rounded
+	// up.
+	Burst int
+	// PerKey makes the rate apply to the requests of each key separately,
+	// instead of to the requests of each region. The state of keys whose
+	// requests are all allowed again, after some idle time, is dropped.
+	PerKey bool
+	// MaxInFlight is the maximum number of concurrent requests of the client.
+	// If zero, it is not limited.
+	MaxInFlight int
+}
+
+// minBucketSweep is the number of token buckets of a rateLimiter above which
+// idle ones are first evicted.
+const minBucketSweep = 64
+
+// rateLimiter limits the requests of a client, see [WithRateLimit].
+type rateLimiter struct {
+	rate  float64
+	burst int
+	// perKey is set by RateLimit.PerKey.
+	perKey bool
+	// inFlight has a value for each request in flight. It is nil if the
+	// number of requests in flight is not limited.
+	inFlight chan struct{}
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// sweepAt is the number of buckets at which idle buckets are evicted
+	// before adding another one.
+	sweepAt int
+}
+
+func newRateLimiter(l RateLimit) (*rateLimiter, error) {
+	if l.RequestsPerSecond < 0 || math.IsNaN(l.RequestsPerSecond) || math.IsInf(l.RequestsPerSecond, 0) {
+		return nil, fmt.Errorf("RequestsPerSecond must be a non-negative number, got %v", l.RequestsPerSecond)
+	}
+	if l.Burst < 0 || l.MaxInFlight < 0 {
+		return nil, errors.New("Burst and MaxInFlight must not be negative")
+	}
+	if l.RequestsPerSecond == 0 && l.MaxInFlight == 0 {
+		return nil, errors.New("either RequestsPerSecond or MaxInFlight must be set")
+	}
+	if l.RequestsPerSecond == 0 && (l.Burst != 0 || l.PerKey) {
+		return nil, errors.New("Burst and PerKey require RequestsPerSecond")
+	}
+	r := &rateLimiter{
+		rate:    l.RequestsPerSecond,
+		burst:   l.Burst,
+		perKey:  l.PerKey,
+		buckets: make(map[string]*tokenBucket),
+		sweepAt: minBucketSweep,
+	}
+	if r.burst == 0 {
+		r.burst = int(math.Ceil(r.rate))
+	}
+	if l.MaxInFlight > 0 {
+		r.inFlight = make(chan struct{}, l.MaxInFlight)
+	}
+	return r, nil
+}
+
+// WithRateLimit makes the client limit the rate of its requests to AWS KMS,
+// with a token bucket per region or per key, and the number of its concurrent
+// requests, as specified by limit. The limits are shared by all the primitives
+// of the client.
+//
+// Requests wait until they are allowed by the limits, or until their context
+// is done. Requests which would have to wait past the deadline of their context
+// fail immediately with an error matching [context.DeadlineExceeded].
+//
+// Each attempt of requests retried with [WithRetryPolicy] is limited.
+func WithRateLimit(limit RateLimit) ClientOption {
+	return option(func(a *Client) error {
+		if a.rateLimiter != nil {
+			return errors.New("WithRateLimit option cannot be used, rate limit already set")
+		}
+		r, err := newRateLimiter(limit)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %v", err)
+		}
+		a.rateLimiter = r
+		return nil
+	})
+}
+
+// reserve takes a token from the token bucket of call, creating it if needed,
+// see [tokenBucket.reserve]. It returns the bucket.
+func (r *rateLimiter) reserve(call *kmsCall, now time.Time, maxWait time.Duration) (*tokenBucket, time.Duration, bool) {
+	name := call.region
+	if r.perKey {
+		name = call.keyID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.buckets[name]
+	if !ok {
+		if len(r.buckets) >= r.sweepAt {
+			r.evictIdle(now)
+		}
+		b = &tokenBucket{rate: r.rate, burst: float64(r.burst), tokens: float64(r.burst), last: now}
+		r.buckets[name] = b
+	}
+	d, ok := b.reserve(now, maxWait)
+	return b, d, ok
+}
+
+// evictIdle drops the buckets which are full at now, since they behave as new
+// ones, so that the buckets of keys which are no longer used don't accumulate.
+// The next sweep happens once the number of buckets doubled.
+func (r *rateLimiter) evictIdle(now time.Time) {
+	for name, b := range r.buckets {
+		if b.full(now) {
+			delete(r.buckets, name)
+		}
+	}
+	r.sweepAt = max(minBucketSweep, 2*len(r.buckets))
+}
+
+// wait waits until the token bucket of call allows a request, or until ctx is
+// done.
+func (r *rateLimiter) wait(ctx context.Context, call *kmsCall) error {
+	maxWait := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = time.Until(deadline)
+	}
+	b, d, ok := r.reserve(call, time.Now(), maxWait)
+	if !ok {
+		return fmt.Errorf("%w: the rate limit requires waiting %v", context.DeadlineExceeded, d)
+	}
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		b.release()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// middleware waits until requests are allowed by the limits, see
+// [WithRateLimit].
+func (r *rateLimiter) middleware(ctx context.Context, call *kmsCall, next kmsHandler) error {
+	if r.rate > 0 {
+		if err := r.wait(ctx, call); err != nil {
+			return err
+		}
+	}
+	if r.inFlight != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r.inFlight <- struct{}{}:
+		}
+		defer func() { <-r.inFlight }()
+	}
+	return next(ctx)
+}
+
+// tokenBucket allows requests at a rate, with bursts of up to burst requests.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from b, and returns how long to wait until the token
+// is available. If this is longer than maxWait, no token is taken and reserve
+// returns false.
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	var d time.Duration
+	if b.tokens < 1 {
+		d = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if d > maxWait {
+		return d, false
+	}
+	b.tokens--
+	return d, true
+}
+
+// full returns whether b has burst tokens at now.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+max(now.Sub(b.last).Seconds(), 0)*b.rate >= b.burst
+}
+
+// release returns a token taken by reserve which was not used.
+func (b *tokenBucket) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
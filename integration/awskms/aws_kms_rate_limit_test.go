@@ -0,0 +1,272 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// slowKMS delays Encrypt requests and records the maximum number of
+// concurrent requests.
+type slowKMS struct {
+	kmsiface.KMSAPI
+	delay time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *slowKMS) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	s.mu.Lock()
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+	time.Sleep(s.delay)
+	return s.KMSAPI.EncryptWithContext(ctx, input, opts...)
+}
+
+func encryptAll(t *testing.T, ctx context.Context, aeads ...*AWSAEAD) {
+	t.Helper()
+	for _, a := range aeads {
+		if _, err := a.EncryptWithContext(ctx, []byte("plaintext"), nil); err != nil {
+			t.Fatalf("a.EncryptWithContext() err = %v, want nil", err)
+		}
+	}
+}
+
+func TestRateLimitIsSharedByPrimitives(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms, WithRateLimit(RateLimit{RequestsPerSecond: 0.001, Burst: 1}))
+	a, err := client.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	b, err := client.GetAEADWithOptions(otherSymmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(otherSymmetricKeyURI) err = %v, want nil", err)
+	}
+	encryptAll(t, context.Background(), a)
+
+	// The request of b would have to wait for the token used by a.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := b.EncryptWithContext(ctx, []byte("plaintext"), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("b.EncryptWithContext() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// reserveAll reserves a token for each call at now and returns how long each
+// of them has to wait.
+func reserveAll(t *testing.T, r *rateLimiter, now time.Time, calls ...*kmsCall) []time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	for _, call := range calls {
+		_, d, ok := r.reserve(call, now, time.Duration(math.MaxInt64))
+		if !ok {
+			t.Fatalf("r.reserve() ok = false, want true")
+		}
+		waits = append(waits, d.Round(time.Millisecond))
+	}
+	return waits
+}
+
+func TestRateLimitWaits(t *testing.T) {
+	r, err := newRateLimiter(RateLimit{RequestsPerSecond: 20, Burst: 1})
+	if err != nil {
+		t.Fatalf("newRateLimiter() err = %v, want nil", err)
+	}
+	a := &kmsCall{region: "us-east-2", keyID: symmetricKeyARN}
+	b := &kmsCall{region: "us-east-2", keyID: otherSymmetricKeyARN}
+	now := time.Now()
+	// The first request uses the burst, the other ones wait 50ms more each.
+	got := reserveAll(t, r, now, a, b, a, b)
+	want := []time.Duration{0, 50 * time.Millisecond, 100 * time.Millisecond, 150 * time.Millisecond}
+	if !slices.Equal(got, want) {
+		t.Errorf("waits = %v, want %v", got, want)
+	}
+	// The tokens are available again after the waits.
+	if got := reserveAll(t, r, now.Add(200*time.Millisecond), a); got[0] != 0 {
+		t.Errorf("wait after 200ms = %v, want 0", got[0])
+	}
+}
+
+func TestRateLimitAllowsBurst(t *testing.T) {
+	r, err := newRateLimiter(RateLimit{RequestsPerSecond: 1, Burst: 3})
+	if err != nil {
+		t.Fatalf("newRateLimiter() err = %v, want nil", err)
+	}
+	a := &kmsCall{region: "us-east-2", keyID: symmetricKeyARN}
+	got := reserveAll(t, r, time.Now(), a, a, a, a)
+	if want := []time.Duration{0, 0, 0, time.Second}; !slices.Equal(got, want) {
+		t.Errorf("waits = %v, want %v", got, want)
+	}
+}
+
+func TestRateLimitPerKey(t *testing.T) {
+	r, err := newRateLimiter(RateLimit{RequestsPerSecond: 1, PerKey: true})
+	if err != nil {
+		t.Fatalf("newRateLimiter() err = %v, want nil", err)
+	}
+	a := &kmsCall{region: "us-east-2", keyID: symmetricKeyARN}
+	b := &kmsCall{region: "us-east-2", keyID: otherSymmetricKeyARN}
+	got := reserveAll(t, r, time.Now(), a, b, a)
+	if want := []time.Duration{0, 0, time.Second}; !slices.Equal(got, want) {
+		t.Errorf("waits = %v, want %v", got, want)
+	}
+}
+
+func TestRateLimitPerKeyEvictsIdleBuckets(t *testing.T) {
+	r, err := newRateLimiter(RateLimit{RequestsPerSecond: 10, PerKey: true})
+	if err != nil {
+		t.Fatalf("newRateLimiter() err = %v, want nil", err)
+	}
+	now := time.Now()
+	// Takes enough tokens from the bucket of the busy key to keep it from
+	// being full for longer than the test.
+	busy := &kmsCall{keyID: "busy"}
+	for i := 0; i < 100000; i++ {
+		r.reserve(busy, now, time.Duration(math.MaxInt64))
+	}
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Second)
+		if _, _, ok := r.reserve(&kmsCall{keyID: fmt.Sprintf("key-%d", i)}, now, 0); !ok {
+			t.Fatalf("r.reserve() for a new key ok = false, want true")
+		}
+	}
+	if len(r.buckets) > minBucketSweep {
+		t.Errorf("len(r.buckets) = %d, want at most %d", len(r.buckets), minBucketSweep)
+	}
+	if _, ok := r.buckets[busy.keyID]; !ok {
+		t.Error("the bucket of the busy key was evicted")
+	}
+}
+
+func TestRateLimitFailsFastPastDeadline(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithRateLimit(RateLimit{RequestsPerSecond: 0.001})).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	encryptAll(t, context.Background(), a)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = a.EncryptWithContext(ctx, []byte("plaintext"), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("a.EncryptWithContext() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The request failed without waiting until the deadline.
+	if ctx.Err() != nil {
+		t.Errorf("ctx.Err() = %v, want nil", ctx.Err())
+	}
+}
+
+func TestRateLimitWaitStopsWhenCanceled(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	a, err := newTestClient(t, fakekms, WithRateLimit(RateLimit{RequestsPerSecond: 0.1})).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	encryptAll(t, context.Background(), a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := a.EncryptWithContext(ctx, []byte("plaintext"), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("a.EncryptWithContext() err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	slow := &slowKMS{KMSAPI: fakekms, delay: 20 * time.Millisecond}
+	a, err := newTestClient(t, slow, WithRateLimit(RateLimit{MaxInFlight: 2})).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.EncryptWithContext(context.Background(), []byte("plaintext"), nil); err != nil {
+				t.Errorf("a.EncryptWithContext() err = %v, want nil", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if slow.maxInFlight > 2 {
+		t.Errorf("maximum number of concurrent requests = %d, want at most 2", slow.maxInFlight)
+	}
+}
+
+func TestWithRateLimitFailsWithInvalidLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		limit RateLimit
+	}{
+		{"no limit", RateLimit{}},
+		{"negative rate", RateLimit{RequestsPerSecond: -1}},
+		{"negative burst", RateLimit{RequestsPerSecond: 1, Burst: -1}},
+		{"negative in-flight", RateLimit{MaxInFlight: -1}},
+		{"burst without rate", RateLimit{Burst: 1, MaxInFlight: 1}},
+		{"per key without rate", RateLimit{PerKey: true, MaxInFlight: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions("aws-kms://", WithRateLimit(tc.limit)); err == nil {
+				t.Error("NewClientWithOptions() err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestWithRateLimitTwiceFails(t *testing.T) {
+	limit := RateLimit{MaxInFlight: 1}
+	if _, err := NewClientWithOptions("aws-kms://", WithRateLimit(limit), WithRateLimit(limit)); err == nil {
+		t.Error("NewClientWithOptions() err = nil, want error")
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// ReEncrypt re-encrypts ciphertext, encrypted by an AEAD for sourceKeyURI with
+// sourceAssociatedData, with the key of destinationKeyURI, using the AWS KMS
+// ReEncrypt API. The plaintext never leaves AWS KMS.
+//
+// The returned ciphertext can be decrypted by an AEAD for destinationKeyURI
+// with destinationAssociatedData, which may differ from sourceAssociatedData
+// to move the ciphertext to a new context. The associated data of each side is
+// passed to AWS KMS in its encryption context with the encryption context name
+// of the client, along with the pairs set by [WithEncryptionContext], which are
+// the same for both sides.
+//
+// Both keys must be supported by the client and be in the same region. The
+// caller must be authorized to use the kms:ReEncryptFrom permission on the
+// source key and kms:ReEncryptTo on the destination key.
+func (c *Client) ReEncrypt(ctx context.Context, sourceKeyURI, destinationKeyURI string, ciphertext, sourceAssociatedData, destinationAssociatedData []byte, opts ...request.Option) ([]byte, error) {
+	r, err := c.newReEncrypter(sourceKeyURI, destinationKeyURI)
+	if err != nil {
+		return nil, err
+	}
+	return r.reEncrypt(ctx, ReEncryptItem{
+		Ciphertext:                ciphertext,
+		SourceAssociatedData:      sourceAssociatedData,
+		DestinationAssociatedData: destinationAssociatedData,
+	}, opts...)
+}
+
+// ReEncryptItem is an input of [Client.ReEncryptBatch] or
+// [Client.ReEncryptStream].
+type ReEncryptItem struct {
+	// Ciphertext is the ciphertext to re-encrypt.
+	Ciphertext []byte
+	// SourceAssociatedData is the associated data of Ciphertext.
+	SourceAssociatedData []byte
+	// DestinationAssociatedData is the associated data of the re-encrypted
+	// ciphertext.
+	DestinationAssociatedData []byte
+}
+
+// ReEncryptBatch re-encrypts the ciphertexts of items, as described in
+// [Client.ReEncrypt]. It makes up to concurrency requests at a time, or
+// [DefaultBatchConcurrency] if concurrency is not positive. The keys are
+// resolved once for the whole batch.
+//
+// It returns a result per item, in the order of items, with the re-encrypted
+// ciphertext of the item. When ctx is done, no more requests are made and the
+// items which were not re-encrypted yet fail with the error of ctx.
+func (c *Client) ReEncryptBatch(ctx context.Context, sourceKeyURI, destinationKeyURI string, items []ReEncryptItem, concurrency int, opts ...request.Option) ([]BatchResult, error) {
+	r, err := c.newReEncrypter(sourceKeyURI, destinationKeyURI)
+	if err != nil {
+		return nil, err
+	}
+	return runBatch(ctx, items, concurrency, func(item ReEncryptItem) ([]byte, error) {
+		return r.reEncrypt(ctx, item, opts...)
+	}), nil
+}
+
+// ReEncryptStream re-encrypts the ciphertexts received from items, as
+// described in [Client.ReEncrypt], until items is closed. It makes up to
+// concurrency requests at a time, or [DefaultBatchConcurrency] if concurrency
+// is not positive. The keys are resolved once for the whole stream.
+//
+// It sends a result per item to the returned channel, in the order the items
+// were received, and closes it after the last one. When ctx is done, no more
+// items are received and the channel is closed, possibly without the results
+// of the items in flight; the caller must cancel ctx if it stops reading the
+// results before the channel is closed.
+func (c *Client) ReEncryptStream(ctx context.Context, sourceKeyURI, destinationKeyURI string, items <-chan ReEncryptItem, concurrency int, opts ...request.Option) (<-chan BatchResult, error) {
+	r, err := c.newReEncrypter(sourceKeyURI, destinationKeyURI)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	// pending holds the result channel of each item in flight, in order.
+	pending := make(chan chan BatchResult, concurrency)
+	go func() {
+		defer close(pending)
+		for {
+			var item ReEncryptItem
+			select {
+			case <-ctx.Done():
+				return
+			case i, ok := <-items:
+				if !ok {
+					return
+				}
+				item = i
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case sem <- struct{}{}:
+			}
+			result := make(chan BatchResult, 1)
+			select {
+			case <-ctx.Done():
+				<-sem
+				return
+			case pending <- result:
+			}
+			go func() {
+				defer func() { <-sem }()
+				data, err := r.reEncrypt(ctx, item, opts...)
+				result <- BatchResult{Data: data, Err: err}
+			}()
+		}
+	}()
+	results := make(chan BatchResult)
+	go func() {
+		defer close(results)
+		for result := range pending {
+			r := <-result
+			select {
+			case <-ctx.Done():
+				return
+			case results <- r:
+			}
+		}
+	}()
+	return results, nil
+}
+
+// reEncrypter re-encrypts ciphertexts from a source key to a destination key.
+type reEncrypter struct {
+	sourceKeyID, destinationKeyID string
+	kms                           kmsiface.KMSAPI
+	encryptionContextName         EncryptionContextName
+	staticContext                 map[string]string
+	grantTokens                   []string
+}
+
+// newReEncrypter resolves the keys of sourceKeyURI and destinationKeyURI once,
+// for all the ciphertexts re-encrypted by the returned reEncrypter.
+func (c *Client) newReEncrypter(sourceKeyURI, destinationKeyURI string) (*reEncrypter, error) {
+	source, err := c.parseSupportedKeyURI(sourceKeyURI)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := c.parseSupportedKeyURI(destinationKeyURI)
+	if err != nil {
+		return nil, err
+	}
+	if c.regionOf(source) != c.regionOf(destination) {
+		return nil, fmt.Errorf("keys %s and %s must be in the same region", sourceKeyURI, destinationKeyURI)
+	}
+	sourceKeyID, _, err := c.resolveKey(source)
+	if err != nil {
+		return nil, err
+	}
+	destinationKeyID, k, err := c.resolveKey(destination)
+	if err != nil {
+		return nil, err
+	}
+	return &reEncrypter{
+		sourceKeyID:           sourceKeyID,
+		destinationKeyID:      destinationKeyID,
+		kms:                   k,
+		encryptionContextName: c.encryptionContextName,
+		staticContext:         c.staticContext,
+		grantTokens:           c.grantTokens,
+	}, nil
+}
+
+func (r *reEncrypter) reEncrypt(ctx context.Context, item ReEncryptItem, opts ...request.Option) ([]byte, error) {
+	resp, err := r.kms.ReEncryptWithContext(ctx, &kms.ReEncryptInput{
+		CiphertextBlob:               item.Ciphertext,
+		SourceKeyId:                  aws.String(r.sourceKeyID),
+		SourceEncryptionContext:      encryptionContext(r.staticContext, r.encryptionContextName, item.SourceAssociatedData),
+		DestinationKeyId:             aws.String(r.destinationKeyID),
+		DestinationEncryptionContext: encryptionContext(r.staticContext, r.encryptionContextName, item.DestinationAssociatedData),
+		GrantTokens:                  kmsGrantTokens(r.grantTokens),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
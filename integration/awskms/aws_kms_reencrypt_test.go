@@ -0,0 +1,344 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// reEncryptItems encrypts items with a and returns the ciphertexts to
+// re-encrypt with the same associated data.
+func reEncryptItems(t *testing.T, a *AWSAEAD, items []BatchItem) []ReEncryptItem {
+	t.Helper()
+	var ciphertexts []ReEncryptItem
+	for i, r := range a.EncryptBatch(context.Background(), items, 0) {
+		if r.Err != nil {
+			t.Fatalf("EncryptBatch()[%d].Err = %v, want nil", i, r.Err)
+		}
+		ciphertexts = append(ciphertexts, ReEncryptItem{
+			Ciphertext:                r.Data,
+			SourceAssociatedData:      items[i].AssociatedData,
+			DestinationAssociatedData: items[i].AssociatedData,
+		})
+	}
+	return ciphertexts
+}
+
+func TestReEncrypt(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var ops []string
+	record := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		ops = append(ops, call.Operation)
+		return invoke(ctx, call)
+	}
+	client := newTestClient(t, fakekms, WithInterceptors(record))
+	source, err := client.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	destination, err := client.GetAEADWithOptions(otherSymmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(otherSymmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associated data")
+	ciphertext, err := source.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("source.Encrypt() err = %v, want nil", err)
+	}
+
+	ops = nil
+	reEncrypted, err := client.ReEncrypt(context.Background(), "aws-kms://"+symmetricKeyARN, "aws-kms://"+otherSymmetricKeyARN, ciphertext, associatedData, associatedData)
+	if err != nil {
+		t.Fatalf("client.ReEncrypt() err = %v, want nil", err)
+	}
+	if len(ops) != 1 || ops[0] != "ReEncrypt" {
+		t.Errorf("client.ReEncrypt() made requests %q, want only ReEncrypt", ops)
+	}
+
+	decrypted, err := destination.Decrypt(reEncrypted, associatedData)
+	if err != nil {
+		t.Fatalf("destination.Decrypt() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("destination.Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+	if _, err := source.Decrypt(reEncrypted, associatedData); err == nil {
+		t.Error("source.Decrypt() err = nil, want error")
+	}
+}
+
+func TestReEncryptWithWrongAssociatedDataFails(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+	source, err := client.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertext, err := source.Encrypt([]byte("plaintext"), []byte("associated data"))
+	if err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	_, err = client.ReEncrypt(context.Background(), "aws-kms://"+symmetricKeyARN, "aws-kms://"+otherSymmetricKeyARN, ciphertext, []byte("wrong associated data"), []byte("associated data"))
+	if !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("client.ReEncrypt() err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+}
+
+func TestReEncryptFailsWithInvalidKeys(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+	otherRegionKeyARN := "arn:aws:kms:eu-west-1:235739564943:key/9b6c2f1e-0d4a-4c8e-b7f3-5a1d2e3c4b5f"
+	for _, tc := range []struct {
+		name                            string
+		sourceKeyURI, destinationKeyURI string
+	}{
+		{"unsupported source", "gcp-kms://key", "aws-kms://" + symmetricKeyARN},
+		{"unsupported destination", "aws-kms://" + symmetricKeyARN, "aws-kms://alias/other"},
+		{"different regions", "aws-kms://" + symmetricKeyARN, "aws-kms://" + otherRegionKeyARN},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.(*Client).ReEncrypt(context.Background(), tc.sourceKeyURI, tc.destinationKeyURI, []byte("ciphertext"), nil, nil)
+			if err == nil {
+				t.Error("client.ReEncrypt() err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestReEncryptBatch(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var (
+		mu  sync.Mutex
+		ops []string
+	)
+	record := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		mu.Lock()
+		ops = append(ops, call.Operation)
+		mu.Unlock()
+		return invoke(ctx, call)
+	}
+	client := newTestClient(t, fakekms, WithInterceptors(record))
+	source, err := client.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	destination, err := client.GetAEADWithOptions(otherSymmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(otherSymmetricKeyURI) err = %v, want nil", err)
+	}
+	items := batchItems(10)
+	ciphertexts := reEncryptItems(t, source, items)
+
+	ops = nil
+	results, err := client.ReEncryptBatch(context.Background(), "aws-kms://"+symmetricKeyARN, "aws-kms://"+otherSymmetricKeyARN, ciphertexts, 1)
+	if err != nil {
+		t.Fatalf("client.ReEncryptBatch() err = %v, want nil", err)
+	}
+	for _, op := range ops {
+		if op != "ReEncrypt" {
+			t.Errorf("client.ReEncryptBatch() made a %s request, want only ReEncrypt", op)
+		}
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("client.ReEncryptBatch()[%d].Err = %v, want nil", i, r.Err)
+		}
+		decrypted, err := destination.Decrypt(r.Data, items[i].AssociatedData)
+		if err != nil {
+			t.Fatalf("destination.Decrypt() err = %v, want nil", err)
+		}
+		if !bytes.Equal(decrypted, items[i].Data) {
+			t.Errorf("destination.Decrypt() = %q, want %q", decrypted, items[i].Data)
+		}
+	}
+}
+
+func TestReEncryptWithNewAssociatedData(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+	source, err := client.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	destination, err := client.GetAEADWithOptions(otherSymmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(otherSymmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	ciphertext, err := source.Encrypt(plaintext, []byte("old associated data"))
+	if err != nil {
+		t.Fatalf("source.Encrypt() err = %v, want nil", err)
+	}
+	reEncrypted, err := client.ReEncrypt(context.Background(), "aws-kms://"+symmetricKeyARN, "aws-kms://"+otherSymmetricKeyARN, ciphertext, []byte("old associated data"), []byte("new associated data"))
+	if err != nil {
+		t.Fatalf("client.ReEncrypt() err = %v, want nil", err)
+	}
+	decrypted, err := destination.Decrypt(reEncrypted, []byte("new associated data"))
+	if err != nil {
+		t.Fatalf("destination.Decrypt() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("destination.Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+	if _, err := destination.Decrypt(reEncrypted, []byte("old associated data")); err == nil {
+		t.Error("destination.Decrypt() with the old associated data err = nil, want error")
+	}
+}
+
+func TestReEncryptBatchResolvesAliasesOnce(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(
+		map[string]string{symmetricKeyARN: kms.KeySpecSymmetricDefault, otherSymmetricKeyARN: kms.KeySpecSymmetricDefault},
+		fakeawskms.WithAlias("alias/source", symmetricKeyARN),
+		fakeawskms.WithAlias("alias/destination", otherSymmetricKeyARN))
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	var (
+		mu           sync.Mutex
+		describeKeys int
+	)
+	record := func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		if call.Operation == "DescribeKey" {
+			mu.Lock()
+			describeKeys++
+			mu.Unlock()
+		}
+		return invoke(ctx, call)
+	}
+	c := newTestClient(t, fakekms, WithAliasResolution(), WithInterceptors(record))
+	items := batchItems(10)
+	source, err := c.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ciphertexts := reEncryptItems(t, source, items)
+
+	describeKeys = 0
+	results, err := c.ReEncryptBatch(context.Background(), "aws-kms://arn:aws:kms:us-east-2:235739564943:alias/source", "aws-kms://arn:aws:kms:us-east-2:235739564943:alias/destination", ciphertexts, 4)
+	if err != nil {
+		t.Fatalf("client.ReEncryptBatch() err = %v, want nil", err)
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("client.ReEncryptBatch()[%d].Err = %v, want nil", i, r.Err)
+		}
+	}
+	if describeKeys != 2 {
+		t.Errorf("client.ReEncryptBatch() made %d DescribeKey requests, want 2", describeKeys)
+	}
+}
+
+func TestReEncryptStream(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+	destination, err := client.GetAEADWithOptions(otherSymmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(otherSymmetricKeyURI) err = %v, want nil", err)
+	}
+	source, err := client.GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	items := batchItems(20)
+	ciphertexts := reEncryptItems(t, source, items)
+
+	in := make(chan ReEncryptItem)
+	go func() {
+		defer close(in)
+		for _, c := range ciphertexts {
+			in <- c
+		}
+	}()
+	out, err := client.ReEncryptStream(context.Background(), "aws-kms://"+symmetricKeyARN, "aws-kms://"+otherSymmetricKeyARN, in, 3)
+	if err != nil {
+		t.Fatalf("client.ReEncryptStream() err = %v, want nil", err)
+	}
+	i := 0
+	for r := range out {
+		if r.Err != nil {
+			t.Fatalf("client.ReEncryptStream() result %d Err = %v, want nil", i, r.Err)
+		}
+		decrypted, err := destination.Decrypt(r.Data, items[i].AssociatedData)
+		if err != nil {
+			t.Fatalf("destination.Decrypt() of result %d err = %v, want nil", i, err)
+		}
+		if !bytes.Equal(decrypted, items[i].Data) {
+			t.Errorf("destination.Decrypt() of result %d = %q, want %q", i, decrypted, items[i].Data)
+		}
+		i++
+	}
+	if i != len(items) {
+		t.Errorf("client.ReEncryptStream() sent %d results, want %d", i, len(items))
+	}
+}
+
+func TestReEncryptStreamStopsWhenContextIsDone(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan ReEncryptItem)
+	out, err := client.ReEncryptStream(ctx, "aws-kms://"+symmetricKeyARN, "aws-kms://"+otherSymmetricKeyARN, in, 0)
+	if err != nil {
+		t.Fatalf("client.ReEncryptStream() err = %v, want nil", err)
+	}
+	cancel()
+	for range out {
+	}
+}
+
+func TestReEncryptStreamFailsWithInvalidKeys(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN, otherSymmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+	if _, err := client.ReEncryptStream(context.Background(), "gcp-kms://key", "aws-kms://"+otherSymmetricKeyARN, make(chan ReEncryptItem), 0); err == nil {
+		t.Error("client.ReEncryptStream() err = nil, want error")
+	}
+}
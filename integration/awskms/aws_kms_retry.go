@@ -0,0 +1,202 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// throttlingException is the error code returned by AWS KMS when the request
+// rate of the caller exceeds the quota of the account.
+const throttlingException = "ThrottlingException"
+
+// defaultRetryableCodes are the error codes retried by a [RetryPolicy] without
+// RetryableCodes, see [DefaultRetryableCodes].
+var defaultRetryableCodes = []string{
+	throttlingException,
+	kms.ErrCodeInternalException,
+	kms.ErrCodeDependencyTimeoutException,
+	kms.ErrCodeKeyUnavailableException,
+	request.ErrCodeRequestError,
+	request.ErrCodeResponseTimeout,
+}
+
+// DefaultRetryableCodes returns the error codes retried by a [RetryPolicy]
+// without RetryableCodes. They are returned for throttled requests and
+// transient failures of AWS KMS or of the network.
+func DefaultRetryableCodes() []string {
+	return slices.Clone(defaultRetryableCodes)
+}
+
+// nonRetryableCodes are the error codes of failures which don't succeed when
+// repeated. They are never retried.
+var nonRetryableCodes = map[string]bool{
+	kms.ErrCodeInvalidCiphertextException:   true,
+	kms.ErrCodeIncorrectKeyException:        true,
+	kms.ErrCodeKMSInvalidMacException:       true,
+	kms.ErrCodeKMSInvalidSignatureException: true,
+	request.CanceledErrorCode:               true,
+}
+
+// RetryPolicy specifies how requests to AWS KMS which fail are retried, see
+// [WithRetryPolicy].
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a request, including
+	// the first one. It must be at least 1.
+	MaxAttempts int
+	// InitialBackoff is the maximum delay before the first retry. The maximum
+	// delay doubles after each retry, up to MaxBackoff. The actual delay is
+	// chosen uniformly at random below the maximum delay.
+	InitialBackoff time.Duration
+	// MaxBackoff is the largest maximum delay between two attempts. If zero,
+	// delays are not capped.
+	MaxBackoff time.Duration
+	// RetryableCodes are the error codes of failures which are retried. If
+	// empty, [DefaultRetryableCodes] are used. InvalidCiphertextException,
+	// IncorrectKeyException, KMSInvalidMacException and
+	// KMSInvalidSignatureException are never retried.
+	RetryableCodes []string
+}
+
+// DefaultRetryPolicy returns a RetryPolicy making up to 3 attempts with an
+// initial backoff of 100ms and a maximum backoff of 2s.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+}
+
+// retryPolicy is a validated RetryPolicy.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	retryableCodes map[string]bool
+}
+
+func newRetryPolicy(p RetryPolicy) (*retryPolicy, error) {
+	if p.MaxAttempts < 1 {
+		return nil, fmt.Errorf("MaxAttempts must be at least 1, got %d", p.MaxAttempts)
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return nil, errors.New("backoffs must not be negative")
+	}
+	if p.MaxBackoff != 0 && p.MaxBackoff < p.InitialBackoff {
+		return nil, fmt.Errorf("MaxBackoff %v must not be less than InitialBackoff %v", p.MaxBackoff, p.InitialBackoff)
+	}
+	codes := p.RetryableCodes
+	if len(codes) == 0 {
+		codes = defaultRetryableCodes
+	}
+	retryableCodes := make(map[string]bool)
+	for _, code := range codes {
+		if nonRetryableCodes[code] {
+			return nil, fmt.Errorf("error code %s cannot be retried", code)
+		}
+		retryableCodes[code] = true
+	}
+	return &retryPolicy{
+		maxAttempts:    p.MaxAttempts,
+		initialBackoff: p.InitialBackoff,
+		maxBackoff:     p.MaxBackoff,
+		retryableCodes: retryableCodes,
+	}, nil
+}
+
+// WithRetryPolicy makes the client retry requests to AWS KMS which fail with
+// one of the retryable error codes of policy, waiting with exponential backoff
+// and jitter between attempts.
+//
+// Retries are made in addition to the ones made by the AWS SDK, if any. They
+// stop when the context of the request is done. Retries apply per replica of
+// multi-region keys, before failing over to the next replica.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return option(func(a *Client) error {
+		if a.retryPolicy != nil {
+			return errors.New("WithRetryPolicy option cannot be used, retry policy already set")
+		}
+		p, err := newRetryPolicy(policy)
+		if err != nil {
+			return fmt.Errorf("invalid retry policy: %v", err)
+		}
+		a.retryPolicy = p
+		return nil
+	})
+}
+
+// retryable returns whether a request which failed with err can be retried.
+func (p *retryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	return p.retryableCodes[aerr.Code()] && !nonRetryableCodes[aerr.Code()]
+}
+
+// backoff returns the delay before the retry following attempt, which starts
+// at 1.
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	limit := p.maxBackoff
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+	d := p.initialBackoff
+	for i := 1; i < attempt && d < limit; i++ {
+		if d > limit/2 {
+			d = limit
+			break
+		}
+		d *= 2
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// middleware retries requests which fail with a retryable error, see
+// [WithRetryPolicy].
+func (p *retryPolicy) middleware(ctx context.Context, _ *kmsCall, next kmsHandler) error {
+	for attempt := 1; ; attempt++ {
+		err := next(ctx)
+		if err == nil || attempt >= p.maxAttempts || !p.retryable(ctx, err) {
+			return err
+		}
+		t := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// flakyKMS fails the first failures Encrypt and Decrypt requests with an
+// error with code.
+type flakyKMS struct {
+	kmsiface.KMSAPI
+	code     string
+	failures int
+	requests int
+}
+
+func (f *flakyKMS) fail() error {
+	f.requests++
+	if f.requests > f.failures {
+		return nil
+	}
+	return awserr.New(f.code, "injected failure", nil)
+}
+
+func (f *flakyKMS) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.KMSAPI.Encrypt(input)
+}
+
+func (f *flakyKMS) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.KMSAPI.EncryptWithContext(ctx, input, opts...)
+}
+
+func (f *flakyKMS) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.KMSAPI.DecryptWithContext(ctx, input, opts...)
+}
+
+func TestWithRetryPolicyRetriesRetryableErrors(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	for _, code := range DefaultRetryableCodes() {
+		t.Run(code, func(t *testing.T) {
+			fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+			if err != nil {
+				t.Fatalf("fakeawskms.New() failed: %v", err)
+			}
+			flaky := &flakyKMS{KMSAPI: fakekms, code: code, failures: 2}
+			a, err := newTestClient(t, flaky, WithRetryPolicy(policy)).GetAEADWithOptions(symmetricKeyURI)
+			if err != nil {
+				t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+			}
+			if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+				t.Fatalf("a.Encrypt() err = %v, want nil", err)
+			}
+			if flaky.requests != 3 {
+				t.Errorf("flaky.requests = %d, want 3", flaky.requests)
+			}
+		})
+	}
+}
+
+func TestWithRetryPolicyStopsAfterMaxAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	flaky := &flakyKMS{KMSAPI: fakekms, code: throttlingException, failures: 5}
+	a, err := newTestClient(t, flaky, WithRetryPolicy(policy)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	_, err = a.EncryptWithContext(context.Background(), []byte("plaintext"), nil)
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != throttlingException {
+		t.Errorf("a.EncryptWithContext() err = %v, want %s", err, throttlingException)
+	}
+	if flaky.requests != 2 {
+		t.Errorf("flaky.requests = %d, want 2", flaky.requests)
+	}
+}
+
+func TestWithRetryPolicyDoesNotRetryOtherErrors(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableCodes: []string{throttlingException}}
+	for _, code := range []string{kms.ErrCodeInvalidCiphertextException, kms.ErrCodeIncorrectKeyException, kms.ErrCodeInternalException, "AccessDeniedException"} {
+		t.Run(code, func(t *testing.T) {
+			fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+			if err != nil {
+				t.Fatalf("fakeawskms.New() failed: %v", err)
+			}
+			flaky := &flakyKMS{KMSAPI: fakekms, code: code, failures: 1}
+			a, err := newTestClient(t, flaky, WithRetryPolicy(policy)).GetAEADWithOptions(symmetricKeyURI)
+			if err != nil {
+				t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+			}
+			if _, err := a.DecryptWithContext(context.Background(), []byte("ciphertext"), nil); err == nil {
+				t.Fatal("a.DecryptWithContext() err = nil, want error")
+			}
+			if flaky.requests != 1 {
+				t.Errorf("flaky.requests = %d, want 1", flaky.requests)
+			}
+		})
+	}
+}
+
+func TestWithRetryPolicyStopsWhenContextIsDone(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	flaky := &flakyKMS{KMSAPI: fakekms, code: throttlingException, failures: 2}
+	a, err := newTestClient(t, flaky, WithRetryPolicy(policy)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := a.EncryptWithContext(ctx, []byte("plaintext"), nil); err == nil {
+		t.Fatal("a.EncryptWithContext() err = nil, want error")
+	}
+	if flaky.requests > 2 {
+		t.Errorf("flaky.requests = %d, want at most 2", flaky.requests)
+	}
+}
+
+func TestWithRetryPolicyWithInvalidPolicyFails(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy RetryPolicy
+	}{
+		{"no attempts", RetryPolicy{}},
+		{"negative backoff", RetryPolicy{MaxAttempts: 2, InitialBackoff: -time.Second}},
+		{"max backoff below initial backoff", RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: time.Millisecond}},
+		{"InvalidCiphertextException", RetryPolicy{MaxAttempts: 2, RetryableCodes: []string{kms.ErrCodeInvalidCiphertextException}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions("aws-kms://", WithRetryPolicy(tc.policy)); err == nil {
+				t.Error("NewClientWithOptions(_, WithRetryPolicy(_)) err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoffWithoutMaxBackoffDoesNotOverflow(t *testing.T) {
+	p, err := newRetryPolicy(RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Second})
+	if err != nil {
+		t.Fatalf("newRetryPolicy() err = %v, want nil", err)
+	}
+	// 2^40 seconds overflow a time.Duration. Backoffs are random, so the
+	// largest of many is checked to not have dropped to zero.
+	for _, attempt := range []int{40, 63, 64, 99} {
+		var largest time.Duration
+		for i := 0; i < 100; i++ {
+			d := p.backoff(attempt)
+			if d < 0 {
+				t.Fatalf("p.backoff(%d) = %v, want not negative", attempt, d)
+			}
+			largest = max(largest, d)
+		}
+		if largest < time.Hour {
+			t.Errorf("largest p.backoff(%d) = %v, want at least %v", attempt, largest, time.Hour)
+		}
+	}
+}
+
+func TestDefaultRetryableCodesReturnsACopy(t *testing.T) {
+	codes := DefaultRetryableCodes()
+	codes[0] = "Modified"
+	if DefaultRetryableCodes()[0] == "Modified" {
+		t.Error("DefaultRetryableCodes() returned a slice shared with the defaults")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p, err := newRetryPolicy(RetryPolicy{MaxAttempts: 10, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newRetryPolicy() err = %v, want nil", err)
+	}
+	for _, tc := range []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond},
+		{9, 50 * time.Millisecond},
+	} {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(tc.attempt); d < 0 || d >= tc.max {
+				t.Fatalf("p.backoff(%d) = %v, want in [0, %v)", tc.attempt, d, tc.max)
+			}
+		}
+	}
+}
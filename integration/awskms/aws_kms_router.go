@@ -0,0 +1,168 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/tink-crypto/tink-go/v2/core/registry"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+// Route maps the key URIs matching a pattern to a client, see [NewRouter].
+type Route struct {
+	// Pattern is a key URI prefix in which "*" matches any part of an ARN
+	// component, that is any characters but ":" and "/". For example,
+	// "aws-kms://arn:aws:kms:*:111122223333:" matches the keys of an account in
+	// all regions, and "aws-kms://arn:aws:kms:eu-*:" the keys of all accounts
+	// in the European regions.
+	Pattern string
+	// Options are the options of the client of the route, applied after the
+	// options shared by all routes. They typically set the credentials of the
+	// account, for example with [WithAssumeRole] or [WithProfile].
+	Options []ClientOption
+}
+
+// route is a validated Route and its client.
+type route struct {
+	pattern string
+	// match matches the key URIs of the route, without the scheme.
+	match *regexp.Regexp
+	// uriPrefix is the URI prefix of the client of the route, the part of the
+	// pattern before the first wildcard.
+	uriPrefix string
+	opts      []ClientOption
+
+	mu     sync.Mutex
+	client *Client
+}
+
+func newRoute(r Route, shared []ClientOption) (*route, error) {
+	pattern, ok := trimScheme(r.Pattern)
+	if !ok {
+		return nil, &KeyURIError{URI: r.Pattern, Err: ErrInvalidScheme}
+	}
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	opts := make([]ClientOption, 0, len(shared)+len(r.Options))
+	opts = append(opts, shared...)
+	opts = append(opts, r.Options...)
+	return &route{
+		pattern:   r.Pattern,
+		match:     regexp.MustCompile("^" + strings.Join(parts, "[^:/]*")),
+		uriPrefix: awsPrefix + strings.SplitN(pattern, "*", 2)[0],
+		opts:      opts,
+	}, nil
+}
+
+// matches returns whether keyURI matches the pattern of r.
+func (r *route) matches(keyURI string) bool {
+	uri, ok := trimScheme(keyURI)
+	return ok && r.match.MatchString(uri)
+}
+
+// getClient returns the client of r, creating it on first use. Clients which
+// can't be created are not cached, so that creating them is tried again.
+func (r *route) getClient() (*Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client != nil {
+		return r.client, nil
+	}
+	client, err := NewClientWithOptions(r.uriPrefix, r.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating the client of route %s: %v", r.pattern, err)
+	}
+	r.client = client.(*Client)
+	return r.client, nil
+}
+
+// Router is a [registry.KMSClient] which routes key URIs to clients, for
+// example to use different credentials for the keys of different AWS accounts.
+//
+// Each route has its own [Client], which is created with the options of the
+// route when it's first used and then reused.
+type Router struct {
+	routes []*route
+}
+
+var _ registry.KMSClient = (*Router)(nil)
+
+// NewRouter returns a Router for routes. Key URIs are routed to the first route
+// whose pattern they match; key URIs which match no route are not supported.
+//
+// opts are the options shared by the clients of all routes, such as
+// [WithRetryPolicy]. They are applied separately to the client of each route,
+// so state such as rate limits isn't shared between routes.
+func NewRouter(routes []Route, opts ...ClientOption) (*Router, error) {
+	if len(routes) == 0 {
+		return nil, errors.New("at least one route is required")
+	}
+	r := &Router{}
+	for _, rt := range routes {
+		route, err := newRoute(rt, opts)
+		if err != nil {
+			return nil, err
+		}
+		r.routes = append(r.routes, route)
+	}
+	return r, nil
+}
+
+// route returns the route of keyURI.
+func (r *Router) route(keyURI string) (*route, error) {
+	for _, route := range r.routes {
+		if route.matches(keyURI) {
+			return route, nil
+		}
+	}
+	return nil, fmt.Errorf("keyURI %s matches no route", keyURI)
+}
+
+// Supported returns true if keyURI matches the pattern of a route. Like
+// [Client.Supported], it doesn't validate keyURI otherwise.
+func (r *Router) Supported(keyURI string) bool {
+	_, err := r.route(keyURI)
+	return err == nil
+}
+
+// Client returns the client of the route of keyURI, creating it if needed. Use
+// it for the primitives of [Client] beyond AEAD, such as [Client.GetSigner].
+func (r *Router) Client(keyURI string) (*Client, error) {
+	route, err := r.route(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	return route.getClient()
+}
+
+// GetAEAD returns an AEAD for keyURI from the client of its route, see
+// [Client.GetAEAD].
+func (r *Router) GetAEAD(keyURI string) (tink.AEAD, error) {
+	c, err := r.Client(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetAEAD(keyURI)
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+const (
+	accountAKeyARN = "arn:aws:kms:us-east-2:111111111111:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	accountBKeyARN = "arn:aws:kms:eu-west-1:222222222222:key/9b6c2f1e-0d4a-4c8e-b7f3-5a1d2e3c4b5f"
+)
+
+// countClients returns an option which counts the clients it is applied to.
+func countClients(n *int) ClientOption {
+	return option(func(*Client) error {
+		*n++
+		return nil
+	})
+}
+
+func TestRouterRoutesKeyURIsToTheClientsOfTheirRoute(t *testing.T) {
+	kmsA, err := fakeawskms.New([]string{accountAKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	kmsB, err := fakeawskms.New([]string{accountBKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	var clientsA, clientsB int
+	var ops []string
+	router, err := NewRouter([]Route{
+		{Pattern: "aws-kms://arn:aws:kms:*:111111111111:", Options: []ClientOption{WithKMS(kmsA), countClients(&clientsA)}},
+		{Pattern: "aws-kms://arn:aws:kms:*:222222222222:", Options: []ClientOption{WithKMS(kmsB), countClients(&clientsB)}},
+	}, WithInterceptors(func(ctx context.Context, call *KMSCall, invoke KMSInvoker) error {
+		ops = append(ops, call.Operation+" "+call.Region)
+		return invoke(ctx, call)
+	}))
+	if err != nil {
+		t.Fatalf("NewRouter() err = %v, want nil", err)
+	}
+	if clientsA != 0 || clientsB != 0 {
+		t.Errorf("clients created by NewRouter() = %d, %d, want 0, 0", clientsA, clientsB)
+	}
+
+	for _, keyARN := range []string{accountAKeyARN, accountBKeyARN, accountAKeyARN} {
+		a, err := router.GetAEAD("aws-kms://" + keyARN)
+		if err != nil {
+			t.Fatalf("router.GetAEAD(%q) err = %v, want nil", keyARN, err)
+		}
+		ciphertext, err := a.Encrypt([]byte("plaintext"), []byte("associated data"))
+		if err != nil {
+			t.Fatalf("a.Encrypt() err = %v, want nil", err)
+		}
+		plaintext, err := a.Decrypt(ciphertext, []byte("associated data"))
+		if err != nil {
+			t.Fatalf("a.Decrypt() err = %v, want nil", err)
+		}
+		if !bytes.Equal(plaintext, []byte("plaintext")) {
+			t.Errorf("a.Decrypt() = %q, want %q", plaintext, "plaintext")
+		}
+	}
+	if clientsA != 1 || clientsB != 1 {
+		t.Errorf("clients created = %d, %d, want 1, 1", clientsA, clientsB)
+	}
+	if len(ops) != 6 || ops[2] != "Encrypt eu-west-1" {
+		t.Errorf("intercepted operations = %q, want the shared interceptor in both routes", ops)
+	}
+
+	c, err := router.Client("aws-kms://" + accountBKeyARN)
+	if err != nil {
+		t.Fatalf("router.Client() err = %v, want nil", err)
+	}
+	if c.keyURIPrefix != "aws-kms://arn:aws:kms:" {
+		t.Errorf("c.keyURIPrefix = %q, want %q", c.keyURIPrefix, "aws-kms://arn:aws:kms:")
+	}
+}
+
+func TestRouterSupported(t *testing.T) {
+	router, err := NewRouter([]Route{
+		{Pattern: "aws-kms://arn:aws:kms:eu-*:"},
+		{Pattern: "AWS-KMS://arn:aws:kms:us-east-2:111111111111:"},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter() err = %v, want nil", err)
+	}
+	for _, tc := range []struct {
+		keyURI string
+		want   bool
+	}{
+		{"aws-kms://" + accountAKeyARN, true},
+		{"aws-kms://" + accountBKeyARN, true},
+		{"aws-kms://arn:aws:kms:us-east-2:222222222222:key/3ee50705-5a82-4f5b-9753-05c4f473922f", false},
+		{"aws-kms://arn:aws:kms:us-west-2:111111111111:key/3ee50705-5a82-4f5b-9753-05c4f473922f", false},
+		{"aws-kms://arn:aws:kms:us-east-2:111111111111:key/not-a-key-id", true},
+		{"gcp-kms://projects/p/locations/l/keyRings/r/cryptoKeys/k", false},
+	} {
+		if got := router.Supported(tc.keyURI); got != tc.want {
+			t.Errorf("router.Supported(%q) = %v, want %v", tc.keyURI, got, tc.want)
+		}
+	}
+	if _, err := router.GetAEAD("aws-kms://arn:aws:kms:us-west-2:111111111111:key/3ee50705-5a82-4f5b-9753-05c4f473922f"); err == nil {
+		t.Error("router.GetAEAD() with unrouted key URI err = nil, want error")
+	}
+	_, err = router.GetAEAD("aws-kms://arn:aws:kms:us-east-2:111111111111:key/not-a-key-id")
+	var uriErr *KeyURIError
+	if !errors.As(err, &uriErr) {
+		t.Errorf("router.GetAEAD() with invalid key URI err = %v, want a *KeyURIError", err)
+	}
+}
+
+func TestRouterRoutesToTheFirstMatchingRoute(t *testing.T) {
+	var first, second int
+	router, err := NewRouter([]Route{
+		{Pattern: "aws-kms://arn:aws:kms:us-east-2:", Options: []ClientOption{countClients(&first)}},
+		{Pattern: "aws-kms://", Options: []ClientOption{countClients(&second)}},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter() err = %v, want nil", err)
+	}
+	if _, err := router.Client("aws-kms://" + accountAKeyARN); err != nil {
+		t.Fatalf("router.Client() err = %v, want nil", err)
+	}
+	if _, err := router.Client("aws-kms://" + accountBKeyARN); err != nil {
+		t.Fatalf("router.Client() err = %v, want nil", err)
+	}
+	if first != 1 || second != 1 {
+		t.Errorf("clients created = %d, %d, want 1, 1", first, second)
+	}
+}
+
+func TestRouterDoesNotCacheClientErrors(t *testing.T) {
+	var attempts int
+	router, err := NewRouter([]Route{{Pattern: "aws-kms://", Options: []ClientOption{countClients(&attempts), WithProfile("")}}})
+	if err != nil {
+		t.Fatalf("NewRouter() err = %v, want nil", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := router.GetAEAD("aws-kms://" + accountAKeyARN); err == nil {
+			t.Error("router.GetAEAD() err = nil, want error")
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("client creation attempts = %d, want 2", attempts)
+	}
+}
+
+func TestNewRouterFails(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		routes []Route
+	}{
+		{"no routes", nil},
+		{"invalid scheme", []Route{{Pattern: "aws-kms://arn:aws:kms:*:111111111111:"}, {Pattern: "gcp-kms://"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewRouter(tc.routes); err == nil {
+				t.Error("NewRouter() err = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	// Register the hash functions used by the KMS signing algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+// maxRawMessageSize is the largest message AWS KMS accepts with the RAW
+// message type.
+const maxRawMessageSize = 4096
+
+// signingHashes maps the KMS signing algorithms to the hash function applied to
+// the message. SM2DSA is absent as it requires the message to be sent to KMS
+// as is.
+var signingHashes = map[string]crypto.Hash{
+	kms.SigningAlgorithmSpecRsassaPssSha256:      crypto.SHA256,
+	kms.SigningAlgorithmSpecRsassaPssSha384:      crypto.SHA384,
+	kms.SigningAlgorithmSpecRsassaPssSha512:      crypto.SHA512,
+	kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256: crypto.SHA256,
+	kms.SigningAlgorithmSpecRsassaPkcs1V15Sha384: crypto.SHA384,
+	kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512: crypto.SHA512,
+	kms.SigningAlgorithmSpecEcdsaSha256:          crypto.SHA256,
+	kms.SigningAlgorithmSpecEcdsaSha384:          crypto.SHA384,
+	kms.SigningAlgorithmSpecEcdsaSha512:          crypto.SHA512,
+}
+
+func validateSigningAlgorithm(signingAlgorithm string) error {
+	for _, a := range kms.SigningAlgorithmSpec_Values() {
+		if a == signingAlgorithm {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported signing algorithm %q", signingAlgorithm)
+}
+
+// message returns the Message and MessageType fields of Sign and Verify
+// requests for data.
+//
+// Except for SM2DSA, data is hashed locally and sent as a digest. This keeps
+// data in the process and removes the 4096 bytes limit of RAW messages, while
+// producing the same signatures.
+func message(signingAlgorithm string, data []byte) ([]byte, *string, error) {
+	h, ok := signingHashes[signingAlgorithm]
+	if !ok {
+		if len(data) > maxRawMessageSize {
+			return nil, nil, fmt.Errorf("%s messages must be at most %d bytes, got %d", signingAlgorithm, maxRawMessageSize, len(data))
+		}
+		return data, aws.String(kms.MessageTypeRaw), nil
+	}
+	digest := h.New()
+	digest.Write(data)
+	return digest.Sum(nil), aws.String(kms.MessageTypeDigest), nil
+}
+
+// AWSSigner is an implementation of the Signer interface which signs data
+// remotely via the AWS KMS Sign API using an asymmetric key.
+type AWSSigner struct {
+	keyURI           string
+	kms              kmsiface.KMSAPI
+	signingAlgorithm string
+}
+
+// Sign computes a signature for data.
+func (s *AWSSigner) Sign(data []byte) ([]byte, error) {
+	return s.SignWithContext(context.Background(), data)
+}
+
+// SignWithContext computes a signature for data.
+//
+// ctx is used for the KMS request, and opts are applied to it.
+func (s *AWSSigner) SignWithContext(ctx context.Context, data []byte, opts ...request.Option) ([]byte, error) {
+	msg, msgType, err := message(s.signingAlgorithm, data)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.kms.SignWithContext(ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyURI),
+		Message:          msg,
+		MessageType:      msgType,
+		SigningAlgorithm: aws.String(s.signingAlgorithm),
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// AWSVerifier is an implementation of the Verifier interface which verifies
+// signatures remotely via the AWS KMS Verify API.
+type AWSVerifier struct {
+	keyURI           string
+	kms              kmsiface.KMSAPI
+	signingAlgorithm string
+}
+
+// Verify verifies whether signature is a valid signature for data.
+func (v *AWSVerifier) Verify(signature, data []byte) error {
+	return v.VerifyWithContext(context.Background(), signature, data)
+}
+
+// VerifyWithContext verifies whether signature is a valid signature for data.
+//
+// ctx is used for the KMS request, and opts are applied to it.
+func (v *AWSVerifier) VerifyWithContext(ctx context.Context, signature, data []byte, opts ...request.Option) error {
+	msg, msgType, err := message(v.signingAlgorithm, data)
+	if err != nil {
+		return err
+	}
+	resp, err := v.kms.VerifyWithContext(ctx, &kms.VerifyInput{
+		KeyId:            aws.String(v.keyURI),
+		Message:          msg,
+		MessageType:      msgType,
+		Signature:        signature,
+		SigningAlgorithm: aws.String(v.signingAlgorithm),
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	if !aws.BoolValue(resp.SignatureValid) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// localVerifier verifies signatures using the public key of an asymmetric KMS
+// key, without calling KMS.
+type localVerifier struct {
+	publicKey        crypto.PublicKey
+	signingAlgorithm string
+}
+
+func newLocalVerifier(resp *kms.GetPublicKeyOutput, signingAlgorithm string) (*localVerifier, error) {
+	if _, ok := signingHashes[signingAlgorithm]; !ok {
+		return nil, fmt.Errorf("signing algorithm %q cannot be verified locally", signingAlgorithm)
+	}
+	if len(resp.SigningAlgorithms) > 0 {
+		supported := false
+		for _, a := range resp.SigningAlgorithms {
+			if aws.StringValue(a) == signingAlgorithm {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return nil, fmt.Errorf("signing algorithm %q is not supported by key %s", signingAlgorithm, aws.StringValue(resp.KeyId))
+		}
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key: %v", err)
+	}
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(signingAlgorithm, "RSASSA_") {
+			return nil, fmt.Errorf("signing algorithm %q cannot be used with an RSA key", signingAlgorithm)
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(signingAlgorithm, "ECDSA_") {
+			return nil, fmt.Errorf("signing algorithm %q cannot be used with an ECC key", signingAlgorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return &localVerifier{
+		publicKey:        publicKey,
+		signingAlgorithm: signingAlgorithm,
+	}, nil
+}
+
+// Verify verifies whether signature is a valid signature for data.
+func (v *localVerifier) Verify(signature, data []byte) error {
+	h := signingHashes[v.signingAlgorithm]
+	digest := h.New()
+	digest.Write(data)
+	hashed := digest.Sum(nil)
+
+	switch v.signingAlgorithm {
+	case kms.SigningAlgorithmSpecRsassaPssSha256, kms.SigningAlgorithmSpecRsassaPssSha384, kms.SigningAlgorithmSpecRsassaPssSha512:
+		// AWS KMS uses a salt of the same length as the digest.
+		return rsa.VerifyPSS(v.publicKey.(*rsa.PublicKey), h, hashed, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha384, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512:
+		return rsa.VerifyPKCS1v15(v.publicKey.(*rsa.PublicKey), h, hashed, signature)
+	default:
+		if !ecdsa.VerifyASN1(v.publicKey.(*ecdsa.PublicKey), hashed, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+}
+
+// GetSigner returns an implementation of the Signer interface which signs data
+// remotely via the AWS KMS Sign API using the asymmetric key keyURI.
+//
+// signingAlgorithm must be one of the KMS SigningAlgorithmSpec values, such as
+// kms.SigningAlgorithmSpecEcdsaSha256, and must be supported by the key.
+//
+// keyURI must be supported by this client and must have the same format as
+// for [Client.GetAEAD]. The returned Signer is an [*AWSSigner].
+func (c *Client) GetSigner(keyURI, signingAlgorithm string) (tink.Signer, error) {
+	uri, k, err := c.signingKeyID(keyURI, signingAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &AWSSigner{
+		keyURI:           uri,
+		kms:              k,
+		signingAlgorithm: signingAlgorithm,
+	}, nil
+}
+
+// GetVerifier returns an implementation of the Verifier interface which
+// verifies signatures remotely via the AWS KMS Verify API using the asymmetric
+// key keyURI.
+//
+// signingAlgorithm and keyURI are as for [Client.GetSigner]. The returned
+// Verifier is an [*AWSVerifier].
+func (c *Client) GetVerifier(keyURI, signingAlgorithm string) (tink.Verifier, error) {
+	uri, k, err := c.signingKeyID(keyURI, signingAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &AWSVerifier{
+		keyURI:           uri,
+		kms:              k,
+		signingAlgorithm: signingAlgorithm,
+	}, nil
+}
+
+// GetLocalVerifier returns an implementation of the Verifier interface which
+// verifies signatures locally, using the public key of the asymmetric key
+// keyURI.
+//
+// The public key is fetched once via the AWS KMS GetPublicKey API. Verifying
+// signatures does not call AWS KMS afterwards.
+//
+// signingAlgorithm and keyURI are as for [Client.GetSigner], except that
+// kms.SigningAlgorithmSpecSm2dsa is not supported.
+func (c *Client) GetLocalVerifier(keyURI, signingAlgorithm string) (tink.Verifier, error) {
+	uri, k, err := c.signingKeyID(keyURI, signingAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.GetPublicKeyWithContext(context.Background(), &kms.GetPublicKeyInput{
+		KeyId: aws.String(uri),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newLocalVerifier(resp, signingAlgorithm)
+}
+
+// signingKeyID validates keyURI and signingAlgorithm and returns the KMS key ID
+// to use in requests and the KMS client of its region.
+func (c *Client) signingKeyID(keyURI, signingAlgorithm string) (string, kmsiface.KMSAPI, error) {
+	if err := validateSigningAlgorithm(signingAlgorithm); err != nil {
+		return "", nil, err
+	}
+	return c.keyFor(keyURI)
+}
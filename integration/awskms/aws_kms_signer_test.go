@@ -0,0 +1,149 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+const (
+	rsaKeyARN = "arn:aws:kms:us-east-2:235739564943:key/7a0e3dbd-3b5e-4e3e-8c1f-2b8a6d1c0f5a"
+	rsaKeyURI = "aws-kms://arn:aws:kms:us-east-2:235739564943:key/7a0e3dbd-3b5e-4e3e-8c1f-2b8a6d1c0f5a"
+	eccKeyARN = "arn:aws:kms:us-east-2:235739564943:key/0d6c3f4a-7b1e-4f0a-9a52-1e3f5c7b9d2e"
+	eccKeyURI = "aws-kms://arn:aws:kms:us-east-2:235739564943:key/0d6c3f4a-7b1e-4f0a-9a52-1e3f5c7b9d2e"
+)
+
+func TestGetSignerSignVerify(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{
+		rsaKeyARN: kms.KeySpecRsa2048,
+		eccKeyARN: kms.KeySpecEccNistP256,
+	})
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+
+	for _, tc := range []struct {
+		keyURI           string
+		signingAlgorithm string
+	}{
+		{rsaKeyURI, kms.SigningAlgorithmSpecRsassaPssSha256},
+		{rsaKeyURI, kms.SigningAlgorithmSpecRsassaPssSha384},
+		{rsaKeyURI, kms.SigningAlgorithmSpecRsassaPssSha512},
+		{rsaKeyURI, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256},
+		{rsaKeyURI, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha384},
+		{rsaKeyURI, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512},
+		{eccKeyURI, kms.SigningAlgorithmSpecEcdsaSha256},
+	} {
+		t.Run(tc.signingAlgorithm, func(t *testing.T) {
+			signer, err := client.GetSigner(tc.keyURI, tc.signingAlgorithm)
+			if err != nil {
+				t.Fatalf("client.GetSigner() err = %v, want nil", err)
+			}
+			verifier, err := client.GetVerifier(tc.keyURI, tc.signingAlgorithm)
+			if err != nil {
+				t.Fatalf("client.GetVerifier() err = %v, want nil", err)
+			}
+			localVerifier, err := client.GetLocalVerifier(tc.keyURI, tc.signingAlgorithm)
+			if err != nil {
+				t.Fatalf("client.GetLocalVerifier() err = %v, want nil", err)
+			}
+
+			// Longer than the limit of RAW messages, as data is sent as a digest.
+			data := bytes.Repeat([]byte("data"), 2000)
+			signature, err := signer.Sign(data)
+			if err != nil {
+				t.Fatalf("signer.Sign(data) err = %v, want nil", err)
+			}
+			if err := verifier.Verify(signature, data); err != nil {
+				t.Errorf("verifier.Verify(signature, data) err = %v, want nil", err)
+			}
+			if err := localVerifier.Verify(signature, data); err != nil {
+				t.Errorf("localVerifier.Verify(signature, data) err = %v, want nil", err)
+			}
+
+			invalidData := []byte("invalidData")
+			if err := verifier.Verify(signature, invalidData); err == nil {
+				t.Error("verifier.Verify(signature, invalidData) err = nil, want error")
+			}
+			if err := localVerifier.Verify(signature, invalidData); err == nil {
+				t.Error("localVerifier.Verify(signature, invalidData) err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestGetSignerWithInvalidSigningAlgorithmFails(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{
+		rsaKeyARN: kms.KeySpecRsa2048,
+		eccKeyARN: kms.KeySpecEccNistP256,
+	})
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+
+	if _, err := client.GetSigner(eccKeyURI, "ECDSA_SHA_1"); err == nil {
+		t.Error("client.GetSigner(eccKeyURI, \"ECDSA_SHA_1\") err = nil, want error")
+	}
+	if _, err := client.GetVerifier(eccKeyURI, "ECDSA_SHA_1"); err == nil {
+		t.Error("client.GetVerifier(eccKeyURI, \"ECDSA_SHA_1\") err = nil, want error")
+	}
+}
+
+func TestGetSignerWithUnsupportedKeyURIFails(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{eccKeyARN: kms.KeySpecEccNistP256})
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client, err := NewClientWithOptions("aws-kms://arn:aws:kms:us-west-2:", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() failed: %v", err)
+	}
+
+	if _, err := client.(*Client).GetSigner(eccKeyURI, kms.SigningAlgorithmSpecEcdsaSha256); err == nil {
+		t.Error("client.GetSigner(eccKeyURI, _) err = nil, want error")
+	}
+}
+
+func TestGetLocalVerifierWithUnsupportedSigningAlgorithmFails(t *testing.T) {
+	fakekms, err := fakeawskms.NewWithKeySpecs(map[string]string{
+		rsaKeyARN: kms.KeySpecRsa2048,
+		eccKeyARN: kms.KeySpecEccNistP256,
+	})
+	if err != nil {
+		t.Fatalf("fakeawskms.NewWithKeySpecs() failed: %v", err)
+	}
+	client := newTestClient(t, fakekms)
+
+	for _, tc := range []struct {
+		keyURI           string
+		signingAlgorithm string
+	}{
+		{eccKeyURI, kms.SigningAlgorithmSpecSm2dsa},
+		{eccKeyURI, kms.SigningAlgorithmSpecEcdsaSha384},
+		{eccKeyURI, kms.SigningAlgorithmSpecRsassaPssSha256},
+	} {
+		if _, err := client.GetLocalVerifier(tc.keyURI, tc.signingAlgorithm); err == nil {
+			t.Errorf("client.GetLocalVerifier(%q, %q) err = nil, want error", tc.keyURI, tc.signingAlgorithm)
+		}
+	}
+}
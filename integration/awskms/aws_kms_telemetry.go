@@ -0,0 +1,182 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer and meter of the client.
+const instrumentationName = "github.com/tink-crypto/tink-go-awskms/v2/integration/awskms"
+
+// Names of the metrics recorded by clients with [WithTelemetry].
+const (
+	// MetricRequestDuration is a histogram of the duration in seconds of AWS
+	// KMS requests, including retries.
+	MetricRequestDuration = "aws.kms.client.request.duration"
+	// MetricRequests counts AWS KMS requests.
+	MetricRequests = "aws.kms.client.requests"
+	// MetricPayloadSize is a histogram of the size in bytes of the data
+	// processed by AWS KMS requests, such as plaintexts for Encrypt requests
+	// and ciphertexts for Decrypt requests.
+	MetricPayloadSize = "aws.kms.client.payload.size"
+)
+
+// Attributes of the spans and metrics recorded by clients with
+// [WithTelemetry].
+const (
+	// AttributeKeyID is the key ARN, or other key identifier, of the request.
+	// It is only set on spans.
+	AttributeKeyID = attribute.Key("aws.kms.key_id")
+	// AttributeRegion is the region of the KMS client sending the request.
+	AttributeRegion = attribute.Key("cloud.region")
+	// AttributeOperation is the name of the AWS KMS API, such as "Encrypt".
+	AttributeOperation = attribute.Key("rpc.method")
+	// AttributeOutcome is "success" or "error".
+	AttributeOutcome = attribute.Key("aws.kms.outcome")
+	// AttributeErrorClass is the AWS error code of failed requests, such as
+	// "ThrottlingException", "canceled" or "deadline_exceeded" if the context
+	// of the request was done, or "_OTHER".
+	AttributeErrorClass = attribute.Key("error.type")
+)
+
+// telemetry records spans and metrics for AWS KMS requests, see
+// [WithTelemetry].
+type telemetry struct {
+	tracer      trace.Tracer
+	duration    metric.Float64Histogram
+	requests    metric.Int64Counter
+	payloadSize metric.Int64Histogram
+}
+
+func newTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*telemetry, error) {
+	meter := meterProvider.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram(MetricRequestDuration,
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of AWS KMS requests."))
+	if err != nil {
+		return nil, err
+	}
+	requests, err := meter.Int64Counter(MetricRequests,
+		metric.WithUnit("{request}"),
+		metric.WithDescription("Number of AWS KMS requests."))
+	if err != nil {
+		return nil, err
+	}
+	payloadSize, err := meter.Int64Histogram(MetricPayloadSize,
+		metric.WithUnit("By"),
+		metric.WithDescription("Size of the data processed by AWS KMS requests."))
+	if err != nil {
+		return nil, err
+	}
+	return &telemetry{
+		tracer:      tracerProvider.Tracer(instrumentationName),
+		duration:    duration,
+		requests:    requests,
+		payloadSize: payloadSize,
+	}, nil
+}
+
+// WithTelemetry makes the client record an OpenTelemetry span and metrics for
+// each request to AWS KMS, with tracerProvider and meterProvider. If either is
+// nil, the global provider is used.
+//
+// Spans are named after the AWS KMS API, such as "KMS.Encrypt", and have the
+// attributes above. Their duration includes the retries made with
+// [WithRetryPolicy]. The metrics are described above. Neither spans nor metrics
+// contain plaintexts, ciphertexts or associated data; only their sizes are
+// recorded.
+func WithTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) ClientOption {
+	return option(func(a *Client) error {
+		if a.telemetry != nil {
+			return errors.New("WithTelemetry option cannot be used, telemetry already set")
+		}
+		if tracerProvider == nil {
+			tracerProvider = otel.GetTracerProvider()
+		}
+		if meterProvider == nil {
+			meterProvider = otel.GetMeterProvider()
+		}
+		t, err := newTelemetry(tracerProvider, meterProvider)
+		if err != nil {
+			return fmt.Errorf("failed creating instruments: %v", err)
+		}
+		a.telemetry = t
+		return nil
+	})
+}
+
+// middleware records a span and metrics for each request, see
+// [WithTelemetry].
+func (t *telemetry) middleware(ctx context.Context, call *kmsCall, next kmsHandler) error {
+	attrs := []attribute.KeyValue{
+		AttributeOperation.String(call.op),
+		AttributeRegion.String(call.region),
+	}
+	ctx, span := t.tracer.Start(ctx, "KMS."+call.op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+		trace.WithAttributes(AttributeKeyID.String(call.keyID)))
+	defer span.End()
+
+	start := time.Now()
+	err := next(ctx)
+	elapsed := time.Since(start)
+
+	outcome := []attribute.KeyValue{AttributeOutcome.String("success")}
+	if err != nil {
+		class := errorClass(err)
+		outcome = []attribute.KeyValue{AttributeOutcome.String("error"), AttributeErrorClass.String(class)}
+		// The error message is not recorded, as it may contain details of
+		// the request.
+		span.SetStatus(codes.Error, class)
+	}
+	span.SetAttributes(outcome...)
+
+	set := metric.WithAttributes(append(attrs, outcome...)...)
+	t.duration.Record(ctx, elapsed.Seconds(), set)
+	t.requests.Add(ctx, 1, set)
+	if call.payloadSize >= 0 {
+		t.payloadSize.Record(ctx, int64(call.payloadSize), set)
+	}
+	return err
+}
+
+// errorClass returns the value of [AttributeErrorClass] for err.
+func errorClass(err error) string {
+	var aerr awserr.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.As(err, &aerr):
+		return aerr.Code()
+	default:
+		return "_OTHER"
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTelemetryRecordsSpans(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	a, err := newTestClient(t, fakekms, WithTelemetry(tracerProvider, nil)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("secret plaintext")
+	associatedData := []byte("secret associated data")
+	ciphertext, err := a.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if _, err := a.DecryptWithContext(context.Background(), ciphertext, associatedData); err != nil {
+		t.Fatalf("a.DecryptWithContext() err = %v, want nil", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("len(recorder.Ended()) = %d, want 2", len(spans))
+	}
+	for i, op := range []string{"Encrypt", "Decrypt"} {
+		span := spans[i]
+		if got, want := span.Name(), "KMS."+op; got != want {
+			t.Errorf("span.Name() = %q, want %q", got, want)
+		}
+		want := map[attribute.Key]string{
+			AttributeKeyID:     symmetricKeyARN,
+			AttributeRegion:    "us-east-2",
+			AttributeOperation: op,
+			AttributeOutcome:   "success",
+		}
+		got := make(map[attribute.Key]string)
+		for _, kv := range span.Attributes() {
+			got[kv.Key] = kv.Value.Emit()
+			for _, secret := range [][]byte{plaintext, associatedData} {
+				if strings.Contains(kv.Value.Emit(), string(secret)) || strings.Contains(kv.Value.Emit(), hex.EncodeToString(secret)) {
+					t.Errorf("span %s attribute %s = %q contains secret data", span.Name(), kv.Key, kv.Value.Emit())
+				}
+			}
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("span %s attribute %s = %q, want %q", span.Name(), k, got[k], v)
+			}
+		}
+		if _, ok := got[AttributeErrorClass]; ok {
+			t.Errorf("span %s has attribute %s, want none", span.Name(), AttributeErrorClass)
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("span %s status = %v, want not error", span.Name(), span.Status())
+		}
+	}
+}
+
+func TestTelemetryRecordsErrors(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	a, err := newTestClient(t, fakekms, WithTelemetry(tracerProvider, nil)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	if _, err := a.Decrypt([]byte("invalid ciphertext"), nil); err == nil {
+		t.Fatal("a.Decrypt() err = nil, want error")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("len(recorder.Ended()) = %d, want 1", len(spans))
+	}
+	span := spans[0]
+	if got, want := span.Status().Code, codes.Error; got != want {
+		t.Errorf("span.Status().Code = %v, want %v", got, want)
+	}
+	got := make(map[attribute.Key]string)
+	for _, kv := range span.Attributes() {
+		got[kv.Key] = kv.Value.Emit()
+	}
+	if got[AttributeOutcome] != "error" {
+		t.Errorf("span attribute %s = %q, want %q", AttributeOutcome, got[AttributeOutcome], "error")
+	}
+	if got[AttributeErrorClass] != "InvalidCiphertextException" {
+		t.Errorf("span attribute %s = %q, want %q", AttributeErrorClass, got[AttributeErrorClass], "InvalidCiphertextException")
+	}
+}
+
+func TestTelemetryRecordsMetrics(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	a, err := newTestClient(t, fakekms, WithTelemetry(nil, meterProvider)).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	for i := 0; i < 3; i++ {
+		if _, err := a.Encrypt(plaintext, nil); err != nil {
+			t.Fatalf("a.Encrypt() err = %v, want nil", err)
+		}
+	}
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("reader.Collect() err = %v, want nil", err)
+	}
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	wantAttrs := attribute.NewSet(
+		AttributeOperation.String("Encrypt"),
+		AttributeRegion.String("us-east-2"),
+		AttributeOutcome.String("success"))
+
+	requests, ok := metrics[MetricRequests].(metricdata.Sum[int64])
+	if !ok || len(requests.DataPoints) != 1 {
+		t.Fatalf("metric %s = %v, want one data point", MetricRequests, metrics[MetricRequests])
+	}
+	if dp := requests.DataPoints[0]; dp.Value != 3 || !dp.Attributes.Equals(&wantAttrs) {
+		t.Errorf("metric %s = %d with %v, want 3 with %v", MetricRequests, dp.Value, dp.Attributes.Encoded(attribute.DefaultEncoder()), wantAttrs.Encoded(attribute.DefaultEncoder()))
+	}
+
+	duration, ok := metrics[MetricRequestDuration].(metricdata.Histogram[float64])
+	if !ok || len(duration.DataPoints) != 1 || duration.DataPoints[0].Count != 3 {
+		t.Errorf("metric %s = %v, want one data point with count 3", MetricRequestDuration, metrics[MetricRequestDuration])
+	}
+
+	size, ok := metrics[MetricPayloadSize].(metricdata.Histogram[int64])
+	if !ok || len(size.DataPoints) != 1 {
+		t.Fatalf("metric %s = %v, want one data point", MetricPayloadSize, metrics[MetricPayloadSize])
+	}
+	if dp := size.DataPoints[0]; dp.Count != 3 || dp.Sum != int64(3*len(plaintext)) {
+		t.Errorf("metric %s count = %d, sum = %d, want 3, %d", MetricPayloadSize, dp.Count, dp.Sum, 3*len(plaintext))
+	}
+}
+
+func TestWithTelemetryTwiceFails(t *testing.T) {
+	_, err := NewClientWithOptions("aws-kms://", WithTelemetry(nil, nil), WithTelemetry(nil, nil))
+	if err == nil {
+		t.Error("NewClientWithOptions() err = nil, want error")
+	}
+}
@@ -0,0 +1,487 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	kmsv2 "github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/smithy-go"
+)
+
+// kmsV2API is the subset of the AWS SDK for Go v2 KMS client used by this
+// package.
+type kmsV2API interface {
+	Encrypt(ctx context.Context, params *kmsv2.EncryptInput, optFns ...func(*kmsv2.Options)) (*kmsv2.EncryptOutput, error)
+	Decrypt(ctx context.Context, params *kmsv2.DecryptInput, optFns ...func(*kmsv2.Options)) (*kmsv2.DecryptOutput, error)
+	ReEncrypt(ctx context.Context, params *kmsv2.ReEncryptInput, optFns ...func(*kmsv2.Options)) (*kmsv2.ReEncryptOutput, error)
+	Sign(ctx context.Context, params *kmsv2.SignInput, optFns ...func(*kmsv2.Options)) (*kmsv2.SignOutput, error)
+	Verify(ctx context.Context, params *kmsv2.VerifyInput, optFns ...func(*kmsv2.Options)) (*kmsv2.VerifyOutput, error)
+	GetPublicKey(ctx context.Context, params *kmsv2.GetPublicKeyInput, optFns ...func(*kmsv2.Options)) (*kmsv2.GetPublicKeyOutput, error)
+	GenerateMac(ctx context.Context, params *kmsv2.GenerateMacInput, optFns ...func(*kmsv2.Options)) (*kmsv2.GenerateMacOutput, error)
+	VerifyMac(ctx context.Context, params *kmsv2.VerifyMacInput, optFns ...func(*kmsv2.Options)) (*kmsv2.VerifyMacOutput, error)
+	GenerateDataKey(ctx context.Context, params *kmsv2.GenerateDataKeyInput, optFns ...func(*kmsv2.Options)) (*kmsv2.GenerateDataKeyOutput, error)
+	GenerateDataKeyWithoutPlaintext(ctx context.Context, params *kmsv2.GenerateDataKeyWithoutPlaintextInput, optFns ...func(*kmsv2.Options)) (*kmsv2.GenerateDataKeyWithoutPlaintextOutput, error)
+	CreateGrant(ctx context.Context, params *kmsv2.CreateGrantInput, optFns ...func(*kmsv2.Options)) (*kmsv2.CreateGrantOutput, error)
+	DescribeKey(ctx context.Context, params *kmsv2.DescribeKeyInput, optFns ...func(*kmsv2.Options)) (*kmsv2.DescribeKeyOutput, error)
+	ListAliases(ctx context.Context, params *kmsv2.ListAliasesInput, optFns ...func(*kmsv2.Options)) (*kmsv2.ListAliasesOutput, error)
+}
+
+// WithKMSV2 sets the underlying AWS KMS client to client, a preexisting AWS
+// SDK for Go v2 KMS client instance.
+//
+// Ciphertexts produced by primitives of this client are interchangeable with
+// those produced by a client configured with [WithKMS].
+//
+// It's the callers responsibility to ensure that the configured region of
+// client aligns with the region in key URIs passed to this client. Otherwise,
+// API requests will fail.
+//
+// Request options of the v1 SDK, such as request.WithResponseReadTimeout,
+// cannot be applied to client. Use the deadline of the context instead.
+func WithKMSV2(client *kmsv2.Client) ClientOption {
+	return option(func(a *Client) error {
+		if client == nil {
+			return errors.New("WithKMSV2 option cannot be used with a nil client")
+		}
+		if a.kms != nil {
+			return errors.New("WithKMSV2 option cannot be used, KMS client already set")
+		}
+		if a.hasSessionOptions() {
+			return errors.New("WithKMSV2 option cannot be used, options of created KMS clients already set")
+		}
+		a.kms = newKMSV2Adapter(client)
+		return nil
+	})
+}
+
+// kmsV2Adapter exposes an AWS SDK for Go v2 KMS client as a kmsiface.KMSAPI.
+//
+// Only the operations used by this package are implemented. The others are
+// left to the embedded kmsiface.KMSAPI, which is nil, so calling them panics.
+// Request options are specific to the v1 SDK and cannot be applied to v2
+// requests, so the implemented operations fail if any are given. Errors
+// returned by the v2 client which carry an AWS API error code are converted to
+// awserr.Error, so that callers can handle them as they would v1 errors.
+type kmsV2Adapter struct {
+	kmsiface.KMSAPI
+	client kmsV2API
+}
+
+func newKMSV2Adapter(client kmsV2API) *kmsV2Adapter {
+	return &kmsV2Adapter{client: client}
+}
+
+func (k *kmsV2Adapter) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	return k.EncryptWithContext(aws.BackgroundContext(), input)
+}
+
+// EncryptWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	if err := checkKMSV2RequestOptions("Encrypt", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.Encrypt(ctx, &kmsv2.EncryptInput{
+		KeyId:             input.KeyId,
+		Plaintext:         input.Plaintext,
+		EncryptionContext: encryptionContextV2(input.EncryptionContext),
+		GrantTokens:       grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.EncryptOutput{
+		CiphertextBlob: resp.CiphertextBlob,
+		KeyId:          resp.KeyId,
+	}, nil
+}
+
+func (k *kmsV2Adapter) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	return k.DecryptWithContext(aws.BackgroundContext(), input)
+}
+
+// DecryptWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	if err := checkKMSV2RequestOptions("Decrypt", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.Decrypt(ctx, &kmsv2.DecryptInput{
+		KeyId:             input.KeyId,
+		CiphertextBlob:    input.CiphertextBlob,
+		EncryptionContext: encryptionContextV2(input.EncryptionContext),
+		GrantTokens:       grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.DecryptOutput{
+		Plaintext: resp.Plaintext,
+		KeyId:     resp.KeyId,
+	}, nil
+}
+
+// ReEncryptWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) ReEncryptWithContext(ctx aws.Context, input *kms.ReEncryptInput, opts ...request.Option) (*kms.ReEncryptOutput, error) {
+	if err := checkKMSV2RequestOptions("ReEncrypt", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.ReEncrypt(ctx, &kmsv2.ReEncryptInput{
+		CiphertextBlob:               input.CiphertextBlob,
+		SourceKeyId:                  input.SourceKeyId,
+		SourceEncryptionContext:      encryptionContextV2(input.SourceEncryptionContext),
+		DestinationKeyId:             input.DestinationKeyId,
+		DestinationEncryptionContext: encryptionContextV2(input.DestinationEncryptionContext),
+		GrantTokens:                  grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.ReEncryptOutput{
+		CiphertextBlob: resp.CiphertextBlob,
+		KeyId:          resp.KeyId,
+		SourceKeyId:    resp.SourceKeyId,
+	}, nil
+}
+
+// SignWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) SignWithContext(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error) {
+	if err := checkKMSV2RequestOptions("Sign", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.Sign(ctx, &kmsv2.SignInput{
+		KeyId:            input.KeyId,
+		Message:          input.Message,
+		MessageType:      kmstypes.MessageType(aws.StringValue(input.MessageType)),
+		SigningAlgorithm: kmstypes.SigningAlgorithmSpec(aws.StringValue(input.SigningAlgorithm)),
+		GrantTokens:      grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.SignOutput{
+		KeyId:            resp.KeyId,
+		Signature:        resp.Signature,
+		SigningAlgorithm: aws.String(string(resp.SigningAlgorithm)),
+	}, nil
+}
+
+// VerifyWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) VerifyWithContext(ctx aws.Context, input *kms.VerifyInput, opts ...request.Option) (*kms.VerifyOutput, error) {
+	if err := checkKMSV2RequestOptions("Verify", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.Verify(ctx, &kmsv2.VerifyInput{
+		KeyId:            input.KeyId,
+		Message:          input.Message,
+		MessageType:      kmstypes.MessageType(aws.StringValue(input.MessageType)),
+		Signature:        input.Signature,
+		SigningAlgorithm: kmstypes.SigningAlgorithmSpec(aws.StringValue(input.SigningAlgorithm)),
+		GrantTokens:      grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.VerifyOutput{
+		KeyId:            resp.KeyId,
+		SignatureValid:   aws.Bool(resp.SignatureValid),
+		SigningAlgorithm: aws.String(string(resp.SigningAlgorithm)),
+	}, nil
+}
+
+// GetPublicKeyWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) GetPublicKeyWithContext(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+	if err := checkKMSV2RequestOptions("GetPublicKey", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.GetPublicKey(ctx, &kmsv2.GetPublicKeyInput{
+		KeyId:       input.KeyId,
+		GrantTokens: grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	signingAlgorithms := make([]*string, 0, len(resp.SigningAlgorithms))
+	for _, a := range resp.SigningAlgorithms {
+		signingAlgorithms = append(signingAlgorithms, aws.String(string(a)))
+	}
+	return &kms.GetPublicKeyOutput{
+		KeyId:             resp.KeyId,
+		KeySpec:           aws.String(string(resp.KeySpec)),
+		KeyUsage:          aws.String(string(resp.KeyUsage)),
+		PublicKey:         resp.PublicKey,
+		SigningAlgorithms: signingAlgorithms,
+	}, nil
+}
+
+// GenerateMacWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) GenerateMacWithContext(ctx aws.Context, input *kms.GenerateMacInput, opts ...request.Option) (*kms.GenerateMacOutput, error) {
+	if err := checkKMSV2RequestOptions("GenerateMac", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.GenerateMac(ctx, &kmsv2.GenerateMacInput{
+		KeyId:        input.KeyId,
+		MacAlgorithm: kmstypes.MacAlgorithmSpec(aws.StringValue(input.MacAlgorithm)),
+		Message:      input.Message,
+		GrantTokens:  grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.GenerateMacOutput{
+		KeyId:        resp.KeyId,
+		Mac:          resp.Mac,
+		MacAlgorithm: aws.String(string(resp.MacAlgorithm)),
+	}, nil
+}
+
+// VerifyMacWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) VerifyMacWithContext(ctx aws.Context, input *kms.VerifyMacInput, opts ...request.Option) (*kms.VerifyMacOutput, error) {
+	if err := checkKMSV2RequestOptions("VerifyMac", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.VerifyMac(ctx, &kmsv2.VerifyMacInput{
+		KeyId:        input.KeyId,
+		Mac:          input.Mac,
+		MacAlgorithm: kmstypes.MacAlgorithmSpec(aws.StringValue(input.MacAlgorithm)),
+		Message:      input.Message,
+		GrantTokens:  grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.VerifyMacOutput{
+		KeyId:        resp.KeyId,
+		MacAlgorithm: aws.String(string(resp.MacAlgorithm)),
+		MacValid:     aws.Bool(resp.MacValid),
+	}, nil
+}
+
+// GenerateDataKeyWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, opts ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	if err := checkKMSV2RequestOptions("GenerateDataKey", opts); err != nil {
+		return nil, err
+	}
+	numberOfBytes, err := int32V2("NumberOfBytes", input.NumberOfBytes)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.client.GenerateDataKey(ctx, &kmsv2.GenerateDataKeyInput{
+		KeyId:             input.KeyId,
+		KeySpec:           kmstypes.DataKeySpec(aws.StringValue(input.KeySpec)),
+		NumberOfBytes:     numberOfBytes,
+		EncryptionContext: encryptionContextV2(input.EncryptionContext),
+		GrantTokens:       grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.GenerateDataKeyOutput{
+		CiphertextBlob: resp.CiphertextBlob,
+		KeyId:          resp.KeyId,
+		Plaintext:      resp.Plaintext,
+	}, nil
+}
+
+// GenerateDataKeyWithoutPlaintextWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) GenerateDataKeyWithoutPlaintextWithContext(ctx aws.Context, input *kms.GenerateDataKeyWithoutPlaintextInput, opts ...request.Option) (*kms.GenerateDataKeyWithoutPlaintextOutput, error) {
+	if err := checkKMSV2RequestOptions("GenerateDataKeyWithoutPlaintext", opts); err != nil {
+		return nil, err
+	}
+	numberOfBytes, err := int32V2("NumberOfBytes", input.NumberOfBytes)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.client.GenerateDataKeyWithoutPlaintext(ctx, &kmsv2.GenerateDataKeyWithoutPlaintextInput{
+		KeyId:             input.KeyId,
+		KeySpec:           kmstypes.DataKeySpec(aws.StringValue(input.KeySpec)),
+		NumberOfBytes:     numberOfBytes,
+		EncryptionContext: encryptionContextV2(input.EncryptionContext),
+		GrantTokens:       grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.GenerateDataKeyWithoutPlaintextOutput{
+		CiphertextBlob: resp.CiphertextBlob,
+		KeyId:          resp.KeyId,
+	}, nil
+}
+
+// CreateGrantWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) CreateGrantWithContext(ctx aws.Context, input *kms.CreateGrantInput, opts ...request.Option) (*kms.CreateGrantOutput, error) {
+	if err := checkKMSV2RequestOptions("CreateGrant", opts); err != nil {
+		return nil, err
+	}
+	operations := make([]kmstypes.GrantOperation, len(input.Operations))
+	for i, op := range input.Operations {
+		operations[i] = kmstypes.GrantOperation(aws.StringValue(op))
+	}
+	var constraints *kmstypes.GrantConstraints
+	if input.Constraints != nil {
+		constraints = &kmstypes.GrantConstraints{
+			EncryptionContextEquals: encryptionContextV2(input.Constraints.EncryptionContextEquals),
+			EncryptionContextSubset: encryptionContextV2(input.Constraints.EncryptionContextSubset),
+		}
+	}
+	resp, err := k.client.CreateGrant(ctx, &kmsv2.CreateGrantInput{
+		KeyId:             input.KeyId,
+		GranteePrincipal:  input.GranteePrincipal,
+		RetiringPrincipal: input.RetiringPrincipal,
+		Operations:        operations,
+		Constraints:       constraints,
+		Name:              input.Name,
+		GrantTokens:       grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	return &kms.CreateGrantOutput{
+		GrantId:    resp.GrantId,
+		GrantToken: resp.GrantToken,
+	}, nil
+}
+
+// DescribeKeyWithContext implements kmsiface.KMSAPI.
+// Only the key metadata fields used by this package are converted.
+func (k *kmsV2Adapter) DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+	if err := checkKMSV2RequestOptions("DescribeKey", opts); err != nil {
+		return nil, err
+	}
+	resp, err := k.client.DescribeKey(ctx, &kmsv2.DescribeKeyInput{
+		KeyId:       input.KeyId,
+		GrantTokens: grantTokensV2(input.GrantTokens),
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	if resp.KeyMetadata == nil {
+		return &kms.DescribeKeyOutput{}, nil
+	}
+	md := resp.KeyMetadata
+	macAlgorithms := make([]*string, 0, len(md.MacAlgorithms))
+	for _, a := range md.MacAlgorithms {
+		macAlgorithms = append(macAlgorithms, aws.String(string(a)))
+	}
+	signingAlgorithms := make([]*string, 0, len(md.SigningAlgorithms))
+	for _, a := range md.SigningAlgorithms {
+		signingAlgorithms = append(signingAlgorithms, aws.String(string(a)))
+	}
+	return &kms.DescribeKeyOutput{
+		KeyMetadata: &kms.KeyMetadata{
+			AWSAccountId:      md.AWSAccountId,
+			Arn:               md.Arn,
+			Enabled:           aws.Bool(md.Enabled),
+			KeyId:             md.KeyId,
+			KeySpec:           aws.String(string(md.KeySpec)),
+			KeyState:          aws.String(string(md.KeyState)),
+			KeyUsage:          aws.String(string(md.KeyUsage)),
+			MacAlgorithms:     macAlgorithms,
+			MultiRegion:       md.MultiRegion,
+			SigningAlgorithms: signingAlgorithms,
+		},
+	}, nil
+}
+
+// ListAliasesWithContext implements kmsiface.KMSAPI.
+func (k *kmsV2Adapter) ListAliasesWithContext(ctx aws.Context, input *kms.ListAliasesInput, opts ...request.Option) (*kms.ListAliasesOutput, error) {
+	if err := checkKMSV2RequestOptions("ListAliases", opts); err != nil {
+		return nil, err
+	}
+	limit, err := int32V2("Limit", input.Limit)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.client.ListAliases(ctx, &kmsv2.ListAliasesInput{
+		KeyId:  input.KeyId,
+		Limit:  limit,
+		Marker: input.Marker,
+	})
+	if err != nil {
+		return nil, convertV2Error(err)
+	}
+	aliases := make([]*kms.AliasListEntry, 0, len(resp.Aliases))
+	for _, a := range resp.Aliases {
+		aliases = append(aliases, &kms.AliasListEntry{
+			AliasArn:    a.AliasArn,
+			AliasName:   a.AliasName,
+			TargetKeyId: a.TargetKeyId,
+		})
+	}
+	return &kms.ListAliasesOutput{
+		Aliases:    aliases,
+		NextMarker: resp.NextMarker,
+		Truncated:  aws.Bool(resp.Truncated),
+	}, nil
+}
+
+// checkKMSV2RequestOptions fails if opts, the v1 request options of an op
+// request, are not empty, since they cannot be applied to v2 requests.
+func checkKMSV2RequestOptions(op string, opts []request.Option) error {
+	if len(opts) > 0 {
+		return fmt.Errorf("AWS KMS %s: request options are not supported with WithKMSV2", op)
+	}
+	return nil
+}
+
+// encryptionContextV2 converts a v1 EncryptionContext to its v2 equivalent.
+func encryptionContextV2(ec map[string]*string) map[string]string {
+	if len(ec) == 0 {
+		return nil
+	}
+	return aws.StringValueMap(ec)
+}
+
+// grantTokensV2 converts v1 GrantTokens to their v2 equivalent.
+func grantTokensV2(tokens []*string) []string {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return aws.StringValueSlice(tokens)
+}
+
+// int32V2 converts n, the v1 value of the name parameter, to its v2
+// equivalent. It fails if n does not fit in an int32, rather than sending a
+// different value.
+func int32V2(name string, n *int64) (*int32, error) {
+	if n == nil {
+		return nil, nil
+	}
+	if *n < math.MinInt32 || *n > math.MaxInt32 {
+		return nil, fmt.Errorf("AWS KMS %s %d is out of range", name, *n)
+	}
+	v := int32(*n)
+	return &v, nil
+}
+
+// convertV2Error converts errors carrying an AWS API error code to
+// awserr.Error. The original error is kept as the origin error.
+func convertV2Error(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return awserr.New(apiErr.ErrorCode(), apiErr.ErrorMessage(), err)
+	}
+	return err
+}
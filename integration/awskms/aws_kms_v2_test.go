@@ -0,0 +1,350 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	kmsv2 "github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/smithy-go"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/internal/fakeawskms"
+)
+
+// fakeKMSV2 implements kmsV2API on top of a kmsiface.KMSAPI.
+type fakeKMSV2 struct {
+	kmsV2API
+	kms kmsiface.KMSAPI
+	err error
+}
+
+func (f *fakeKMSV2) Encrypt(_ context.Context, params *kmsv2.EncryptInput, _ ...func(*kmsv2.Options)) (*kmsv2.EncryptOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp, err := f.kms.Encrypt(&kms.EncryptInput{
+		KeyId:             params.KeyId,
+		Plaintext:         params.Plaintext,
+		EncryptionContext: aws.StringMap(params.EncryptionContext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kmsv2.EncryptOutput{CiphertextBlob: resp.CiphertextBlob, KeyId: resp.KeyId}, nil
+}
+
+func (f *fakeKMSV2) Decrypt(_ context.Context, params *kmsv2.DecryptInput, _ ...func(*kmsv2.Options)) (*kmsv2.DecryptOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp, err := f.kms.Decrypt(&kms.DecryptInput{
+		KeyId:             params.KeyId,
+		CiphertextBlob:    params.CiphertextBlob,
+		EncryptionContext: aws.StringMap(params.EncryptionContext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kmsv2.DecryptOutput{Plaintext: resp.Plaintext, KeyId: resp.KeyId}, nil
+}
+
+func TestWithKMSV2_NilClientFails(t *testing.T) {
+	if _, err := NewClientWithOptions("aws-kms://", WithKMSV2(nil)); err == nil {
+		t.Fatal("NewClientWithOptions(_, WithKMSV2(nil)) err = nil, want error")
+	}
+}
+
+func TestWithKMSV2_RepeatedWithKMSFails(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-2:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	fakekms, err := fakeawskms.New([]string{keyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+
+	_, err = NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithKMSV2(&kmsv2.Client{}))
+	if err == nil {
+		t.Fatal("NewClientWithOptions(_, WithKMS(_), WithKMSV2(_)) err = nil, want error")
+	}
+}
+
+func TestKMSV2Adapter_CiphertextsAreCompatible(t *testing.T) {
+	keyARN := "arn:aws:kms:us-east-2:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	keyURI := "aws-kms://arn:aws:kms:us-east-2:235739564943:key/3ee50705-5a82-4f5b-9753-05c4f473922f"
+	fakekms, err := fakeawskms.New([]string{keyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+
+	for _, name := range []EncryptionContextName{AssociatedData, LegacyAdditionalData} {
+		t.Run(name.String(), func(t *testing.T) {
+			v1Client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms), WithEncryptionContextName(name))
+			if err != nil {
+				t.Fatalf("NewClientWithOptions() failed: %v", err)
+			}
+			v2Client, err := NewClientWithOptions("aws-kms://", WithKMS(newKMSV2Adapter(&fakeKMSV2{kms: fakekms})), WithEncryptionContextName(name))
+			if err != nil {
+				t.Fatalf("NewClientWithOptions() failed: %v", err)
+			}
+			v1AEAD, err := v1Client.GetAEAD(keyURI)
+			if err != nil {
+				t.Fatalf("v1Client.GetAEAD(keyURI) err = %v, want nil", err)
+			}
+			v2AEAD, err := v2Client.GetAEAD(keyURI)
+			if err != nil {
+				t.Fatalf("v2Client.GetAEAD(keyURI) err = %v, want nil", err)
+			}
+
+			plaintext := []byte("plaintext")
+			associatedData := []byte("associatedData")
+			ciphertext, err := v2AEAD.Encrypt(plaintext, associatedData)
+			if err != nil {
+				t.Fatalf("v2AEAD.Encrypt(plaintext, associatedData) err = %v, want nil", err)
+			}
+			decrypted, err := v1AEAD.Decrypt(ciphertext, associatedData)
+			if err != nil {
+				t.Fatalf("v1AEAD.Decrypt(ciphertext, associatedData) err = %v, want nil", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+			}
+
+			ciphertext, err = v1AEAD.Encrypt(plaintext, associatedData)
+			if err != nil {
+				t.Fatalf("v1AEAD.Encrypt(plaintext, associatedData) err = %v, want nil", err)
+			}
+			decrypted, err = v2AEAD.Decrypt(ciphertext, associatedData)
+			if err != nil {
+				t.Fatalf("v2AEAD.Decrypt(ciphertext, associatedData) err = %v, want nil", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+			}
+
+			if _, err := v2AEAD.Decrypt(ciphertext, []byte("invalidAssociatedData")); err == nil {
+				t.Error("v2AEAD.Decrypt(ciphertext, []byte(\"invalidAssociatedData\")) err = nil, want error")
+			}
+		})
+	}
+}
+
+func TestKMSV2Adapter_ConvertsAPIErrors(t *testing.T) {
+	fake := &fakeKMSV2{err: &smithy.GenericAPIError{Code: kms.ErrCodeInvalidCiphertextException, Message: "bad ciphertext"}}
+	adapter := newKMSV2Adapter(fake)
+
+	_, err := adapter.Decrypt(&kms.DecryptInput{CiphertextBlob: []byte("ciphertext")})
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		t.Fatalf("adapter.Decrypt() err = %v, want awserr.Error", err)
+	}
+	if aerr.Code() != kms.ErrCodeInvalidCiphertextException {
+		t.Errorf("aerr.Code() = %q, want %q", aerr.Code(), kms.ErrCodeInvalidCiphertextException)
+	}
+	if aerr.OrigErr() != fake.err {
+		t.Errorf("aerr.OrigErr() = %v, want %v", aerr.OrigErr(), fake.err)
+	}
+}
+
+func TestKMSV2Adapter_RequestOptionsFail(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	adapter := newKMSV2Adapter(&fakeKMSV2{kms: fakekms})
+	input := &kms.EncryptInput{KeyId: aws.String(symmetricKeyARN), Plaintext: []byte("plaintext")}
+	if _, err := adapter.EncryptWithContext(context.Background(), input); err != nil {
+		t.Fatalf("adapter.EncryptWithContext() err = %v, want nil", err)
+	}
+	if _, err := adapter.EncryptWithContext(context.Background(), input, request.WithResponseReadTimeout(time.Second)); err == nil {
+		t.Error("adapter.EncryptWithContext() with request options err = nil, want error")
+	}
+}
+
+func TestKMSV2Adapter_OutOfRangeNumberOfBytesFails(t *testing.T) {
+	adapter := newKMSV2Adapter(&fakeKMSV2{})
+	_, err := adapter.GenerateDataKeyWithContext(context.Background(), &kms.GenerateDataKeyInput{
+		KeyId:         aws.String(symmetricKeyARN),
+		NumberOfBytes: aws.Int64(math.MaxInt32 + 32),
+	})
+	if err == nil {
+		t.Error("adapter.GenerateDataKeyWithContext() with out of range NumberOfBytes err = nil, want error")
+	}
+}
+
+// kmsV2Message holds the fields of the JSON bodies of the AWS KMS Encrypt and
+// Decrypt requests and responses used in tests.
+type kmsV2Message struct {
+	KeyId             string            `json:",omitempty"`
+	Plaintext         []byte            `json:",omitempty"`
+	CiphertextBlob    []byte            `json:",omitempty"`
+	EncryptionContext map[string]string `json:",omitempty"`
+}
+
+// newKMSV2Server returns an AWS SDK for Go v2 KMS client sending its Encrypt
+// and Decrypt requests to fakekms through an HTTP server, and the targets of
+// the requests it made.
+func newKMSV2Server(t *testing.T, fakekms kmsiface.KMSAPI) (*kmsv2.Client, *[]string) {
+	t.Helper()
+	var targets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		targets = append(targets, target)
+		var input, output kmsV2Message
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err == nil {
+			switch target {
+			case "TrentService.Encrypt":
+				var resp *kms.EncryptOutput
+				resp, err = fakekms.EncryptWithContext(r.Context(), &kms.EncryptInput{
+					KeyId:             aws.String(input.KeyId),
+					Plaintext:         input.Plaintext,
+					EncryptionContext: aws.StringMap(input.EncryptionContext),
+				})
+				if err == nil {
+					output = kmsV2Message{KeyId: aws.StringValue(resp.KeyId), CiphertextBlob: resp.CiphertextBlob}
+				}
+			case "TrentService.Decrypt":
+				var resp *kms.DecryptOutput
+				resp, err = fakekms.DecryptWithContext(r.Context(), &kms.DecryptInput{
+					KeyId:             aws.String(input.KeyId),
+					CiphertextBlob:    input.CiphertextBlob,
+					EncryptionContext: aws.StringMap(input.EncryptionContext),
+				})
+				if err == nil {
+					output = kmsV2Message{KeyId: aws.StringValue(resp.KeyId), Plaintext: resp.Plaintext}
+				}
+			default:
+				err = awserr.New("UnsupportedOperationException", target, nil)
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if err != nil {
+			code := "InternalFailure"
+			if aerr, ok := err.(awserr.Error); ok {
+				code = aerr.Code()
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type": %q, "message": %q}`, code, err.Error())
+			return
+		}
+		if err := json.NewEncoder(w).Encode(output); err != nil {
+			t.Errorf("json.NewEncoder(w).Encode() err = %v", err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client := kmsv2.New(kmsv2.Options{
+		Region:       "us-east-2",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials:  awsv2.AnonymousCredentials{},
+	})
+	return client, &targets
+}
+
+func TestWithKMSV2_EndToEnd(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{symmetricKeyARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	v2, targets := newKMSV2Server(t, fakekms)
+	v2Client, err := NewClientWithOptions("aws-kms://", WithKMSV2(v2))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions(_, WithKMSV2(_)) err = %v, want nil", err)
+	}
+	v2AEAD, err := v2Client.GetAEAD("aws-kms://" + symmetricKeyARN)
+	if err != nil {
+		t.Fatalf("v2Client.GetAEAD() err = %v, want nil", err)
+	}
+	v1Client, err := NewClientWithOptions("aws-kms://", WithKMS(fakekms))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions(_, WithKMS(_)) err = %v, want nil", err)
+	}
+	v1AEAD, err := v1Client.(*Client).GetAEADWithOptions(symmetricKeyURI)
+	if err != nil {
+		t.Fatalf("v1Client.GetAEADWithOptions(symmetricKeyURI) err = %v, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	associatedData := []byte("associatedData")
+
+	ciphertext, err := v2AEAD.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("v2AEAD.Encrypt() err = %v, want nil", err)
+	}
+	decrypted, err := v1AEAD.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("v1AEAD.Decrypt() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("v1AEAD.Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+	decrypted, err = v2AEAD.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("v2AEAD.Decrypt() err = %v, want nil", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("v2AEAD.Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+	if _, err := v2AEAD.Decrypt(ciphertext, []byte("invalidAssociatedData")); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("v2AEAD.Decrypt() with invalid associated data err = %v, want %v", err, ErrInvalidCiphertext)
+	}
+	if want := []string{"TrentService.Encrypt", "TrentService.Decrypt", "TrentService.Decrypt"}; !slices.Equal(*targets, want) {
+		t.Errorf("requests = %q, want %q", *targets, want)
+	}
+}
+
+func TestWithRegionalKMSV2_MultiRegionKey(t *testing.T) {
+	fakekms, err := fakeawskms.New([]string{eastReplicaARN, westReplicaARN})
+	if err != nil {
+		t.Fatalf("fakeawskms.New() failed: %v", err)
+	}
+	east, eastTargets := newKMSV2Server(t, fakekms)
+	west, westTargets := newKMSV2Server(t, fakekms)
+	client, err := NewClientWithOptions("aws-kms://",
+		WithKMSV2(east),
+		WithRegionalKMSV2("us-west-2", west),
+		WithMultiRegionKeyReplicas(eastReplicaURI, westReplicaURI))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+	}
+	a, err := client.GetAEAD(westReplicaURI)
+	if err != nil {
+		t.Fatalf("client.GetAEAD(westReplicaURI) err = %v, want nil", err)
+	}
+	if _, err := a.Encrypt([]byte("plaintext"), nil); err != nil {
+		t.Fatalf("a.Encrypt() err = %v, want nil", err)
+	}
+	if len(*westTargets) != 1 || len(*eastTargets) != 0 {
+		t.Errorf("requests (west, east) = (%q, %q), want one to west only", *westTargets, *eastTargets)
+	}
+
+	if _, err := NewClientWithOptions("aws-kms://", WithKMSV2(east), WithRegionalKMSV2("us-west-2", nil)); err == nil {
+		t.Error("NewClientWithOptions(_, WithRegionalKMSV2(_, nil)) err = nil, want error")
+	}
+}
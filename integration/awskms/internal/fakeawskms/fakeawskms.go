@@ -19,10 +19,26 @@ package fakeawskms
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"hash"
+	"regexp"
 	"sort"
+	"strings"
+	"sync"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 	"github.com/tink-crypto/tink-go/v2/aead"
@@ -32,8 +48,84 @@ import (
 
 type fakeAWSKMS struct {
 	kmsiface.KMSAPI
-	aeads  map[string]tink.AEAD
-	keyIDs []string
+	aeads    map[string]tink.AEAD
+	signers  map[string]crypto.Signer
+	hmacKeys map[string][]byte
+	keySpecs map[string]string
+	keyIDs   []string
+	// aliases maps alias names to the key ARNs they refer to.
+	aliases map[string]string
+	// keyStates maps keys to their state, if not enabled.
+	keyStates map[string]string
+	// deniedKeys are the keys requests are denied access to.
+	deniedKeys map[string]bool
+
+	mu                sync.Mutex
+	throttledRequests int
+	// grants maps the tokens of the grants created with CreateGrant to the
+	// keys of the grants.
+	grants map[string]string
+}
+
+// Option configures the fake AWS KMS API returned by [NewWithKeySpecs].
+type Option func(*fakeAWSKMS) error
+
+// WithAlias adds the alias aliasName, such as "alias/my-key", which refers to
+// the key keyID. keyID must be the ARN of a key of the fake.
+//
+// The alias can be used in requests by alias name or alias ARN.
+func WithAlias(aliasName, keyID string) Option {
+	return func(f *fakeAWSKMS) error {
+		if !strings.HasPrefix(aliasName, "alias/") {
+			return fmt.Errorf("alias name %q must start with \"alias/\"", aliasName)
+		}
+		if _, ok := f.keySpecs[keyID]; !ok {
+			return fmt.Errorf("Unknown keyID: %q not in %q", keyID, f.keyIDs)
+		}
+		if _, ok := f.aliases[aliasName]; ok {
+			return fmt.Errorf("alias %q already exists", aliasName)
+		}
+		f.aliases[aliasName] = keyID
+		return nil
+	}
+}
+
+// WithKeyState sets the state of the key keyID, such as
+// kms.KeyStateDisabled. Like in AWS KMS, cryptographic operations with keys
+// which are disabled fail with DisabledException, and with keys in other
+// states than kms.KeyStateEnabled with KMSInvalidStateException.
+func WithKeyState(keyID, keyState string) Option {
+	return func(f *fakeAWSKMS) error {
+		if _, ok := f.keySpecs[keyID]; !ok {
+			return fmt.Errorf("Unknown keyID: %q not in %q", keyID, f.keyIDs)
+		}
+		f.keyStates[keyID] = keyState
+		return nil
+	}
+}
+
+// WithAccessDenied makes requests using the key keyID fail with
+// AccessDeniedException.
+func WithAccessDenied(keyID string) Option {
+	return func(f *fakeAWSKMS) error {
+		if _, ok := f.keySpecs[keyID]; !ok {
+			return fmt.Errorf("Unknown keyID: %q not in %q", keyID, f.keyIDs)
+		}
+		f.deniedKeys[keyID] = true
+		return nil
+	}
+}
+
+// WithThrottledRequests makes the first n requests fail with
+// ThrottlingException.
+func WithThrottledRequests(n int) Option {
+	return func(f *fakeAWSKMS) error {
+		if n < 0 {
+			return fmt.Errorf("n must not be negative, got %d", n)
+		}
+		f.throttledRequests = n
+		return nil
+	}
 }
 
 // serializeContext serializes the context map in a canonical way into a byte array.
@@ -55,30 +147,112 @@ func serializeContext(context map[string]*string) []byte {
 	return b.Bytes()
 }
 
-// New returns a new fake AWS KMS API.
+// New returns a new fake AWS KMS API with symmetric encryption keys.
+//
+// Keys whose IDs are ARNs of replicas of the same multi-region key, with key
+// IDs starting with "mrk-", share their key material.
 func New(validKeyIDs []string) (kmsiface.KMSAPI, error) {
-	aeads := make(map[string]tink.AEAD)
+	keySpecs := make(map[string]string)
 	for _, keyID := range validKeyIDs {
-		handle, err := keyset.NewHandle(aead.AES256GCMKeyTemplate())
-		if err != nil {
+		keySpecs[keyID] = kms.KeySpecSymmetricDefault
+	}
+	return newFakeAWSKMS(validKeyIDs, keySpecs)
+}
+
+// NewWithKeySpecs returns a new fake AWS KMS API with keys of the given specs,
+// configured by opts.
+//
+// keySpecs maps key IDs to AWS KMS key specs. Supported specs are
+// kms.KeySpecSymmetricDefault, the RSA specs, the ECC_NIST specs and the HMAC
+// specs.
+func NewWithKeySpecs(keySpecs map[string]string, opts ...Option) (kmsiface.KMSAPI, error) {
+	keyIDs := make([]string, 0, len(keySpecs))
+	for keyID := range keySpecs {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	f, err := newFakeAWSKMS(keyIDs, keySpecs)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
 			return nil, err
 		}
-		a, err := aead.New(handle)
+	}
+	return f, nil
+}
+
+func newFakeAWSKMS(keyIDs []string, keySpecs map[string]string) (*fakeAWSKMS, error) {
+	f := &fakeAWSKMS{
+		aeads:    make(map[string]tink.AEAD),
+		signers:  make(map[string]crypto.Signer),
+		hmacKeys: make(map[string][]byte),
+		keySpecs: keySpecs,
+		keyIDs:   keyIDs,
+		aliases:  make(map[string]string),
+
+		keyStates:  make(map[string]string),
+		deniedKeys: make(map[string]bool),
+		grants:     make(map[string]string),
+	}
+	multiRegionAEADs := make(map[string]tink.AEAD)
+	for _, keyID := range keyIDs {
+		var err error
+		switch keySpec := keySpecs[keyID]; keySpec {
+		case kms.KeySpecSymmetricDefault:
+			// Replicas of multi-region keys share their key material.
+			materialID := multiRegionKeyMaterialID(keyID)
+			if a, ok := multiRegionAEADs[materialID]; ok && materialID != "" {
+				f.aeads[keyID] = a
+				continue
+			}
+			var handle *keyset.Handle
+			handle, err = keyset.NewHandle(aead.AES256GCMKeyTemplate())
+			if err != nil {
+				return nil, err
+			}
+			f.aeads[keyID], err = aead.New(handle)
+			if materialID != "" {
+				multiRegionAEADs[materialID] = f.aeads[keyID]
+			}
+		case kms.KeySpecRsa2048:
+			f.signers[keyID], err = rsa.GenerateKey(rand.Reader, 2048)
+		case kms.KeySpecRsa3072:
+			f.signers[keyID], err = rsa.GenerateKey(rand.Reader, 3072)
+		case kms.KeySpecRsa4096:
+			f.signers[keyID], err = rsa.GenerateKey(rand.Reader, 4096)
+		case kms.KeySpecEccNistP256:
+			f.signers[keyID], err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case kms.KeySpecEccNistP384:
+			f.signers[keyID], err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		case kms.KeySpecEccNistP521:
+			f.signers[keyID], err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		case kms.KeySpecHmac224, kms.KeySpecHmac256, kms.KeySpecHmac384, kms.KeySpecHmac512:
+			key := make([]byte, macHashes[macAlgorithm(keySpec)]().Size())
+			_, err = rand.Read(key)
+			f.hmacKeys[keyID] = key
+		default:
+			return nil, fmt.Errorf("unsupported key spec %q for keyID %q", keySpec, keyID)
+		}
 		if err != nil {
 			return nil, err
 		}
-		aeads[keyID] = a
 	}
-	return &fakeAWSKMS{
-		aeads:  aeads,
-		keyIDs: validKeyIDs,
-	}, nil
+	return f, nil
 }
 
 func (f *fakeAWSKMS) Encrypt(request *kms.EncryptInput) (*kms.EncryptOutput, error) {
-	a, ok := f.aeads[*request.KeyId]
+	if err := f.checkGrantTokens(request.GrantTokens); err != nil {
+		return nil, err
+	}
+	keyID := f.resolveKeyID(*request.KeyId)
+	if err := f.checkKey(keyID); err != nil {
+		return nil, err
+	}
+	a, ok := f.aeads[keyID]
 	if !ok {
-		return nil, fmt.Errorf("Unknown keyID: %q not in %q", *request.KeyId, f.keyIDs)
+		return nil, f.unusableKeyError(keyID, "encryption")
 	}
 	serializedContext := serializeContext(request.EncryptionContext)
 	ciphertext, err := a.Encrypt(request.Plaintext, serializedContext)
@@ -87,35 +261,661 @@ func (f *fakeAWSKMS) Encrypt(request *kms.EncryptInput) (*kms.EncryptOutput, err
 	}
 	return &kms.EncryptOutput{
 		CiphertextBlob: ciphertext,
-		KeyId:          request.KeyId,
+		KeyId:          aws.String(keyID),
 	}, nil
 }
 
+func (f *fakeAWSKMS) EncryptWithContext(ctx aws.Context, request *kms.EncryptInput, _ ...request.Option) (*kms.EncryptOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Encrypt(request)
+}
+
 func (f *fakeAWSKMS) Decrypt(request *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	if err := f.checkGrantTokens(request.GrantTokens); err != nil {
+		return nil, err
+	}
 	serializedContext := serializeContext(request.EncryptionContext)
 	if request.KeyId != nil {
-		a, ok := f.aeads[*request.KeyId]
+		keyID := f.resolveKeyID(*request.KeyId)
+		if err := f.checkKey(keyID); err != nil {
+			return nil, err
+		}
+		a, ok := f.aeads[keyID]
 		if !ok {
-			return nil, fmt.Errorf("Unknown keyID: %q not in %q", *request.KeyId, f.keyIDs)
+			return nil, f.unusableKeyError(keyID, "decryption")
 		}
 		plaintext, err := a.Decrypt(request.CiphertextBlob, serializedContext)
 		if err != nil {
-			return nil, fmt.Errorf("Decryption with keyID %q failed", *request.KeyId)
+			return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, fmt.Sprintf("Decryption with keyID %q failed", keyID), nil)
 		}
 		return &kms.DecryptOutput{
 			Plaintext: plaintext,
-			KeyId:     request.KeyId,
+			KeyId:     aws.String(keyID),
 		}, nil
 	}
 	// When KeyId is not set, try out all AEADs.
 	for keyID, a := range f.aeads {
 		plaintext, err := a.Decrypt(request.CiphertextBlob, serializedContext)
 		if err == nil {
+			if err := f.checkKey(keyID); err != nil {
+				return nil, err
+			}
 			return &kms.DecryptOutput{
 				Plaintext: plaintext,
 				KeyId:     &keyID,
 			}, nil
 		}
 	}
-	return nil, errors.New("unable to decrypt message")
+	return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, "unable to decrypt message", nil)
+}
+
+func (f *fakeAWSKMS) DecryptWithContext(ctx aws.Context, request *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Decrypt(request)
+}
+
+func (f *fakeAWSKMS) ReEncrypt(request *kms.ReEncryptInput) (*kms.ReEncryptOutput, error) {
+	decrypted, err := f.Decrypt(&kms.DecryptInput{
+		KeyId:             request.SourceKeyId,
+		CiphertextBlob:    request.CiphertextBlob,
+		EncryptionContext: request.SourceEncryptionContext,
+		GrantTokens:       request.GrantTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := f.Encrypt(&kms.EncryptInput{
+		KeyId:             request.DestinationKeyId,
+		Plaintext:         decrypted.Plaintext,
+		EncryptionContext: request.DestinationEncryptionContext,
+		GrantTokens:       request.GrantTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kms.ReEncryptOutput{
+		CiphertextBlob: encrypted.CiphertextBlob,
+		KeyId:          encrypted.KeyId,
+		SourceKeyId:    decrypted.KeyId,
+	}, nil
+}
+
+func (f *fakeAWSKMS) ReEncryptWithContext(ctx aws.Context, request *kms.ReEncryptInput, _ ...request.Option) (*kms.ReEncryptOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.ReEncrypt(request)
+}
+
+func (f *fakeAWSKMS) CreateGrant(request *kms.CreateGrantInput) (*kms.CreateGrantOutput, error) {
+	keyID := f.resolveKeyID(aws.StringValue(request.KeyId))
+	if err := f.checkKey(keyID); err != nil {
+		return nil, err
+	}
+	if _, ok := f.keySpecs[keyID]; !ok {
+		return nil, f.unusableKeyError(keyID, "grants")
+	}
+	if aws.StringValue(request.GranteePrincipal) == "" || len(request.Operations) == 0 {
+		return nil, validationError("GranteePrincipal and Operations are required")
+	}
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	grantID := fmt.Sprintf("%x", id)
+	token := "token-" + grantID
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.grants[token] = keyID
+	return &kms.CreateGrantOutput{
+		GrantId:    aws.String(grantID),
+		GrantToken: aws.String(token),
+	}, nil
+}
+
+func (f *fakeAWSKMS) CreateGrantWithContext(ctx aws.Context, request *kms.CreateGrantInput, _ ...request.Option) (*kms.CreateGrantOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.CreateGrant(request)
+}
+
+func (f *fakeAWSKMS) GenerateDataKey(request *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	var size int
+	switch {
+	case request.KeySpec != nil && request.NumberOfBytes != nil:
+		return nil, errors.New("only one of KeySpec and NumberOfBytes can be set")
+	case aws.StringValue(request.KeySpec) == kms.DataKeySpecAes256:
+		size = 32
+	case aws.StringValue(request.KeySpec) == kms.DataKeySpecAes128:
+		size = 16
+	case request.KeySpec != nil:
+		return nil, fmt.Errorf("unsupported data key spec %q", *request.KeySpec)
+	case request.NumberOfBytes != nil && *request.NumberOfBytes >= 1 && *request.NumberOfBytes <= 1024:
+		size = int(*request.NumberOfBytes)
+	default:
+		return nil, errors.New("either KeySpec or NumberOfBytes between 1 and 1024 must be set")
+	}
+	plaintext := make([]byte, size)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+	resp, err := f.Encrypt(&kms.EncryptInput{
+		KeyId:             request.KeyId,
+		Plaintext:         plaintext,
+		EncryptionContext: request.EncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{
+		CiphertextBlob: resp.CiphertextBlob,
+		KeyId:          resp.KeyId,
+		Plaintext:      plaintext,
+	}, nil
+}
+
+func (f *fakeAWSKMS) GenerateDataKeyWithContext(ctx aws.Context, request *kms.GenerateDataKeyInput, _ ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.GenerateDataKey(request)
+}
+
+func (f *fakeAWSKMS) GenerateDataKeyWithoutPlaintext(request *kms.GenerateDataKeyWithoutPlaintextInput) (*kms.GenerateDataKeyWithoutPlaintextOutput, error) {
+	resp, err := f.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:             request.KeyId,
+		KeySpec:           request.KeySpec,
+		NumberOfBytes:     request.NumberOfBytes,
+		EncryptionContext: request.EncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyWithoutPlaintextOutput{
+		CiphertextBlob: resp.CiphertextBlob,
+		KeyId:          resp.KeyId,
+	}, nil
+}
+
+func (f *fakeAWSKMS) GenerateDataKeyWithoutPlaintextWithContext(ctx aws.Context, request *kms.GenerateDataKeyWithoutPlaintextInput, _ ...request.Option) (*kms.GenerateDataKeyWithoutPlaintextOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.GenerateDataKeyWithoutPlaintext(request)
+}
+
+func (f *fakeAWSKMS) Sign(request *kms.SignInput) (*kms.SignOutput, error) {
+	keyID, err := f.requiredKeyID(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkKey(keyID); err != nil {
+		return nil, err
+	}
+	signer, ok := f.signers[keyID]
+	if !ok {
+		return nil, f.unusableKeyError(keyID, "signing")
+	}
+	digest, h, err := f.digest(keyID, request.SigningAlgorithm, request.MessageType, request.Message)
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		if isPSS(aws.StringValue(request.SigningAlgorithm)) {
+			signature, err = rsa.SignPSS(rand.Reader, key, h, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, h, digest)
+		}
+	case *ecdsa.PrivateKey:
+		signature, err = ecdsa.SignASN1(rand.Reader, key, digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kms.SignOutput{
+		KeyId:            aws.String(keyID),
+		Signature:        signature,
+		SigningAlgorithm: request.SigningAlgorithm,
+	}, nil
+}
+
+func (f *fakeAWSKMS) SignWithContext(ctx aws.Context, request *kms.SignInput, _ ...request.Option) (*kms.SignOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Sign(request)
+}
+
+func (f *fakeAWSKMS) Verify(request *kms.VerifyInput) (*kms.VerifyOutput, error) {
+	keyID, err := f.requiredKeyID(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkKey(keyID); err != nil {
+		return nil, err
+	}
+	signer, ok := f.signers[keyID]
+	if !ok {
+		return nil, f.unusableKeyError(keyID, "verification")
+	}
+	digest, h, err := f.digest(keyID, request.SigningAlgorithm, request.MessageType, request.Message)
+	if err != nil {
+		return nil, err
+	}
+	valid := false
+	switch key := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if isPSS(aws.StringValue(request.SigningAlgorithm)) {
+			valid = rsa.VerifyPSS(key, h, digest, request.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		} else {
+			valid = rsa.VerifyPKCS1v15(key, h, digest, request.Signature) == nil
+		}
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest, request.Signature)
+	}
+	if !valid {
+		return nil, awserr.New(kms.ErrCodeKMSInvalidSignatureException, fmt.Sprintf("invalid signature for keyID %q", keyID), nil)
+	}
+	return &kms.VerifyOutput{
+		KeyId:            aws.String(keyID),
+		SignatureValid:   aws.Bool(true),
+		SigningAlgorithm: request.SigningAlgorithm,
+	}, nil
+}
+
+func (f *fakeAWSKMS) VerifyWithContext(ctx aws.Context, request *kms.VerifyInput, _ ...request.Option) (*kms.VerifyOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Verify(request)
+}
+
+func (f *fakeAWSKMS) GetPublicKey(request *kms.GetPublicKeyInput) (*kms.GetPublicKeyOutput, error) {
+	keyID, err := f.requiredKeyID(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkKey(keyID); err != nil {
+		return nil, err
+	}
+	signer, ok := f.signers[keyID]
+	if !ok {
+		return nil, f.unusableKeyError(keyID, "public key export")
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GetPublicKeyOutput{
+		KeyId:             aws.String(keyID),
+		KeySpec:           aws.String(f.keySpecs[keyID]),
+		KeyUsage:          aws.String(kms.KeyUsageTypeSignVerify),
+		PublicKey:         publicKey,
+		SigningAlgorithms: aws.StringSlice(signingAlgorithms[f.keySpecs[keyID]]),
+	}, nil
+}
+
+func (f *fakeAWSKMS) GetPublicKeyWithContext(ctx aws.Context, request *kms.GetPublicKeyInput, _ ...request.Option) (*kms.GetPublicKeyOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.GetPublicKey(request)
+}
+
+// multiRegionKeyMaterialID returns an ID shared by all replicas of keyID if it
+// is the ARN of a multi-region key, whose key ID starts with "mrk-", and ""
+// otherwise. Replicas only differ by the region field of their ARN.
+func multiRegionKeyMaterialID(keyID string) string {
+	fields := strings.Split(keyID, ":")
+	if len(fields) != 6 || fields[0] != "arn" || fields[2] != "kms" || !strings.HasPrefix(fields[5], "key/mrk-") {
+		return ""
+	}
+	return fields[1] + ":" + fields[4] + ":" + fields[5]
+}
+
+var aliasARN = regexp.MustCompile(`^(arn:[^:]+:kms:[^:]+:[^:]*:)(alias/.+)$`)
+
+// requiredKeyID returns the key ARN that keyID, a required parameter, refers
+// to, see resolveKeyID. It fails with a ValidationException if keyID is
+// missing.
+func (f *fakeAWSKMS) requiredKeyID(keyID *string) (string, error) {
+	if aws.StringValue(keyID) == "" {
+		return "", validationError("KeyId is required")
+	}
+	return f.resolveKeyID(*keyID), nil
+}
+
+// validationError returns the error of AWS KMS for invalid request
+// parameters.
+func validationError(message string) error {
+	return awserr.New("ValidationException", message, nil)
+}
+
+// resolveKeyID returns the key ARN that keyID refers to. keyID can be a key
+// ARN, an alias name, an alias ARN or a key ID. If keyID doesn't refer to a
+// key of the fake, it is returned as is.
+func (f *fakeAWSKMS) resolveKeyID(keyID string) string {
+	if _, ok := f.keySpecs[keyID]; ok {
+		return keyID
+	}
+	if target, ok := f.aliases[keyID]; ok {
+		return target
+	}
+	if m := aliasARN.FindStringSubmatch(keyID); m != nil {
+		if target, ok := f.aliases[m[2]]; ok && strings.HasPrefix(target, m[1]) {
+			return target
+		}
+		return keyID
+	}
+	for _, id := range f.keyIDs {
+		if strings.HasSuffix(id, ":key/"+keyID) {
+			return id
+		}
+	}
+	return keyID
+}
+
+func (f *fakeAWSKMS) ListAliases(request *kms.ListAliasesInput) (*kms.ListAliasesOutput, error) {
+	var targetKeyID string
+	if request.KeyId != nil {
+		targetKeyID = f.resolveKeyID(*request.KeyId)
+		if _, ok := f.keySpecs[targetKeyID]; !ok {
+			return nil, f.unusableKeyError(targetKeyID, "")
+		}
+	}
+	names := make([]string, 0, len(f.aliases))
+	for name := range f.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp := &kms.ListAliasesOutput{Truncated: aws.Bool(false)}
+	for _, name := range names {
+		target := f.aliases[name]
+		if targetKeyID != "" && target != targetKeyID {
+			continue
+		}
+		i := strings.Index(target, ":key/")
+		resp.Aliases = append(resp.Aliases, &kms.AliasListEntry{
+			AliasArn:    aws.String(target[:i+1] + name),
+			AliasName:   aws.String(name),
+			TargetKeyId: aws.String(target[i+len(":key/"):]),
+		})
+	}
+	return resp, nil
+}
+
+func (f *fakeAWSKMS) ListAliasesWithContext(ctx aws.Context, request *kms.ListAliasesInput, _ ...request.Option) (*kms.ListAliasesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.ListAliases(request)
+}
+
+// unusableKeyError returns the error for keyID not being usable for operation.
+func (f *fakeAWSKMS) unusableKeyError(keyID, operation string) error {
+	if keySpec, ok := f.keySpecs[keyID]; ok {
+		return awserr.New(kms.ErrCodeInvalidKeyUsageException, fmt.Sprintf("keyID %q with key spec %q cannot be used for %s", keyID, keySpec, operation), nil)
+	}
+	return awserr.New(kms.ErrCodeNotFoundException, fmt.Sprintf("Unknown keyID: %q not in %q", keyID, f.keyIDs), nil)
+}
+
+// checkAccess returns the error of a request using keyID if the request is
+// throttled or the access to keyID is denied, and nil otherwise.
+func (f *fakeAWSKMS) checkAccess(keyID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.throttledRequests > 0 {
+		f.throttledRequests--
+		return awserr.New("ThrottlingException", "Rate exceeded", nil)
+	}
+	if f.deniedKeys[keyID] {
+		return awserr.New("AccessDeniedException", fmt.Sprintf("User is not authorized to perform this operation on %q", keyID), nil)
+	}
+	return nil
+}
+
+// checkGrantTokens returns an InvalidGrantTokenException error if one of
+// tokens wasn't returned by CreateGrant, and nil otherwise.
+func (f *fakeAWSKMS) checkGrantTokens(tokens []*string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, token := range tokens {
+		if _, ok := f.grants[aws.StringValue(token)]; !ok {
+			return awserr.New(kms.ErrCodeInvalidGrantTokenException, "The grant token is invalid", nil)
+		}
+	}
+	return nil
+}
+
+// checkKey returns the error of a cryptographic operation using keyID if the
+// request is denied or keyID is not enabled, and nil otherwise.
+func (f *fakeAWSKMS) checkKey(keyID string) error {
+	if err := f.checkAccess(keyID); err != nil {
+		return err
+	}
+	switch state := f.keyStates[keyID]; state {
+	case "", kms.KeyStateEnabled:
+		return nil
+	case kms.KeyStateDisabled:
+		return awserr.New(kms.ErrCodeDisabledException, fmt.Sprintf("%q is disabled.", keyID), nil)
+	default:
+		return awserr.New(kms.ErrCodeInvalidStateException, fmt.Sprintf("%q is %s.", keyID, state), nil)
+	}
+}
+
+var rsaSigningAlgorithms = []string{
+	kms.SigningAlgorithmSpecRsassaPssSha256,
+	kms.SigningAlgorithmSpecRsassaPssSha384,
+	kms.SigningAlgorithmSpecRsassaPssSha512,
+	kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	kms.SigningAlgorithmSpecRsassaPkcs1V15Sha384,
+	kms.SigningAlgorithmSpecRsassaPkcs1V15Sha512,
+}
+
+// signingAlgorithms maps key specs to the signing algorithms they support.
+var signingAlgorithms = map[string][]string{
+	kms.KeySpecRsa2048:     rsaSigningAlgorithms,
+	kms.KeySpecRsa3072:     rsaSigningAlgorithms,
+	kms.KeySpecRsa4096:     rsaSigningAlgorithms,
+	kms.KeySpecEccNistP256: {kms.SigningAlgorithmSpecEcdsaSha256},
+	kms.KeySpecEccNistP384: {kms.SigningAlgorithmSpecEcdsaSha384},
+	kms.KeySpecEccNistP521: {kms.SigningAlgorithmSpecEcdsaSha512},
+}
+
+// signingHash returns the hash function of signingAlgorithm, which AWS KMS
+// names after it, such as SHA_256 for ECDSA_SHA_256.
+func signingHash(signingAlgorithm string) crypto.Hash {
+	switch {
+	case strings.HasSuffix(signingAlgorithm, "_SHA_256"):
+		return crypto.SHA256
+	case strings.HasSuffix(signingAlgorithm, "_SHA_384"):
+		return crypto.SHA384
+	case strings.HasSuffix(signingAlgorithm, "_SHA_512"):
+		return crypto.SHA512
+	}
+	return 0
+}
+
+func isPSS(signingAlgorithm string) bool {
+	switch signingAlgorithm {
+	case kms.SigningAlgorithmSpecRsassaPssSha256, kms.SigningAlgorithmSpecRsassaPssSha384, kms.SigningAlgorithmSpecRsassaPssSha512:
+		return true
+	}
+	return false
+}
+
+// digest checks that signingAlgorithm is supported by keyID and returns the
+// digest of message, hashing it if messageType is RAW.
+func (f *fakeAWSKMS) digest(keyID string, signingAlgorithm, messageType *string, message []byte) ([]byte, crypto.Hash, error) {
+	if aws.StringValue(signingAlgorithm) == "" {
+		return nil, 0, validationError("SigningAlgorithm is required")
+	}
+	supported := false
+	for _, a := range signingAlgorithms[f.keySpecs[keyID]] {
+		if a == *signingAlgorithm {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, 0, awserr.New(kms.ErrCodeInvalidKeyUsageException, fmt.Sprintf("signing algorithm %q is not supported by keyID %q", *signingAlgorithm, keyID), nil)
+	}
+	h := signingHash(*signingAlgorithm)
+	switch aws.StringValue(messageType) {
+	case "", kms.MessageTypeRaw:
+		if len(message) > 4096 {
+			return nil, 0, validationError(fmt.Sprintf("RAW message too long: %d bytes", len(message)))
+		}
+		digest := h.New()
+		digest.Write(message)
+		return digest.Sum(nil), h, nil
+	case kms.MessageTypeDigest:
+		if len(message) != h.Size() {
+			return nil, 0, validationError(fmt.Sprintf("digest has %d bytes, want %d", len(message), h.Size()))
+		}
+		return message, h, nil
+	default:
+		return nil, 0, validationError(fmt.Sprintf("unsupported message type %q", *messageType))
+	}
+}
+
+func (f *fakeAWSKMS) GenerateMac(request *kms.GenerateMacInput) (*kms.GenerateMacOutput, error) {
+	keyID, err := f.requiredKeyID(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkKey(keyID); err != nil {
+		return nil, err
+	}
+	mac, err := f.computeMAC(keyID, request.MacAlgorithm, request.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GenerateMacOutput{
+		KeyId:        aws.String(keyID),
+		Mac:          mac,
+		MacAlgorithm: request.MacAlgorithm,
+	}, nil
+}
+
+func (f *fakeAWSKMS) GenerateMacWithContext(ctx aws.Context, request *kms.GenerateMacInput, _ ...request.Option) (*kms.GenerateMacOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.GenerateMac(request)
+}
+
+func (f *fakeAWSKMS) VerifyMac(request *kms.VerifyMacInput) (*kms.VerifyMacOutput, error) {
+	keyID, err := f.requiredKeyID(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkKey(keyID); err != nil {
+		return nil, err
+	}
+	mac, err := f.computeMAC(keyID, request.MacAlgorithm, request.Message)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, request.Mac) {
+		return nil, awserr.New(kms.ErrCodeKMSInvalidMacException, fmt.Sprintf("invalid MAC for keyID %q", keyID), nil)
+	}
+	return &kms.VerifyMacOutput{
+		KeyId:        aws.String(keyID),
+		MacAlgorithm: request.MacAlgorithm,
+		MacValid:     aws.Bool(true),
+	}, nil
+}
+
+func (f *fakeAWSKMS) VerifyMacWithContext(ctx aws.Context, request *kms.VerifyMacInput, _ ...request.Option) (*kms.VerifyMacOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.VerifyMac(request)
+}
+
+func (f *fakeAWSKMS) DescribeKey(request *kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	keyID, err := f.requiredKeyID(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkAccess(keyID); err != nil {
+		return nil, err
+	}
+	keySpec, ok := f.keySpecs[keyID]
+	if !ok {
+		return nil, f.unusableKeyError(keyID, "")
+	}
+	md := &kms.KeyMetadata{
+		Arn:      aws.String(keyID),
+		Enabled:  aws.Bool(true),
+		KeyId:    aws.String(keyID),
+		KeySpec:  aws.String(keySpec),
+		KeyState: aws.String(kms.KeyStateEnabled),
+	}
+	if state, ok := f.keyStates[keyID]; ok {
+		md.Enabled = aws.Bool(state == kms.KeyStateEnabled)
+		md.KeyState = aws.String(state)
+	}
+	switch {
+	case keySpec == kms.KeySpecSymmetricDefault:
+		md.KeyUsage = aws.String(kms.KeyUsageTypeEncryptDecrypt)
+		md.EncryptionAlgorithms = aws.StringSlice([]string{kms.EncryptionAlgorithmSpecSymmetricDefault})
+	case macAlgorithm(keySpec) != "":
+		md.KeyUsage = aws.String(kms.KeyUsageTypeGenerateVerifyMac)
+		md.MacAlgorithms = aws.StringSlice([]string{macAlgorithm(keySpec)})
+	default:
+		md.KeyUsage = aws.String(kms.KeyUsageTypeSignVerify)
+		md.SigningAlgorithms = aws.StringSlice(signingAlgorithms[keySpec])
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: md}, nil
+}
+
+func (f *fakeAWSKMS) DescribeKeyWithContext(ctx aws.Context, request *kms.DescribeKeyInput, _ ...request.Option) (*kms.DescribeKeyOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.DescribeKey(request)
+}
+
+// macAlgorithm returns the MAC algorithm supported by keys of keySpec, which
+// AWS KMS names after it, such as HMAC_SHA_256 for HMAC_256, or "" if keySpec
+// is not an HMAC key spec.
+func macAlgorithm(keySpec string) string {
+	bits, ok := strings.CutPrefix(keySpec, "HMAC_")
+	if !ok {
+		return ""
+	}
+	return "HMAC_SHA_" + bits
+}
+
+var macHashes = map[string]func() hash.Hash{
+	kms.MacAlgorithmSpecHmacSha224: sha256.New224,
+	kms.MacAlgorithmSpecHmacSha256: sha256.New,
+	kms.MacAlgorithmSpecHmacSha384: sha512.New384,
+	kms.MacAlgorithmSpecHmacSha512: sha512.New,
+}
+
+// computeMAC checks that algorithm is supported by keyID and returns the
+// MAC of message.
+func (f *fakeAWSKMS) computeMAC(keyID string, algorithm *string, message []byte) ([]byte, error) {
+	key, ok := f.hmacKeys[keyID]
+	if !ok {
+		return nil, f.unusableKeyError(keyID, "MAC")
+	}
+	if aws.StringValue(algorithm) != macAlgorithm(f.keySpecs[keyID]) {
+		return nil, awserr.New(kms.ErrCodeInvalidKeyUsageException, fmt.Sprintf("MAC algorithm %q is not supported by keyID %q", aws.StringValue(algorithm), keyID), nil)
+	}
+	if len(message) > 4096 {
+		return nil, validationError(fmt.Sprintf("message too long: %d bytes", len(message)))
+	}
+	mac := hmac.New(macHashes[*algorithm], key)
+	mac.Write(message)
+	return mac.Sum(nil), nil
 }
@@ -18,10 +18,15 @@ package fakeawskms
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
 	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/kms"
 )
 
@@ -248,6 +253,635 @@ func TestDecryptWithoutKeyId(t *testing.T) {
 	}
 }
 
+func TestEncryptDecryptWithContext(t *testing.T) {
+	fakeKMS, err := New([]string{validKeyID})
+	if err != nil {
+		t.Fatalf("New() err = %s, want nil", err)
+	}
+
+	plaintext := []byte("plaintext")
+	encRequest := &kms.EncryptInput{
+		KeyId:     aws.String(validKeyID),
+		Plaintext: plaintext,
+	}
+	encResponse, err := fakeKMS.EncryptWithContext(context.Background(), encRequest)
+	if err != nil {
+		t.Fatalf("fakeKMS.EncryptWithContext(ctx, encRequest) err = %s, want nil", err)
+	}
+	decRequest := &kms.DecryptInput{
+		KeyId:          aws.String(validKeyID),
+		CiphertextBlob: encResponse.CiphertextBlob,
+	}
+	decResponse, err := fakeKMS.DecryptWithContext(context.Background(), decRequest)
+	if err != nil {
+		t.Fatalf("fakeKMS.DecryptWithContext(ctx, decRequest) err = %s, want nil", err)
+	}
+	if !bytes.Equal(decResponse.Plaintext, plaintext) {
+		t.Fatalf("decResponse.Plaintext = %q, want %q", decResponse.Plaintext, plaintext)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fakeKMS.EncryptWithContext(ctx, encRequest); err == nil {
+		t.Error("fakeKMS.EncryptWithContext(canceledCtx, encRequest) err = nil, want not nil")
+	}
+	if _, err := fakeKMS.DecryptWithContext(ctx, decRequest); err == nil {
+		t.Error("fakeKMS.DecryptWithContext(canceledCtx, decRequest) err = nil, want not nil")
+	}
+}
+
+func TestReEncrypt(t *testing.T) {
+	fakeKMS, err := New([]string{validKeyID, validKeyID2})
+	if err != nil {
+		t.Fatalf("New() err = %s, want nil", err)
+	}
+	plaintext := []byte("plaintext")
+	context := map[string]*string{"name": aws.String("value")}
+	encResponse, err := fakeKMS.Encrypt(&kms.EncryptInput{
+		KeyId:             aws.String(validKeyID),
+		Plaintext:         plaintext,
+		EncryptionContext: context,
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.Encrypt() err = %s, want nil", err)
+	}
+	reEncRequest := &kms.ReEncryptInput{
+		CiphertextBlob:               encResponse.CiphertextBlob,
+		SourceKeyId:                  aws.String(validKeyID),
+		SourceEncryptionContext:      context,
+		DestinationKeyId:             aws.String(validKeyID2),
+		DestinationEncryptionContext: context,
+	}
+	reEncResponse, err := fakeKMS.ReEncrypt(reEncRequest)
+	if err != nil {
+		t.Fatalf("fakeKMS.ReEncrypt() err = %s, want nil", err)
+	}
+	if got := aws.StringValue(reEncResponse.KeyId); got != validKeyID2 {
+		t.Errorf("reEncResponse.KeyId = %q, want %q", got, validKeyID2)
+	}
+	decResponse, err := fakeKMS.Decrypt(&kms.DecryptInput{
+		KeyId:             aws.String(validKeyID2),
+		CiphertextBlob:    reEncResponse.CiphertextBlob,
+		EncryptionContext: context,
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.Decrypt() err = %s, want nil", err)
+	}
+	if !bytes.Equal(decResponse.Plaintext, plaintext) {
+		t.Errorf("decResponse.Plaintext = %q, want %q", decResponse.Plaintext, plaintext)
+	}
+
+	reEncRequest.SourceEncryptionContext = nil
+	if _, err := fakeKMS.ReEncrypt(reEncRequest); err == nil {
+		t.Error("fakeKMS.ReEncrypt() with the wrong source context err = nil, want not nil")
+	}
+}
+
+func TestCreateGrant(t *testing.T) {
+	fakeKMS, err := New([]string{validKeyID})
+	if err != nil {
+		t.Fatalf("New() err = %s, want nil", err)
+	}
+	grant, err := fakeKMS.CreateGrant(&kms.CreateGrantInput{
+		KeyId:            aws.String(validKeyID),
+		GranteePrincipal: aws.String("arn:aws:iam::111122223333:role/grantee"),
+		Operations:       aws.StringSlice([]string{kms.GrantOperationEncrypt}),
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.CreateGrant() err = %s, want nil", err)
+	}
+	if aws.StringValue(grant.GrantId) == "" || aws.StringValue(grant.GrantToken) == "" {
+		t.Errorf("fakeKMS.CreateGrant() = %v, want a grant ID and token", grant)
+	}
+
+	encRequest := &kms.EncryptInput{
+		KeyId:       aws.String(validKeyID),
+		Plaintext:   []byte("plaintext"),
+		GrantTokens: []*string{grant.GrantToken},
+	}
+	if _, err := fakeKMS.Encrypt(encRequest); err != nil {
+		t.Errorf("fakeKMS.Encrypt() with the grant token err = %s, want nil", err)
+	}
+	encRequest.GrantTokens = aws.StringSlice([]string{"unknown"})
+	_, err = fakeKMS.Encrypt(encRequest)
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != kms.ErrCodeInvalidGrantTokenException {
+		t.Errorf("fakeKMS.Encrypt() with an unknown grant token err = %v, want %s", err, kms.ErrCodeInvalidGrantTokenException)
+	}
+
+	if _, err := fakeKMS.CreateGrant(&kms.CreateGrantInput{KeyId: aws.String(validKeyID)}); err == nil {
+		t.Error("fakeKMS.CreateGrant() without grantee err = nil, want not nil")
+	}
+}
+
+func TestMultiRegionKeyReplicasShareKeyMaterial(t *testing.T) {
+	eastKeyID := "arn:aws:kms:us-east-1:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+	westKeyID := "arn:aws:kms:us-west-2:111122223333:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+	fakeKMS, err := New([]string{eastKeyID, westKeyID, validKeyID})
+	if err != nil {
+		t.Fatalf("New() err = %s, want nil", err)
+	}
+
+	encResponse, err := fakeKMS.Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(eastKeyID),
+		Plaintext: []byte("plaintext"),
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.Encrypt() err = %s, want nil", err)
+	}
+	if _, err := fakeKMS.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(westKeyID),
+		CiphertextBlob: encResponse.CiphertextBlob,
+	}); err != nil {
+		t.Errorf("fakeKMS.Decrypt() with replica err = %s, want nil", err)
+	}
+	if _, err := fakeKMS.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(validKeyID),
+		CiphertextBlob: encResponse.CiphertextBlob,
+	}); err == nil {
+		t.Error("fakeKMS.Decrypt() with other key err = nil, want not nil")
+	}
+}
+
+func TestGenerateDataKey(t *testing.T) {
+	fakeKMS, err := New([]string{validKeyID})
+	if err != nil {
+		t.Fatalf("New() err = %s, want nil", err)
+	}
+
+	genResponse, err := fakeKMS.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(validKeyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.GenerateDataKey() err = %s, want nil", err)
+	}
+	if len(genResponse.Plaintext) != 32 {
+		t.Errorf("len(genResponse.Plaintext) = %d, want 32", len(genResponse.Plaintext))
+	}
+	decResponse, err := fakeKMS.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(validKeyID),
+		CiphertextBlob: genResponse.CiphertextBlob,
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.Decrypt() err = %s, want nil", err)
+	}
+	if !bytes.Equal(decResponse.Plaintext, genResponse.Plaintext) {
+		t.Errorf("decResponse.Plaintext = %x, want %x", decResponse.Plaintext, genResponse.Plaintext)
+	}
+
+	withoutPlaintextResponse, err := fakeKMS.GenerateDataKeyWithoutPlaintext(&kms.GenerateDataKeyWithoutPlaintextInput{
+		KeyId:         aws.String(validKeyID),
+		NumberOfBytes: aws.Int64(16),
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.GenerateDataKeyWithoutPlaintext() err = %s, want nil", err)
+	}
+	decResponse, err = fakeKMS.Decrypt(&kms.DecryptInput{CiphertextBlob: withoutPlaintextResponse.CiphertextBlob})
+	if err != nil {
+		t.Fatalf("fakeKMS.Decrypt() err = %s, want nil", err)
+	}
+	if len(decResponse.Plaintext) != 16 {
+		t.Errorf("len(decResponse.Plaintext) = %d, want 16", len(decResponse.Plaintext))
+	}
+
+	if _, err := fakeKMS.GenerateDataKey(&kms.GenerateDataKeyInput{KeyId: aws.String(validKeyID)}); err == nil {
+		t.Error("fakeKMS.GenerateDataKey() without KeySpec and NumberOfBytes err = nil, want not nil")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{
+		validKeyID:  kms.KeySpecRsa2048,
+		validKeyID2: kms.KeySpecEccNistP256,
+	})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+
+	for _, tc := range []struct {
+		keyID            string
+		signingAlgorithm string
+	}{
+		{validKeyID, kms.SigningAlgorithmSpecRsassaPssSha256},
+		{validKeyID, kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256},
+		{validKeyID2, kms.SigningAlgorithmSpecEcdsaSha256},
+	} {
+		t.Run(tc.signingAlgorithm, func(t *testing.T) {
+			message := []byte("message")
+			signResponse, err := fakeKMS.Sign(&kms.SignInput{
+				KeyId:            aws.String(tc.keyID),
+				Message:          message,
+				SigningAlgorithm: aws.String(tc.signingAlgorithm),
+			})
+			if err != nil {
+				t.Fatalf("fakeKMS.Sign() err = %s, want nil", err)
+			}
+
+			digest := sha256.Sum256(message)
+			verifyRequest := &kms.VerifyInput{
+				KeyId:            aws.String(tc.keyID),
+				Message:          digest[:],
+				MessageType:      aws.String(kms.MessageTypeDigest),
+				Signature:        signResponse.Signature,
+				SigningAlgorithm: aws.String(tc.signingAlgorithm),
+			}
+			verifyResponse, err := fakeKMS.VerifyWithContext(context.Background(), verifyRequest)
+			if err != nil {
+				t.Fatalf("fakeKMS.VerifyWithContext() err = %s, want nil", err)
+			}
+			if !aws.BoolValue(verifyResponse.SignatureValid) {
+				t.Error("verifyResponse.SignatureValid = false, want true")
+			}
+
+			verifyRequest.Message = []byte("invalid digest of 32 bytes......")
+			if _, err := fakeKMS.Verify(verifyRequest); err == nil {
+				t.Error("fakeKMS.Verify(invalidRequest) err = nil, want not nil")
+			}
+		})
+	}
+}
+
+func TestSignWithInvalidParametersFails(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecEccNistP256})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	for _, tc := range []struct {
+		name             string
+		signingAlgorithm string
+		messageType      string
+		message          []byte
+		wantCode         string
+	}{
+		{"unsupported signing algorithm", kms.SigningAlgorithmSpecEcdsaSha384, kms.MessageTypeRaw, []byte("message"), kms.ErrCodeInvalidKeyUsageException},
+		{"RAW message too long", kms.SigningAlgorithmSpecEcdsaSha256, kms.MessageTypeRaw, make([]byte, 4097), "ValidationException"},
+		{"digest of the wrong size", kms.SigningAlgorithmSpecEcdsaSha256, kms.MessageTypeDigest, make([]byte, 20), "ValidationException"},
+		{"unknown message type", kms.SigningAlgorithmSpecEcdsaSha256, "UNKNOWN", []byte("message"), "ValidationException"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := fakeKMS.Sign(&kms.SignInput{
+				KeyId:            aws.String(validKeyID),
+				Message:          tc.message,
+				MessageType:      aws.String(tc.messageType),
+				SigningAlgorithm: aws.String(tc.signingAlgorithm),
+			})
+			var aerr awserr.Error
+			if !errors.As(err, &aerr) || aerr.Code() != tc.wantCode {
+				t.Errorf("fakeKMS.Sign() err = %v, want %s", err, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestSymmetricKeyCannotSign(t *testing.T) {
+	fakeKMS, err := New([]string{validKeyID})
+	if err != nil {
+		t.Fatalf("New() err = %s, want nil", err)
+	}
+	_, err = fakeKMS.Sign(&kms.SignInput{
+		KeyId:            aws.String(validKeyID),
+		Message:          []byte("message"),
+		SigningAlgorithm: aws.String(kms.SigningAlgorithmSpecEcdsaSha256),
+	})
+	if err == nil {
+		t.Error("fakeKMS.Sign() err = nil, want not nil")
+	}
+}
+
+func TestSignVerifyWithMissingParametersFail(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecEccNistP256})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	for _, tc := range []struct {
+		name             string
+		keyID            *string
+		signingAlgorithm *string
+	}{
+		{"without KeyId", nil, aws.String(kms.SigningAlgorithmSpecEcdsaSha256)},
+		{"without SigningAlgorithm", aws.String(validKeyID), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var aerr awserr.Error
+			_, err := fakeKMS.Sign(&kms.SignInput{
+				KeyId:            tc.keyID,
+				Message:          []byte("message"),
+				SigningAlgorithm: tc.signingAlgorithm,
+			})
+			if !errors.As(err, &aerr) || aerr.Code() != "ValidationException" {
+				t.Errorf("fakeKMS.Sign() err = %v, want ValidationException", err)
+			}
+			_, err = fakeKMS.Verify(&kms.VerifyInput{
+				KeyId:            tc.keyID,
+				Message:          []byte("message"),
+				Signature:        []byte("signature"),
+				SigningAlgorithm: tc.signingAlgorithm,
+			})
+			if !errors.As(err, &aerr) || aerr.Code() != "ValidationException" {
+				t.Errorf("fakeKMS.Verify() err = %v, want ValidationException", err)
+			}
+		})
+	}
+}
+
+func TestGetPublicKey(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecEccNistP384})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	resp, err := fakeKMS.GetPublicKey(&kms.GetPublicKeyInput{KeyId: aws.String(validKeyID)})
+	if err != nil {
+		t.Fatalf("fakeKMS.GetPublicKey() err = %s, want nil", err)
+	}
+	if _, err := x509.ParsePKIXPublicKey(resp.PublicKey); err != nil {
+		t.Errorf("x509.ParsePKIXPublicKey(resp.PublicKey) err = %s, want nil", err)
+	}
+	if got, want := aws.StringValue(resp.KeySpec), kms.KeySpecEccNistP384; got != want {
+		t.Errorf("resp.KeySpec = %q, want %q", got, want)
+	}
+	if got, want := aws.StringValueSlice(resp.SigningAlgorithms), []string{kms.SigningAlgorithmSpecEcdsaSha384}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("resp.SigningAlgorithms = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateVerifyMac(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecHmac256})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+
+	message := []byte("message")
+	genResponse, err := fakeKMS.GenerateMac(&kms.GenerateMacInput{
+		KeyId:        aws.String(validKeyID),
+		MacAlgorithm: aws.String(kms.MacAlgorithmSpecHmacSha256),
+		Message:      message,
+	})
+	if err != nil {
+		t.Fatalf("fakeKMS.GenerateMac() err = %s, want nil", err)
+	}
+	verifyRequest := &kms.VerifyMacInput{
+		KeyId:        aws.String(validKeyID),
+		Mac:          genResponse.Mac,
+		MacAlgorithm: aws.String(kms.MacAlgorithmSpecHmacSha256),
+		Message:      message,
+	}
+	verifyResponse, err := fakeKMS.VerifyMacWithContext(context.Background(), verifyRequest)
+	if err != nil {
+		t.Fatalf("fakeKMS.VerifyMacWithContext() err = %s, want nil", err)
+	}
+	if !aws.BoolValue(verifyResponse.MacValid) {
+		t.Error("verifyResponse.MacValid = false, want true")
+	}
+
+	verifyRequest.Message = []byte("otherMessage")
+	if _, err := fakeKMS.VerifyMac(verifyRequest); err == nil {
+		t.Error("fakeKMS.VerifyMac(otherMessage) err = nil, want not nil")
+	}
+	verifyRequest.Message = message
+	verifyRequest.MacAlgorithm = aws.String(kms.MacAlgorithmSpecHmacSha512)
+	if _, err := fakeKMS.VerifyMac(verifyRequest); err == nil {
+		t.Error("fakeKMS.VerifyMac(HMAC_SHA_512) err = nil, want not nil")
+	}
+}
+
+func TestGenerateMacWithInvalidParametersFails(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecHmac256})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	for _, tc := range []struct {
+		name         string
+		macAlgorithm string
+		message      []byte
+		wantCode     string
+	}{
+		{"unsupported MAC algorithm", kms.MacAlgorithmSpecHmacSha512, []byte("message"), kms.ErrCodeInvalidKeyUsageException},
+		{"message too long", kms.MacAlgorithmSpecHmacSha256, make([]byte, 4097), "ValidationException"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := fakeKMS.GenerateMac(&kms.GenerateMacInput{
+				KeyId:        aws.String(validKeyID),
+				MacAlgorithm: aws.String(tc.macAlgorithm),
+				Message:      tc.message,
+			})
+			var aerr awserr.Error
+			if !errors.As(err, &aerr) || aerr.Code() != tc.wantCode {
+				t.Errorf("fakeKMS.GenerateMac() err = %v, want %s", err, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestGenerateVerifyMacWithoutKeyIDFails(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecHmac256})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	var aerr awserr.Error
+	_, err = fakeKMS.GenerateMac(&kms.GenerateMacInput{
+		MacAlgorithm: aws.String(kms.MacAlgorithmSpecHmacSha256),
+		Message:      []byte("message"),
+	})
+	if !errors.As(err, &aerr) || aerr.Code() != "ValidationException" {
+		t.Errorf("fakeKMS.GenerateMac() err = %v, want ValidationException", err)
+	}
+	_, err = fakeKMS.VerifyMac(&kms.VerifyMacInput{
+		Mac:          []byte("mac"),
+		MacAlgorithm: aws.String(kms.MacAlgorithmSpecHmacSha256),
+		Message:      []byte("message"),
+	})
+	if !errors.As(err, &aerr) || aerr.Code() != "ValidationException" {
+		t.Errorf("fakeKMS.VerifyMac() err = %v, want ValidationException", err)
+	}
+}
+
+func TestDescribeKey(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{
+		validKeyID:  kms.KeySpecHmac384,
+		validKeyID2: kms.KeySpecSymmetricDefault,
+	})
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	for _, tc := range []struct {
+		keyID    string
+		keySpec  string
+		keyUsage string
+	}{
+		{validKeyID, kms.KeySpecHmac384, kms.KeyUsageTypeGenerateVerifyMac},
+		{validKeyID2, kms.KeySpecSymmetricDefault, kms.KeyUsageTypeEncryptDecrypt},
+	} {
+		resp, err := fakeKMS.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(tc.keyID)})
+		if err != nil {
+			t.Fatalf("fakeKMS.DescribeKey(%q) err = %s, want nil", tc.keyID, err)
+		}
+		if got := aws.StringValue(resp.KeyMetadata.KeySpec); got != tc.keySpec {
+			t.Errorf("KeySpec = %q, want %q", got, tc.keySpec)
+		}
+		if got := aws.StringValue(resp.KeyMetadata.KeyUsage); got != tc.keyUsage {
+			t.Errorf("KeyUsage = %q, want %q", got, tc.keyUsage)
+		}
+	}
+	if _, err := fakeKMS.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String("unknown")}); err == nil {
+		t.Error("fakeKMS.DescribeKey(unknown) err = nil, want not nil")
+	}
+}
+
+func TestKeyIDsAndAliases(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecSymmetricDefault}, WithAlias("alias/my-key", validKeyID))
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	for _, keyID := range []string{
+		validKeyID,
+		"1234abcd-12ab-34cd-56ef-1234567890ab",
+		"alias/my-key",
+		"arn:aws:kms:us-west-2:111122223333:alias/my-key",
+	} {
+		resp, err := fakeKMS.Encrypt(&kms.EncryptInput{KeyId: aws.String(keyID), Plaintext: []byte("plaintext")})
+		if err != nil {
+			t.Fatalf("fakeKMS.Encrypt(%q) err = %s, want nil", keyID, err)
+		}
+		if got := aws.StringValue(resp.KeyId); got != validKeyID {
+			t.Errorf("fakeKMS.Encrypt(%q).KeyId = %q, want %q", keyID, got, validKeyID)
+		}
+		decResp, err := fakeKMS.Decrypt(&kms.DecryptInput{KeyId: aws.String(validKeyID), CiphertextBlob: resp.CiphertextBlob})
+		if err != nil {
+			t.Fatalf("fakeKMS.Decrypt() err = %s, want nil", err)
+		}
+		if !bytes.Equal(decResp.Plaintext, []byte("plaintext")) {
+			t.Errorf("decResp.Plaintext = %q, want %q", decResp.Plaintext, "plaintext")
+		}
+	}
+	for _, keyID := range []string{
+		"alias/unknown",
+		"arn:aws:kms:us-east-1:111122223333:alias/my-key",
+	} {
+		if _, err := fakeKMS.Encrypt(&kms.EncryptInput{KeyId: aws.String(keyID), Plaintext: []byte("plaintext")}); err == nil {
+			t.Errorf("fakeKMS.Encrypt(%q) err = nil, want not nil", keyID)
+		}
+	}
+}
+
+func TestWithAliasFailsWithUnknownKey(t *testing.T) {
+	if _, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecSymmetricDefault}, WithAlias("alias/my-key", validKeyID2)); err == nil {
+		t.Error("NewWithKeySpecs(_, WithAlias(_, unknown)) err = nil, want not nil")
+	}
+	if _, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecSymmetricDefault}, WithAlias("my-key", validKeyID)); err == nil {
+		t.Error("NewWithKeySpecs(_, WithAlias(\"my-key\", _)) err = nil, want not nil")
+	}
+}
+
+func TestListAliases(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{
+		validKeyID:  kms.KeySpecSymmetricDefault,
+		validKeyID2: kms.KeySpecSymmetricDefault,
+	}, WithAlias("alias/a", validKeyID), WithAlias("alias/b", validKeyID2))
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	resp, err := fakeKMS.ListAliases(&kms.ListAliasesInput{})
+	if err != nil {
+		t.Fatalf("fakeKMS.ListAliases() err = %s, want nil", err)
+	}
+	if len(resp.Aliases) != 2 {
+		t.Fatalf("len(resp.Aliases) = %d, want 2", len(resp.Aliases))
+	}
+	a := resp.Aliases[0]
+	if got, want := aws.StringValue(a.AliasArn), "arn:aws:kms:us-west-2:111122223333:alias/a"; got != want {
+		t.Errorf("AliasArn = %q, want %q", got, want)
+	}
+	if got, want := aws.StringValue(a.TargetKeyId), "1234abcd-12ab-34cd-56ef-1234567890ab"; got != want {
+		t.Errorf("TargetKeyId = %q, want %q", got, want)
+	}
+
+	resp, err = fakeKMS.ListAliases(&kms.ListAliasesInput{KeyId: aws.String(validKeyID2)})
+	if err != nil {
+		t.Fatalf("fakeKMS.ListAliases() err = %s, want nil", err)
+	}
+	if len(resp.Aliases) != 1 || aws.StringValue(resp.Aliases[0].AliasName) != "alias/b" {
+		t.Errorf("fakeKMS.ListAliases(validKeyID2).Aliases = %v, want alias/b only", resp.Aliases)
+	}
+}
+
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func TestErrorCodes(t *testing.T) {
+	disabledKeyID := "arn:aws:kms:us-west-2:111122223333:key/disabled"
+	pendingDeletionKeyID := "arn:aws:kms:us-west-2:111122223333:key/pending-deletion"
+	deniedKeyID := "arn:aws:kms:us-west-2:111122223333:key/denied"
+	fakeKMS, err := NewWithKeySpecs(map[string]string{
+		validKeyID:           kms.KeySpecSymmetricDefault,
+		validKeyID2:          kms.KeySpecHmac256,
+		disabledKeyID:        kms.KeySpecSymmetricDefault,
+		pendingDeletionKeyID: kms.KeySpecSymmetricDefault,
+		deniedKeyID:          kms.KeySpecSymmetricDefault,
+	}, WithKeyState(disabledKeyID, kms.KeyStateDisabled), WithKeyState(pendingDeletionKeyID, kms.KeyStatePendingDeletion), WithAccessDenied(deniedKeyID))
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	encrypt := func(keyID string) error {
+		_, err := fakeKMS.Encrypt(&kms.EncryptInput{KeyId: aws.String(keyID), Plaintext: []byte("plaintext")})
+		return err
+	}
+	for _, tc := range []struct {
+		name     string
+		err      error
+		wantCode string
+	}{
+		{"unknown key", encrypt("arn:aws:kms:us-west-2:111122223333:key/unknown"), kms.ErrCodeNotFoundException},
+		{"key usage", encrypt(validKeyID2), kms.ErrCodeInvalidKeyUsageException},
+		{"disabled", encrypt(disabledKeyID), kms.ErrCodeDisabledException},
+		{"pending deletion", encrypt(pendingDeletionKeyID), kms.ErrCodeInvalidStateException},
+		{"access denied", encrypt(deniedKeyID), "AccessDeniedException"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := errorCode(tc.err); got != tc.wantCode {
+				t.Errorf("err = %v, want code %s", tc.err, tc.wantCode)
+			}
+		})
+	}
+
+	_, err = fakeKMS.Decrypt(&kms.DecryptInput{KeyId: aws.String(validKeyID), CiphertextBlob: []byte("invalid")})
+	if got := errorCode(err); got != kms.ErrCodeInvalidCiphertextException {
+		t.Errorf("fakeKMS.Decrypt(invalid) err = %v, want code %s", err, kms.ErrCodeInvalidCiphertextException)
+	}
+	_, err = fakeKMS.VerifyMac(&kms.VerifyMacInput{KeyId: aws.String(validKeyID2), MacAlgorithm: aws.String(kms.MacAlgorithmSpecHmacSha256), Message: []byte("message"), Mac: []byte("invalid")})
+	if got := errorCode(err); got != kms.ErrCodeKMSInvalidMacException {
+		t.Errorf("fakeKMS.VerifyMac(invalid) err = %v, want code %s", err, kms.ErrCodeKMSInvalidMacException)
+	}
+
+	resp, err := fakeKMS.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(disabledKeyID)})
+	if err != nil {
+		t.Fatalf("fakeKMS.DescribeKey(disabledKeyID) err = %s, want nil", err)
+	}
+	if got := aws.StringValue(resp.KeyMetadata.KeyState); got != kms.KeyStateDisabled {
+		t.Errorf("KeyState = %q, want %q", got, kms.KeyStateDisabled)
+	}
+}
+
+func TestWithThrottledRequests(t *testing.T) {
+	fakeKMS, err := NewWithKeySpecs(map[string]string{validKeyID: kms.KeySpecSymmetricDefault}, WithThrottledRequests(2))
+	if err != nil {
+		t.Fatalf("NewWithKeySpecs() err = %s, want nil", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := fakeKMS.Encrypt(&kms.EncryptInput{KeyId: aws.String(validKeyID), Plaintext: []byte("plaintext")})
+		if i < 2 && errorCode(err) != "ThrottlingException" {
+			t.Errorf("request %d: err = %v, want ThrottlingException", i, err)
+		}
+		if i == 2 && err != nil {
+			t.Errorf("request %d: err = %v, want nil", i, err)
+		}
+	}
+}
+
 func TestSerializeContext(t *testing.T) {
 	uvw := "uvw"
 	xyz := "xyz"